@@ -1,50 +1,178 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 
-	"kormsen.com/machine-ui/pkg/sandbox/api"
-	"kormsen.com/machine-ui/pkg/sandbox/discovery"
-	"kormsen.com/machine-ui/pkg/sandbox/renderer"
-	// "kormsen.com/machine-ui/pkg/models" - Models are used by discovery and api packages, not directly in main
+	"kormsen.com/machine-ui/pkg/sandbox"
+	"kormsen.com/machine-ui/pkg/sandbox/config"
+	"kormsen.com/machine-ui/pkg/sandbox/parity"
 )
 
-const (
-	componentsDir = "static/components"     // Relative to project root
-	templateDir   = "cmd/sandbox/templates" // Relative to project root
-	staticDir     = "static"                // Relative to project root
-	listenAddr    = ":8080"
-)
+const exportDir = "dist" // Default output directory of the export command
 
+// main runs the development server, or with "export" as the first argument writes the
+// sandbox as a static site. Both take the configuration flags, see -help:
+//
+//	go run ./cmd/sandbox                  # serve on :8080 with live reload
+//	go run ./cmd/sandbox -listen :3000    # serve on another port
+//	go run ./cmd/sandbox export -out dist # write every story to ./dist
+//	go run ./cmd/sandbox snapshot         # compare SSR stories with their __snapshots__
+//	go run ./cmd/sandbox snapshot -update # rewrite the snapshots
+//	go run ./cmd/sandbox parity           # compare SSR templates with the Preact renders
+//
+// To run the sandbox inside another Go application, use package sandbox directly.
 func main() {
-	// Discover stories
-	discoveredComponents, err := discovery.DiscoverStories(componentsDir)
-	if err != nil {
-		log.Printf("Warning: Error discovering stories from %s: %v", componentsDir, err)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			runExport(os.Args[2:])
+			return
+		case "snapshot":
+			runSnapshot(os.Args[2:])
+			return
+		case "parity":
+			runParity(os.Args[2:])
+			return
+		}
+	}
+	serve(os.Args[1:])
+}
+
+// loadConfig parses args and resolves the configuration, exiting on invalid settings.
+// define adds flags of its own, such as the export command's -out.
+func loadConfig(name string, args []string, define func(flags *flag.FlagSet)) config.Config {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	configFlags := config.Bind(flags)
+	if define != nil {
+		define(flags)
 	}
-	if len(discoveredComponents) == 0 {
-		log.Println("No component stories were found. The sidebar will be empty.")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		log.Fatalf("Unexpected arguments: %v (see -help)", flags.Args())
 	}
 
-	// Define a slice of strings for the template directories
-	templateDirs := []string{"cmd/sandbox/templates", "static"}
+	cfg, err := configFlags.Load(os.LookupEnv)
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	return cfg
+}
 
-	// Pass the slice to LoadTemplates
-	templateSet, err := renderer.LoadTemplates(templateDirs)
+func serve(args []string) {
+	cfg := loadConfig("sandbox", args, nil)
+	sb, err := sandbox.New(sandbox.Options{Config: cfg})
 	if err != nil {
-		log.Fatalf("Failed to load templates: %v", err)
+		log.Fatal(err)
 	}
 
-	// Create the main router
-	// Note: api.NewRouter expects []models.ComponentGroup, which discovery.DiscoverStories returns.
-	// The models package is imported by the api and discovery packages themselves.
-	mainRouter := api.NewRouter(staticDir, templateSet, discoveredComponents)
+	// Re-run discovery and template parsing whenever a component or template file changes
+	go sb.Watch(context.Background())
 
 	// Start the HTTP server
-	log.Printf("Sandbox application starting. Listening on http://localhost%s ...", listenAddr)
-	err = http.ListenAndServe(listenAddr, mainRouter)
+	log.Printf("Sandbox application starting. Listening on %s ...", listenURL(cfg.Listen))
+	err = http.ListenAndServe(cfg.Listen, sb)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
 }
+
+// listenURL turns a listen address like ":8080" into a URL to open in the browser.
+func listenURL(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return "http://localhost" + addr
+	}
+	return "http://" + addr
+}
+
+// runExport renders every story in every render mode and theme to a directory that can be
+// opened from any static host.
+func runExport(args []string) {
+	var outDir *string
+	cfg := loadConfig("export", args, func(flags *flag.FlagSet) {
+		outDir = flags.String("out", exportDir, "directory to write the static site to")
+	})
+	sb, err := sandbox.New(sandbox.Options{Config: cfg})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	summary, err := sb.Export(*outDir)
+	if err != nil {
+		log.Fatalf("Export failed: %v", err)
+	}
+	log.Printf("Exported %s to %s", summary, *outDir)
+}
+
+// runSnapshot checks the HTML of every SSR story against its snapshot and exits with status 1
+// if any differ, are missing or fail to render, so it can run in CI. With -update it writes
+// the snapshots instead.
+func runSnapshot(args []string) {
+	var update *bool
+	cfg := loadConfig("snapshot", args, func(flags *flag.FlagSet) {
+		update = flags.Bool("update", false, "write missing and changed snapshots and delete obsolete ones")
+	})
+	sb, err := sandbox.New(sandbox.Options{Config: cfg})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	summary, err := sb.Snapshot(*update, os.Stdout)
+	if err != nil {
+		log.Fatalf("Snapshot failed: %v", err)
+	}
+	log.Printf("Snapshots: %s", summary)
+	if summary.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// runParity renders every story that has an SSR template with both the template and its
+// Preact component, prints the differences and exits with status 1 if any story differs or
+// fails to render. -component limits the check to one component.
+func runParity(args []string) {
+	var componentName *string
+	cfg := loadConfig("parity", args, func(flags *flag.FlagSet) {
+		componentName = flags.String("component", "", "only check the stories of this component")
+	})
+	sb, err := sandbox.New(sandbox.Options{Config: cfg})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	reports := sb.Parity(*componentName)
+	for _, report := range reports {
+		switch {
+		case report.Error != "":
+			fmt.Printf("ERROR %s/%s: %s\n", report.Component, report.StoryKey, report.Error)
+		case len(report.Differences) > 0:
+			fmt.Printf("DIFF  %s/%s\n", report.Component, report.StoryKey)
+			for _, difference := range report.Differences {
+				detail := difference.Detail
+				if detail != "" {
+					detail = " " + detail
+				}
+				fmt.Printf("      %s%s at %s\n", difference.Kind, detail, difference.Path)
+				if difference.SSR != "" {
+					fmt.Printf("        ssr: %s\n", difference.SSR)
+				}
+				if difference.CSR != "" {
+					fmt.Printf("        csr: %s\n", difference.CSR)
+				}
+			}
+		default:
+			fmt.Printf("ok    %s/%s\n", report.Component, report.StoryKey)
+		}
+	}
+
+	failed := parity.Failed(reports)
+	log.Printf("Parity: %d stories compared, %d differ", len(reports), failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,24 @@
+// Package machineui carries the sandbox's built-in files, so the sandbox binary runs from
+// any directory: the UI templates, the sandbox modules with Preact and htm, and a default
+// import map for them. A project only brings its own components, see cmd/sandbox.
+//
+// The files are embedded from their places in this repository, and Files keeps that layout
+// (cmd/sandbox/templates, static/modules, importmap.json). Files on disk at the same paths
+// take precedence when the sandbox stacks a project over Files, so the UI can still be
+// worked on with live reload.
+package machineui
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// The templates need all: because their names start with an underscore.
+//
+//go:embed all:cmd/sandbox/templates static/modules importmap.json
+var files embed.FS
+
+// Files returns the embedded files, rooted like this repository.
+func Files() fs.FS {
+	return files
+}
@@ -5,6 +5,15 @@ go 1.23.0
 toolchain go1.23.9
 
 require (
-	github.com/evanw/esbuild v0.25.4 // indirect
-	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
+	github.com/evanw/esbuild v0.25.4
+	github.com/grafana/sobek v0.0.0-20260429085637-a66d4790012b
+	golang.org/x/net v0.42.0
+)
+
+require (
+	github.com/dlclark/regexp2 v1.12.0 // indirect
+	github.com/go-sourcemap/sourcemap v2.1.4+incompatible // indirect
+	github.com/google/pprof v0.0.0-20230207041349-798e818bf904 // indirect
+	golang.org/x/sys v0.34.0 // indirect
+	golang.org/x/text v0.27.0 // indirect
 )
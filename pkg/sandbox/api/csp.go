@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Every page and frame gets a fresh nonce, which the templates put on their inline <script>,
+// <style> and import map elements, so the sandbox works under a Content-Security-Policy
+// without 'unsafe-inline'. With AppHandlers.CSP set, the policy is sent as well, and browsers
+// report violations to /sandbox/api/csp-report, where they are logged. That shows whether
+// the project's components work under the CSP of the production site.
+
+// DefaultCSPPolicy allows the sandbox's own scripts, styles and frames and nothing inline but
+// nonce-carrying elements and style attributes. "{nonce}" is replaced with the request's nonce.
+const DefaultCSPPolicy = "default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self' 'nonce-{nonce}'; " +
+	"style-src-attr 'unsafe-inline'; img-src 'self' data:; font-src 'self' data:; connect-src 'self'; " +
+	"frame-src 'self'; object-src 'none'; base-uri 'self'; form-action 'self'"
+
+// maxCSPReportSize bounds the body of a violation report.
+const maxCSPReportSize = 64 << 10
+
+// CSP is the Content-Security-Policy the sandbox sends with its pages and frames.
+type CSP struct {
+	Policy     string // The policy; "{nonce}" is replaced with the request's nonce. Empty means DefaultCSPPolicy
+	ReportOnly bool   // Send Content-Security-Policy-Report-Only: violations are reported, not blocked
+}
+
+type cspNonceKey struct{}
+
+// cspNonce returns the nonce of a request served through withCSP, or "".
+func cspNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+// withCSP gives a page or frame handler a nonce (see cspNonce) and sends the policy.
+//
+// Mach partial responses are swapped into a page that was loaded with another nonce, and the
+// browser checks their inline styles against that page's policy. They reuse the page's nonce,
+// which the client sends in X-Mach-Nonce; every tab has its own.
+func (h *AppHandlers) withCSP(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current := h.snapshot()
+		nonce := r.Header.Get(MachNonceHeader)
+		if r.Header.Get(MachRequestHeader) != "true" || !validNonce(nonce) {
+			nonce = newNonce()
+		}
+
+		if csp := current.CSP; csp != nil && !isStaticExport(r) {
+			policy := csp.Policy
+			if policy == "" {
+				policy = DefaultCSPPolicy
+			}
+			policy = strings.ReplaceAll(policy, "{nonce}", nonce)
+			if !strings.Contains(policy, "report-uri") {
+				policy += "; report-uri " + current.BasePath + "/sandbox/api/csp-report"
+			}
+			header := "Content-Security-Policy"
+			if csp.ReportOnly {
+				header = "Content-Security-Policy-Report-Only"
+			}
+			w.Header().Set(header, policy)
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, nonce)))
+	}
+}
+
+// newNonce returns 128 random bits, base64 encoded.
+func newNonce() string {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		panic("crypto/rand: " + err.Error()) // Never happens on supported platforms
+	}
+	return base64.RawURLEncoding.EncodeToString(nonce)
+}
+
+// validNonce reports whether a header value has the form of a nonce from newNonce.
+func validNonce(value string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	return err == nil && len(decoded) == 16
+}
+
+// CSPReport serves /sandbox/api/csp-report, the report-uri of the policy: it logs the
+// violations browsers post, in the application/csp-report format.
+func (h *AppHandlers) CSPReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var report struct {
+		Body struct {
+			DocumentURI        string `json:"document-uri"`
+			ViolatedDirective  string `json:"violated-directive"`
+			EffectiveDirective string `json:"effective-directive"`
+			BlockedURI         string `json:"blocked-uri"`
+			SourceFile         string `json:"source-file"`
+			LineNumber         int    `json:"line-number"`
+			Sample             string `json:"script-sample"`
+			Disposition        string `json:"disposition"`
+		} `json:"csp-report"`
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCSPReportSize))
+	if err == nil {
+		err = json.Unmarshal(body, &report)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid CSP report: "+err.Error())
+		return
+	}
+	violation := report.Body
+	directive := violation.EffectiveDirective
+	if directive == "" {
+		directive = violation.ViolatedDirective
+	}
+	location := violation.SourceFile
+	if location != "" && violation.LineNumber > 0 {
+		location += ":" + strconv.Itoa(violation.LineNumber)
+	}
+	log.Printf("CSP %s: %s blocked %q on %s %s %q", violation.Disposition, directive, violation.BlockedURI, violation.DocumentURI, location, violation.Sample)
+	w.WriteHeader(http.StatusNoContent)
+}
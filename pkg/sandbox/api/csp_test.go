@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCSPNonceOfPartialResponses(t *testing.T) {
+	h := &AppHandlers{CSP: &CSP{}}
+	handler := h.withCSP(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<style nonce="` + cspNonce(r) + `"></style>`))
+	})
+	load := func(header http.Header) (nonce, policy string) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/sandbox/button/primary", nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if cookies := w.Result().Cookies(); len(cookies) > 0 {
+			t.Errorf("response sets cookies %v", cookies)
+		}
+		body := w.Body.String()
+		nonce = strings.TrimSuffix(strings.TrimPrefix(body, `<style nonce="`), `"></style>`)
+		if !validNonce(nonce) {
+			t.Fatalf("response %q has no valid nonce", body)
+		}
+		return nonce, w.Header().Get("Content-Security-Policy")
+	}
+
+	// Two tabs load the page, each with a nonce of its own
+	first, _ := load(nil)
+	second, _ := load(nil)
+	if first == second {
+		t.Fatalf("both pages have nonce %q", first)
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   string // The nonce of the response; "" for a new one
+	}{
+		{name: "partial of the first page", header: http.Header{MachRequestHeader: {"true"}, MachNonceHeader: {first}}, want: first},
+		{name: "partial of the second page", header: http.Header{MachRequestHeader: {"true"}, MachNonceHeader: {second}}, want: second},
+		{name: "partial without nonce", header: http.Header{MachRequestHeader: {"true"}}},
+		{name: "partial with invalid nonce", header: http.Header{MachRequestHeader: {"true"}, MachNonceHeader: {"'unsafe-inline'"}}},
+		{name: "nonce without partial", header: http.Header{MachNonceHeader: {first}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nonce, policy := load(test.header)
+			if test.want != "" && nonce != test.want {
+				t.Errorf("nonce = %q, want %q", nonce, test.want)
+			}
+			if test.want == "" && (nonce == first || nonce == second) {
+				t.Errorf("nonce = %q, want a new one", nonce)
+			}
+			if !strings.Contains(policy, "'nonce-"+nonce+"'") {
+				t.Errorf("policy %q does not allow nonce %q", policy, nonce)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+)
+
+// ViewDiagnostics renders the discovery diagnostics report at /sandbox/__diagnostics.
+// `?component=name` limits the report to one component. The report is returned as JSON
+// when the request asks for it with `?format=json` or an `Accept: application/json` header,
+// so editors and CI scripts can consume the same data as the page.
+func (h *AppHandlers) ViewDiagnostics(w http.ResponseWriter, r *http.Request) {
+	h = h.snapshot()
+	componentFilter := r.URL.Query().Get("component")
+
+	filtered := h.Diagnostics
+	if componentFilter != "" {
+		filtered = nil
+		for _, diagnostic := range h.Diagnostics {
+			if diagnostic.Component == componentFilter {
+				filtered = append(filtered, diagnostic)
+			}
+		}
+	}
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		if filtered == nil {
+			filtered = models.Diagnostics{} // Encode as [] rather than null
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(filtered); err != nil {
+			log.Printf("ViewDiagnostics: Error encoding diagnostics JSON: %v", err)
+		}
+		return
+	}
+
+	// Components are copied so IsSelected can be set without touching the shared slice
+	pageComponents := make([]models.ComponentGroup, len(h.Components))
+	copy(pageComponents, h.Components)
+	for i := range pageComponents {
+		pageComponents[i].IsSelected = pageComponents[i].Name == componentFilter
+	}
+
+	currentTheme := h.theme(r.URL.Query().Get("theme"))
+
+	pageTitle := "Diagnostics"
+	if componentFilter != "" {
+		pageTitle = "Diagnostics: " + componentFilter
+	}
+
+	data := models.PageData{
+		Title:                 pageTitle,
+		Components:            pageComponents,
+		BasePath:              h.BasePath,
+		StaticBaseURL:         h.BasePath + "/static",
+		Diagnostics:           h.Diagnostics,
+		ImportMapJSON:         h.importMapHTML(),
+		StaticExport:          isStaticExport(r),
+		LiveReload:            h.liveReload(r),
+		Nonce:                 cspNonce(r),
+		Theme:                 currentTheme,
+		CurrentPath:           h.BasePath + r.URL.Path,
+		CanClientSideNavigate: true,
+		RenderMode:            "csr", // Used by the navigation links
+		IsDiagnosticsPage:     true,
+		DiagnosticsFilter:     componentFilter,
+		FilteredDiagnostics:   filtered,
+	}
+
+	// No toolbar or args editor: the report replaces the whole main area (see full-body-content)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := w.Write([]byte("<!DOCTYPE html>\n<html lang=\"en\">")); err != nil {
+		log.Printf("ViewDiagnostics: Error writing initial HTML: %v", err)
+		return
+	}
+	if err := h.Templates.ExecuteTemplate(w, "_document_head", data); err != nil {
+		log.Printf("ViewDiagnostics: Error executing _document_head template: %v", err)
+		_, _ = w.Write([]byte("</html>"))
+		return
+	}
+	if err := h.Templates.ExecuteTemplate(w, "full-body-content", data); err != nil {
+		log.Printf("ViewDiagnostics: Error executing full-body-content template: %v", err)
+		_, _ = w.Write([]byte("</html>"))
+		return
+	}
+	if _, err := w.Write([]byte("</html>")); err != nil {
+		log.Printf("ViewDiagnostics: Error writing closing HTML tag: %v", err)
+	}
+}
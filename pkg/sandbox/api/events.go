@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Reload scopes tell the browser how much to re-render after a change.
+const (
+	ReloadScopePage  = "page"  // Stories, args or sandbox templates changed: reload the manager page
+	ReloadScopeFrame = "frame" // Only component sources changed: reload the story iframe
+)
+
+// sseKeepAliveInterval is how often an idle event stream gets a comment line, so proxies
+// and browsers do not time the connection out.
+const sseKeepAliveInterval = 25 * time.Second
+
+// ReloadEvent is sent to connected browsers after the sandbox reloaded files.
+type ReloadEvent struct {
+	Version int      `json:"version"` // Incremented on every reload
+	Scope   string   `json:"scope"`   // ReloadScopePage or ReloadScopeFrame
+	Changed []string `json:"changed"` // Changed files, for logging in the browser console
+}
+
+// ReloadEvents fans reload notifications out to every connected Server-Sent Events client.
+type ReloadEvents struct {
+	serverID string // Changes when the process restarts, so clients reconnecting to a new server reload
+
+	mu      sync.Mutex
+	version int
+	clients map[chan ReloadEvent]struct{}
+}
+
+// NewReloadEvents returns an event hub with no connected clients.
+func NewReloadEvents() *ReloadEvents {
+	return &ReloadEvents{
+		serverID: strconv.FormatInt(time.Now().UnixNano(), 36),
+		clients:  make(map[chan ReloadEvent]struct{}),
+	}
+}
+
+// Publish sends a reload event to every connected client. Clients that are not keeping up
+// miss the event rather than blocking the watcher; they only ever need the latest one.
+func (e *ReloadEvents) Publish(scope string, changed []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.version++
+	event := ReloadEvent{Version: e.version, Scope: scope, Changed: changed}
+	for client := range e.clients {
+		select {
+		case client <- event:
+		default:
+		}
+	}
+	log.Printf("ReloadEvents: published %s reload #%d to %d clients", scope, e.version, len(e.clients))
+}
+
+func (e *ReloadEvents) subscribe() chan ReloadEvent {
+	client := make(chan ReloadEvent, 1)
+	e.mu.Lock()
+	e.clients[client] = struct{}{}
+	e.mu.Unlock()
+	return client
+}
+
+func (e *ReloadEvents) unsubscribe(client chan ReloadEvent) {
+	e.mu.Lock()
+	delete(e.clients, client)
+	e.mu.Unlock()
+}
+
+// ServeHTTP streams reload events as Server-Sent Events. The first event, "hello", carries the
+// server ID; live-reload.js reloads when it reconnects and the ID changed (the server restarted).
+func (e *ReloadEvents) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	client := e.subscribe()
+	defer e.unsubscribe(client)
+
+	fmt.Fprintf(w, "event: hello\ndata: {\"serverId\":%q}\n\n", e.serverID)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event := <-client:
+			payload, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("ReloadEvents: Error marshalling event: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: reload\ndata: %s\n\n", payload); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
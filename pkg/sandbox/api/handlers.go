@@ -1,39 +1,273 @@
 package api
 
 import (
-	"bytes"         // Added for capturing template output to a buffer
-	"encoding/json" // Added for marshalling sandbox config
-
-	// Added for errors.New
+	"bytes"
+	"encoding/json"
 	"fmt"
-	"slices"
-
-	// Added for Sprintf
 	"html/template"
+	"io/fs"
 	"log"
+	"maps"
 	"net/http"
-	"net/url" // Added for URL manipulation
-
-	// Added for parsing numbers from query
-	"strconv"
+	"net/url"
+	"slices"
 	"strings"
+	"sync"
 
-	"kormsen.com/machine-ui/pkg/sandbox/models" // Updated path
-	// Updated path
-	// Added for slices.Contains
+	"kormsen.com/machine-ui/pkg/sandbox/args"
+	"kormsen.com/machine-ui/pkg/sandbox/importmap"
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+	"kormsen.com/machine-ui/pkg/sandbox/themes"
+	"kormsen.com/machine-ui/pkg/sandbox/viewport"
 )
 
 // AppHandlers holds dependencies for HTTP handlers, such as the list of discovered components
 // and the parsed HTML templates.
 // It's a good practice to pass dependencies to handlers explicitly rather than using globals.
+//
+// Components, Templates, Diagnostics, ImportMap and Themes are replaced as a whole by Update when files
+// change. Handlers never read them from the shared value directly: they start with
+// `h = h.snapshot()` so one request sees one consistent set, even if a reload happens halfway through it.
 type AppHandlers struct {
-	Components []models.ComponentGroup // A cache of discovered components
-	Templates  *template.Template
+	Components  []models.ComponentGroup // A cache of discovered components
+	Templates   *template.Template
+	Diagnostics models.Diagnostics   // Problems found while discovering stories and loading templates
+	ImportMap   *importmap.ImportMap // Rendered into the page and frame heads
+	Events      *ReloadEvents        // Notifies browsers after Update; nil disables live reload
+	Hydration   *HydrationReports    // Mismatches reported by hydrate frames; nil discards them
+	RenderModes *RenderModeRegistry  // The render modes offered in the toolbar and served as frames, see RenderMode
+	Themes      []string             // The registered themes, see package themes; empty means themes.Builtin
+	Viewports   []viewport.Viewport  // The story frame sizes offered in the toolbar; empty means viewport.Presets
+
+	// Used when a URL names no theme or render mode (or an unknown one). An empty or
+	// unregistered DefaultTheme means "light", or the first theme if that is not registered; an empty DefaultRenderMode, or one the story does not have, keeps the
+	// built-in choice: SSR for full page loads, CSR for mach requests.
+	DefaultTheme      string
+	DefaultRenderMode string
+
+	// HideDiagnostics leaves the diagnostics report route out of NewRouter. The caller is
+	// expected to pass content without diagnostics as well, so the UI does not link to it.
+	HideDiagnostics bool
+
+	// BasePath is the path the sandbox is mounted under, e.g. "/_design", without a trailing
+	// slash; empty at the root. Requests reach the handlers with it stripped (see package
+	// sandbox), and every URL the handlers generate starts with it.
+	BasePath string
+
+	// Args decodes the args in page and frame URLs: whether args a story does not declare are
+	// accepted, and the allowlist HTML args are sanitized with. The zero value accepts them and
+	// uses the default allowlist.
+	Args args.Decoder
+
+	// CSP is the Content-Security-Policy sent with pages and frames; nil sends none. Inline
+	// scripts and styles carry a per-request nonce either way, see csp.go.
+	CSP *CSP
+
+	// Static is the static directory, as served under /static/. The parity report reads the
+	// story files and their imports from it; nil leaves the report without CSR renderings.
+	Static fs.FS
+
+	mu sync.RWMutex // Guards the fields above against Update
+}
+
+// Content is what the handlers serve from the project's files. It is loaded at startup and
+// again whenever the watcher sees a change.
+type Content struct {
+	Components  []models.ComponentGroup
+	Templates   *template.Template // nil in an Update keeps the current templates (e.g. when re-parsing failed)
+	Diagnostics models.Diagnostics
+	ImportMap   *importmap.ImportMap
+	Themes      []string // Configured or discovered from the stylesheets, see package themes
+}
+
+// NewAppHandlers returns handlers serving the given content.
+func NewAppHandlers(content Content) *AppHandlers {
+	return &AppHandlers{
+		Components:  content.Components,
+		Templates:   content.Templates,
+		Diagnostics: content.Diagnostics,
+		ImportMap:   content.ImportMap,
+		Themes:      content.Themes,
+		Events:      NewReloadEvents(),
+		Hydration:   NewHydrationReports(),
+		RenderModes: NewRenderModeRegistry(),
+	}
+}
+
+// Update swaps in newly loaded content and tells connected browsers to re-render.
+func (h *AppHandlers) Update(content Content, scope string, changed []string) {
+	h.mu.Lock()
+	h.Components = content.Components
+	if content.Templates != nil {
+		h.Templates = content.Templates
+	}
+	h.Diagnostics = content.Diagnostics
+	h.ImportMap = content.ImportMap
+	h.Themes = content.Themes
+	h.mu.Unlock()
+
+	if h.Events != nil {
+		h.Events.Publish(scope, changed)
+	}
+}
+
+// Content returns the content currently served.
+func (h *AppHandlers) Content() Content {
+	h = h.snapshot()
+	return Content{
+		Components:  h.Components,
+		Templates:   h.Templates,
+		Diagnostics: h.Diagnostics,
+		ImportMap:   h.ImportMap,
+		Themes:      h.Themes,
+	}
+}
+
+// ExportHeader marks requests made by the static export (see package export). Pages rendered
+// for it leave out what needs a running server: live reload and mach-link partial navigation.
+const ExportHeader = "X-Sandbox-Export"
+
+func isStaticExport(r *http.Request) bool {
+	return r.Header.Get(ExportHeader) == "true"
+}
+
+// liveReload reports whether the page or frame for r loads live-reload.js: only if the events
+// stream is served (see NewRouter) and the page is not exported.
+func (h *AppHandlers) liveReload(r *http.Request) bool {
+	return h.Events != nil && !isStaticExport(r)
+}
+
+// snapshot returns a copy of the handlers holding the current content.
+// The slices and template set are never mutated after Update, so sharing them is safe.
+func (h *AppHandlers) snapshot() *AppHandlers {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return &AppHandlers{
+		Components:  h.Components,
+		Templates:   h.Templates,
+		Diagnostics: h.Diagnostics,
+		ImportMap:   h.ImportMap,
+		Themes:      h.Themes,
+		Events:      h.Events,
+		Hydration:   h.Hydration,
+		RenderModes: h.RenderModes,
+		Viewports:   h.Viewports,
+
+		DefaultTheme:      h.DefaultTheme,
+		DefaultRenderMode: h.DefaultRenderMode,
+		HideDiagnostics:   h.HideDiagnostics,
+		BasePath:          h.BasePath,
+		Args:              h.Args,
+		CSP:               h.CSP,
+		Static:            h.Static,
+	}
+}
+
+// importMapHTML returns the import map for page and frame heads, with its targets moved under
+// BasePath, see importmap.ImportMap.Mounted.
+func (h *AppHandlers) importMapHTML() template.HTML {
+	return h.ImportMap.Mounted(h.BasePath).HTML()
+}
+
+// themes returns the registered themes.
+func (h *AppHandlers) themes() []string {
+	if len(h.Themes) > 0 {
+		return h.Themes
+	}
+	return themes.Builtin
+}
+
+// theme returns the requested theme if it is a registered one, the default theme otherwise.
+func (h *AppHandlers) theme(requested string) string {
+	registered := h.themes()
+	for _, theme := range []string{requested, h.DefaultTheme, "light"} {
+		if slices.Contains(registered, theme) {
+			return theme
+		}
+	}
+	return registered[0]
+}
+
+// themeSelect fills in the toolbar's theme select of data for a page at path: an option per
+// registered theme, linking to path with the query's theme replaced, and the hidden fields
+// that keep the rest of the query when the select's form is submitted.
+func (h *AppHandlers) themeSelect(data *models.PageData, path string, query url.Values) {
+	data.ThemeOptions = nil
+	for _, name := range h.themes() {
+		themeQuery := url.Values{}
+		for key, values := range query {
+			themeQuery[key] = values
+		}
+		themeQuery.Set("theme", name)
+		data.ThemeOptions = append(data.ThemeOptions, models.ThemeOption{
+			Name:     name,
+			Label:    themes.Label(name),
+			URL:      (&url.URL{Path: path, RawQuery: themeQuery.Encode()}).String(),
+			IsActive: name == data.Theme,
+		})
+	}
+
+	data.ThemeFormURL = path
+	data.ThemeFormFields = nil
+	for _, key := range slices.Sorted(maps.Keys(query)) {
+		if key == "theme" {
+			continue
+		}
+		for _, value := range query[key] {
+			data.ThemeFormFields = append(data.ThemeFormFields, models.FormField{Name: key, Value: value})
+		}
+	}
+}
+
+// viewports returns the viewport presets.
+func (h *AppHandlers) viewports() []viewport.Viewport {
+	if len(h.Viewports) > 0 {
+		return h.Viewports
+	}
+	return viewport.Presets
+}
+
+// viewportSelect sets the story frame's size of data from the query's viewport, and fills in
+// the toolbar's viewport control for a page at path: a link per preset, plus the custom size
+// shown, with the query's viewport replaced, and one without it that fills the page. An
+// unknown viewport fills the page.
+func (h *AppHandlers) viewportSelect(data *models.PageData, path string, query url.Values) {
+	presets := h.viewports()
+	current, ok := viewport.Lookup(query.Get(viewport.Param), presets)
+	data.Viewport, data.ViewportWidth, data.ViewportHeight = "", 0, 0
+	if ok {
+		data.Viewport, data.ViewportWidth, data.ViewportHeight = current.Name, current.Width, current.Height
+	}
+
+	link := func(name, label string) models.ViewportLink {
+		viewportQuery := url.Values{}
+		for key, values := range query {
+			viewportQuery[key] = values
+		}
+		viewportQuery.Del(viewport.Param)
+		if name != "" {
+			viewportQuery.Set(viewport.Param, name)
+		}
+		return models.ViewportLink{
+			Name:     name,
+			Label:    label,
+			URL:      (&url.URL{Path: path, RawQuery: viewportQuery.Encode()}).String(),
+			IsActive: name == data.Viewport,
+		}
+	}
+	data.ViewportLinks = []models.ViewportLink{link("", "Fill")}
+	for _, preset := range presets {
+		data.ViewportLinks = append(data.ViewportLinks, link(preset.Name, preset.Label()))
+	}
+	if ok && !slices.Contains(presets, current) {
+		data.ViewportLinks = append(data.ViewportLinks, link(current.Name, current.Label()))
+	}
 }
 
 // Home renders the home page of the component playground.
 // It lists all available components.
 func (h *AppHandlers) Home(w http.ResponseWriter, r *http.Request) {
+	h = h.snapshot()
 	if r.URL.Path != "/" {
 		http.NotFound(w, r)
 		return
@@ -45,46 +279,38 @@ func (h *AppHandlers) Home(w http.ResponseWriter, r *http.Request) {
 	// Reset IsSelected flags for all components and their variants for the homepage
 	for i := range pageComponents {
 		pageComponents[i].IsSelected = false
+		pageComponents[i].Variants = slices.Clone(pageComponents[i].Variants) // Shared with other requests
 		for j := range pageComponents[i].Variants {
 			pageComponents[i].Variants[j].IsSelected = false
 		}
 	}
 
-	currentTheme := "light"
-	if r.URL.Query().Get("theme") == "dark" {
-		currentTheme = "dark"
-	}
-
-	// Construct ToggleThemeURL
-	toggleThemeQueryForHome := r.URL.Query()
-	newThemeForHomeToggle := "dark"
-	if currentTheme == "light" {
-		newThemeForHomeToggle = "dark"
-	} else {
-		newThemeForHomeToggle = "light"
-	}
-	toggleThemeQueryForHome.Set("theme", newThemeForHomeToggle)
-	// Ensure other relevant params for home are preserved if any (e.g., if home ever gets params)
-	// Path for home page theme toggle should hit the body-swap handler's home context.
-	toggleThemeURLValue := (&url.URL{Path: "/sandbox-body-swap/", RawQuery: toggleThemeQueryForHome.Encode()}).String()
+	currentTheme := h.theme(r.URL.Query().Get("theme"))
 
 	// Construct ResetArgsURL for Home
 	resetArgsQueryHome := r.URL.Query()
 	// No specific args on home, but keep structure. It should also point to body-swap home.
-	resetArgsURLValue := (&url.URL{Path: "/sandbox-body-swap/", RawQuery: resetArgsQueryHome.Encode()}).String()
+	resetArgsURLValue := (&url.URL{Path: h.BasePath + "/sandbox-body-swap/", RawQuery: resetArgsQueryHome.Encode()}).String()
 
 	data := models.PageData{
 		Title:                 "Component Playground",
 		Components:            pageComponents,
-		StaticBaseURL:         "/static",
+		BasePath:              h.BasePath,
+		StaticBaseURL:         h.BasePath + "/static",
+		Diagnostics:           h.Diagnostics,
+		ImportMapJSON:         h.importMapHTML(),
+		StaticExport:          isStaticExport(r),
+		LiveReload:            h.liveReload(r),
+		Nonce:                 cspNonce(r),
 		Theme:                 currentTheme,
-		ToggleThemeURL:        toggleThemeURLValue,
 		ResetArgsURL:          resetArgsURLValue,
-		CurrentPath:           r.URL.Path, // Current path is "/"
-		CanClientSideNavigate: true,       // Assuming JS is available for mach-link
-		RenderMode:            "csr",      // Home doesn't have specific modes, toolbar needs a default
-		IframeSrcURL:          "/sandbox-content?renderMode=csr&componentName=fallback",
+		CurrentPath:           h.BasePath + r.URL.Path, // Current path is "/"
+		CanClientSideNavigate: true,                    // Assuming JS is available for mach-link
+		RenderMode:            "csr",                   // Home doesn't have specific modes, toolbar needs a default
+		IframeSrcURL:          h.BasePath + "/sandbox-content?renderMode=csr&componentName=fallback",
 	}
+	// The theme select hits the body-swap handler's home context
+	h.themeSelect(&data, h.BasePath+"/sandbox-body-swap/", r.URL.Query())
 
 	// Pre-render ToolbarHTML and StoryArgsEditorHTML for the body template
 	var toolbarBuf bytes.Buffer
@@ -123,11 +349,17 @@ func (h *AppHandlers) Home(w http.ResponseWriter, r *http.Request) {
 // ViewStory renders the page for a specific component story.
 // It now always uses an iframe for content, whose src is determined by renderMode.
 func (h *AppHandlers) ViewStory(w http.ResponseWriter, r *http.Request) {
+	h = h.snapshot()
 	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
 	if len(pathParts) < 2 || pathParts[0] != "sandbox" {
 		http.NotFound(w, r)
 		return
 	}
+	mach, err := readMachRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	componentNameParam := pathParts[1]
 	storyKeyParam := ""
@@ -161,7 +393,7 @@ func (h *AppHandlers) ViewStory(w http.ResponseWriter, r *http.Request) {
 
 	if currentComponent == nil {
 		log.Printf("Component group not found: %s", componentNameParam)
-		http.Redirect(w, r, "/", http.StatusFound)
+		machRedirect(w, r, mach, h.BasePath+"/")
 		return
 	}
 
@@ -196,12 +428,12 @@ func (h *AppHandlers) ViewStory(w http.ResponseWriter, r *http.Request) {
 			log.Printf("Story variant '%s' not found in component '%s'.", storyKeyParam, currentComponent.Title)
 			if len(currentComponent.Variants) > 0 {
 				firstStoryKey := currentComponent.Variants[0].Key
-				redirectURL := "/sandbox/" + componentNameParam + "/" + firstStoryKey
+				redirectURL := h.BasePath + "/sandbox/" + componentNameParam + "/" + firstStoryKey
 				existingQuery := r.URL.Query()
 				if len(existingQuery) > 0 {
 					redirectURL += "?" + existingQuery.Encode()
 				}
-				http.Redirect(w, r, redirectURL, http.StatusFound)
+				machRedirect(w, r, mach, redirectURL)
 				return
 			}
 			isFallbackScenario = true
@@ -215,22 +447,15 @@ func (h *AppHandlers) ViewStory(w http.ResponseWriter, r *http.Request) {
 		pageTitle += " - Info"
 	}
 
-	var availableModes []string
-	if selectedStoryVariant != nil && selectedStoryVariant.HasCSR {
-		availableModes = append(availableModes, "csr")
-	}
-	if selectedStoryVariant != nil && selectedStoryVariant.HasSSR && h.Templates.Lookup(selectedStoryVariant.Key) != nil {
-		availableModes = append(availableModes, "ssr")
-	} else if selectedStoryVariant != nil && selectedStoryVariant.HasSSR {
-		log.Printf("Warning: Story %s/%s marked HasSSR, but Go template '%s' not found.", currentComponent.Name, selectedStoryVariant.Key, selectedStoryVariant.Key)
-	}
-	if isFallbackScenario && currentComponent.Path != "" {
-		availableModes = []string{"csr"}
+	story := Story{Component: currentComponent, Variant: selectedStoryVariant, Templates: h.Templates}
+	availableModes := h.RenderModes.Available(story)
+	if selectedStoryVariant != nil && selectedStoryVariant.HasSSR && !story.HasSSRTemplate() {
+		log.Printf("Warning: Story %s/%s marked HasSSR, but Go template '%s' not found.", currentComponent.Name, selectedStoryVariant.Key, selectedStoryVariant.SSRTemplateName)
 	}
 	log.Printf("ViewStory: Initial availableModes: %v for %s/%s", availableModes, componentNameParam, storyKeyParam)
 
 	// Comprehensive logic block for parameter determination and redirects:
-	isMachRequest := r.Header.Get("X-Mach-Request") == "true"
+	isMachRequest := mach.Partial
 	initialRequestedRenderMode := r.URL.Query().Get("renderMode")
 	initialRequestedTheme := r.URL.Query().Get("theme")
 
@@ -255,6 +480,10 @@ func (h *AppHandlers) ViewStory(w http.ResponseWriter, r *http.Request) {
 		// Requested mode is valid and available, use it regardless of Mach request status.
 		effectiveRenderMode = initialRequestedRenderMode
 		log.Printf("ViewStory: Honoring valid requested renderMode from query: '%s'.", effectiveRenderMode)
+	} else if slices.Contains(availableModes, h.DefaultRenderMode) {
+		// A configured default mode wins over the built-in defaults below, as long as the story has it.
+		effectiveRenderMode = h.DefaultRenderMode
+		log.Printf("ViewStory: Requested renderMode ('%s') is missing or invalid, using the configured default '%s'.", initialRequestedRenderMode, effectiveRenderMode)
 	} else {
 		// Requested mode is missing, empty, or invalid for this story. Apply defaults based on request type.
 		log.Printf("ViewStory: Requested renderMode ('%s') is missing or invalid for available modes %v. Determining default...", initialRequestedRenderMode, availableModes)
@@ -287,11 +516,8 @@ func (h *AppHandlers) ViewStory(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Determine effective theme, defaulting to "light".
-	effectiveTheme = initialRequestedTheme
-	if effectiveTheme != "light" && effectiveTheme != "dark" {
-		effectiveTheme = "light" // Default theme
-	}
+	// Determine effective theme, falling back to the default theme.
+	effectiveTheme = h.theme(initialRequestedTheme)
 
 	// For non-Mach requests, redirect ONLY if parameters were defaulted (missing/invalid)
 	// or if theme needed defaulting. If user explicitly provided valid params, don't redirect.
@@ -306,8 +532,8 @@ func (h *AppHandlers) ViewStory(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		// Redirect if theme was defaulted (and wasn't just missing but defaulting to light)
-		if initialRequestedTheme != effectiveTheme && !(initialRequestedTheme == "" && effectiveTheme == "light") {
+		// Redirect if theme was defaulted (and wasn't just missing but defaulting to the default theme)
+		if initialRequestedTheme != effectiveTheme && !(initialRequestedTheme == "" && effectiveTheme == h.theme("")) {
 			needsRedirect = true
 			log.Printf("ViewStory: Redirect needed because theme was defaulted (requested '%s', effective '%s').", initialRequestedTheme, effectiveTheme)
 		}
@@ -316,7 +542,7 @@ func (h *AppHandlers) ViewStory(w http.ResponseWriter, r *http.Request) {
 			canonicalQuery := r.URL.Query()
 			canonicalQuery.Set("renderMode", effectiveRenderMode)
 			canonicalQuery.Set("theme", effectiveTheme)
-			redirectURL := url.URL{Path: r.URL.Path, RawQuery: canonicalQuery.Encode()}
+			redirectURL := url.URL{Path: h.BasePath + r.URL.Path, RawQuery: canonicalQuery.Encode()}
 			log.Printf("ViewStory: Redirecting non-Mach request to canonical URL: '%s'", redirectURL.String())
 			http.Redirect(w, r, redirectURL.String(), http.StatusFound)
 			return
@@ -329,62 +555,26 @@ func (h *AppHandlers) ViewStory(w http.ResponseWriter, r *http.Request) {
 		Components:            pageComponents,
 		SelectedComponent:     currentComponent,
 		SelectedStoryKey:      storyKeyParam,
-		StaticBaseURL:         "/static",
+		BasePath:              h.BasePath,
+		StaticBaseURL:         h.BasePath + "/static",
+		Diagnostics:           h.Diagnostics,
+		ImportMapJSON:         h.importMapHTML(),
+		StaticExport:          isStaticExport(r),
+		LiveReload:            h.liveReload(r),
+		Nonce:                 cspNonce(r),
 		RenderMode:            effectiveRenderMode,
 		AvailableRenderModes:  availableModes,
 		SSRAvailable:          ssrAvailable,
 		Theme:                 effectiveTheme,
-		CurrentPath:           r.URL.Path,
+		CurrentPath:           h.BasePath + r.URL.Path,
 		CanClientSideNavigate: isMachRequest,
 	}
-
-	currentStoryArgs := make(map[string]interface{})
-	// SelectedStoryArgs processing (from ViewStory logic, adapted)
-	if selectedStoryVariant != nil && selectedStoryVariant.Args != nil {
-		requestQueryParams := r.URL.Query()
-		for argName, defaultValue := range selectedStoryVariant.Args {
-			currentVal := defaultValue
-			isDefaultBool := false
-			if _, ok := defaultValue.(bool); ok {
-				isDefaultBool = true
-			}
-			if queryVals, ok := requestQueryParams[argName]; ok && len(queryVals) > 0 {
-				paramStrValue := queryVals[0]
-				if isDefaultBool {
-					currentVal = (strings.ToLower(paramStrValue) == "true")
-				} else {
-					switch defaultValue.(type) {
-					case int, int64, float32, float64:
-						if num, err := strconv.ParseFloat(paramStrValue, 64); err == nil {
-							currentVal = num
-						} else {
-							currentVal = paramStrValue
-						}
-					default:
-						currentVal = paramStrValue
-					}
-				}
-			} else {
-				if isDefaultBool {
-					currentVal = false
-				}
-			}
-			currentStoryArgs[argName] = currentVal
-		}
-		if defaultChildrenVal, ok := selectedStoryVariant.Args["Children"]; ok {
-			if _, defaultIsHTML := defaultChildrenVal.(template.HTML); defaultIsHTML {
-				if currentChildrenVal, currentOk := currentStoryArgs["Children"]; currentOk {
-					if childrenStr, valIsString := currentChildrenVal.(string); valIsString {
-						currentStoryArgs["Children"] = template.HTML(childrenStr)
-					}
-				}
-			}
-		}
-		data.SelectedStoryArgs = currentStoryArgs
+	if effectiveRenderMode == "hydrate" {
+		data.HydrationReport = h.Hydration.Latest(componentNameParam, storyKeyParam)
 	}
 
 	// --- Construct IframeSrcURL with dynamic path ---
-	iframePath := "/sandbox-content/" + componentNameParam
+	iframePath := h.BasePath + "/sandbox-content/" + componentNameParam
 	if storyKeyParam != "" {
 		iframePath += "/" + storyKeyParam
 	}
@@ -393,147 +583,49 @@ func (h *AppHandlers) ViewStory(w http.ResponseWriter, r *http.Request) {
 	iframeQuery.Set("renderMode", data.RenderMode)
 	iframeQuery.Set("theme", data.Theme)
 
-	// Parse args for PageData (for editor) AND for iframe query
+	// The args for the editor and the iframe query
+	argSpec := args.ForVariant(selectedStoryVariant)
+	storyArgs, extraArgs, argProblems := h.pageArgs(r.URL.Query(), argSpec, "ViewStory: "+componentNameParam+"/"+storyKeyParam)
 	if selectedStoryVariant != nil && selectedStoryVariant.Args != nil {
-		requestQueryParams := r.URL.Query()
-		for argName, defaultValue := range selectedStoryVariant.Args {
-			currentVal := defaultValue
-			stringValForQuery := fmt.Sprintf("%v", defaultValue)
-			isDefaultBool := false
-			if _, ok := defaultValue.(bool); ok {
-				isDefaultBool = true
-			}
-
-			if queryVals, ok := requestQueryParams[argName]; ok && len(queryVals) > 0 {
-				paramStrValue := queryVals[0]
-				stringValForQuery = paramStrValue
-				if isDefaultBool {
-					currentVal = (strings.ToLower(paramStrValue) == "true")
-				} else {
-					switch defaultValue.(type) {
-					case int, int64, float32, float64:
-						if num, err := strconv.ParseFloat(paramStrValue, 64); err == nil {
-							currentVal = num
-						} else {
-							currentVal = paramStrValue
-						}
-					default:
-						currentVal = paramStrValue
-					}
-				}
-			} else {
-				if isDefaultBool {
-					currentVal = false
-					stringValForQuery = "false"
-				}
-			}
-			currentStoryArgs[argName] = currentVal
-			iframeQuery.Set(argName, stringValForQuery)
-		}
-		if defaultChildrenVal, ok := selectedStoryVariant.Args["Children"]; ok {
-			if _, defaultIsHTML := defaultChildrenVal.(template.HTML); defaultIsHTML {
-				if currentChildrenVal, currentOk := currentStoryArgs["Children"]; currentOk {
-					if childrenStr, valIsString := currentChildrenVal.(string); valIsString {
-						currentStoryArgs["Children"] = template.HTML(childrenStr)
-					}
-				}
-			}
-		}
-		data.SelectedStoryArgs = currentStoryArgs
+		args.Encode(storyArgs, iframeQuery)
+		data.SelectedStoryArgs = storyArgs
 	} else {
-		requestQueryParams := r.URL.Query()
-		for key, vals := range requestQueryParams {
-			if len(vals) > 0 && key != "renderMode" && key != "theme" && key != "componentName" && key != "storyKey" {
-				iframeQuery.Set(key, vals[0])
-			}
-		}
+		args.Encode(extraArgs, iframeQuery)
 	}
+	data.ArgProblems = argProblems
 	data.IframeSrcURL = iframePath + "?" + iframeQuery.Encode() // Assign the final URL
 
-	vsToggleThemeQuery := r.URL.Query()
-	newThemeForStoryToggle := "dark"
-	if data.Theme == "light" {
-		newThemeForStoryToggle = "dark"
-	} else {
-		newThemeForStoryToggle = "light"
-	}
-	vsToggleThemeQuery.Set("theme", newThemeForStoryToggle)
-
-	// Path for story view theme toggle should hit the body-swap handler with component/story context.
-	bodySwapPathForStory := "/sandbox-body-swap/" + componentNameParam
+	// Path for the story view's theme select should hit the body-swap handler with component/story context.
+	bodySwapPathForStory := h.BasePath + "/sandbox-body-swap/" + componentNameParam
 	if storyKeyParam != "" {
 		bodySwapPathForStory += "/" + storyKeyParam
 	}
-	data.ToggleThemeURL = (&url.URL{Path: bodySwapPathForStory, RawQuery: vsToggleThemeQuery.Encode()}).String()
+	h.themeSelect(&data, bodySwapPathForStory, r.URL.Query())
 
 	resetArgsQueryStory := r.URL.Query()
-	if selectedStoryVariant != nil && selectedStoryVariant.Args != nil {
-		for argName := range selectedStoryVariant.Args {
-			resetArgsQueryStory.Del(argName)
-		}
-	}
+	args.Delete(resetArgsQueryStory, argSpec)
 	data.ResetArgsURL = (&url.URL{Path: bodySwapPathForStory, RawQuery: resetArgsQueryStory.Encode()}).String()
 
-	var modeLinks []models.ModeSwitchLink
-	for _, mode := range data.AvailableRenderModes {
-		queryForModeSwitch := r.URL.Query()
-		queryForModeSwitch.Set("renderMode", mode)
-		modeURL := (&url.URL{Path: bodySwapPathForStory, RawQuery: queryForModeSwitch.Encode()}).String()
-		isActive := (mode == data.RenderMode)
-		var text string
-		if isActive {
-			text = strings.ToUpper(mode) + " Active"
-		} else {
-			text = "Switch to " + strings.ToUpper(mode)
-		}
-		modeLinks = append(modeLinks, models.ModeSwitchLink{ModeKey: mode, URL: modeURL, IsActive: isActive, Text: text})
-	}
-	data.ModeSwitchLinks = modeLinks
-
-	var toolbarBuf bytes.Buffer
-	if err := h.Templates.ExecuteTemplate(&toolbarBuf, "toolbar-content", data); err == nil {
-		data.ToolbarHTML = template.HTML(toolbarBuf.String())
-	} else {
-		log.Printf("Error rendering toolbar template: %v", err)
-		data.ToolbarHTML = template.HTML("<!-- Error rendering toolbar -->")
-	}
-
-	var argsEditorBuf bytes.Buffer
-	if err := h.Templates.ExecuteTemplate(&argsEditorBuf, "story-args-editor-content", data); err == nil {
-		data.StoryArgsEditorHTML = template.HTML(argsEditorBuf.String())
-	} else {
-		log.Printf("Error rendering story args editor template: %v", err)
-		data.StoryArgsEditorHTML = template.HTML("<!-- Error rendering story args editor -->")
-	}
+	data.ModeSwitchLinks = h.modeSwitchLinks(data.AvailableRenderModes, data.RenderMode, bodySwapPathForStory, r.URL.Query())
+	h.viewportSelect(&data, bodySwapPathForStory, r.URL.Query())
 
 	data.IsPartialRequest = isMachRequest
 	if isMachRequest {
-		log.Printf("Partial request for %s/%s. Target: '%s', Mode: %s, IframeSrc: %s", componentNameParam, storyKeyParam, data.ClientTargetSelector, data.RenderMode, data.IframeSrcURL)
-		w.Header().Set("X-Mach-Title", pageTitle)
-
-		var mainContentBuf bytes.Buffer
-		if err := h.Templates.ExecuteTemplate(&mainContentBuf, "content", data); err != nil {
-			log.Printf("Error executing partial template 'content': %v", err)
-			http.Error(w, "Failed to render partial content", http.StatusInternalServerError)
+		log.Printf("Partial request for %s/%s. Targets: %v, Mode: %s, IframeSrc: %s", componentNameParam, storyKeyParam, mach.Targets, data.RenderMode, data.IframeSrcURL)
+		w.Header().Set(MachTitleHeader, pageTitle)
+		w.Header().Set(MachPushURLHeader, storyPageURL(h.BasePath+r.URL.Path, r.URL.Query(), data.RenderMode, data.Theme))
+		if mach.Targets != nil {
+			h.writeMachFragments(w, data, mach.Targets, "ViewStory")
 			return
 		}
 
-		var navContentBuf bytes.Buffer
-		if err := h.Templates.ExecuteTemplate(&navContentBuf, "navigation-content", data); err != nil {
-			log.Printf("Error executing partial template 'navigation-content': %v", err)
-			http.Error(w, "Failed to render partial navigation", http.StatusInternalServerError)
-			return
+		// No X-Mach-Target: the whole body, like a body swap
+		h.renderPanels(&data, "ViewStory")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := h.Templates.ExecuteTemplate(w, "full-body-content", data); err != nil {
+			log.Printf("ViewStory: Error executing template 'full-body-content': %v", err)
+			http.Error(w, "Failed to render body content fragment", http.StatusInternalServerError)
 		}
-		var updatedToolbarBuf, updatedArgsEditorBuf bytes.Buffer
-		_ = h.Templates.ExecuteTemplate(&updatedToolbarBuf, "toolbar-content", data)
-		_ = h.Templates.ExecuteTemplate(&updatedArgsEditorBuf, "story-args-editor-content", data)
-
-		responseBody := "<div data-mach-target-selector=\"#component-nav\">" + navContentBuf.String() + "</div>" +
-			"<div data-mach-target-selector=\".sandbox-toolbar\">" + updatedToolbarBuf.String() + "</div>" +
-			"<div data-mach-target-selector=\".story-args-editor\">" + updatedArgsEditorBuf.String() + "</div>" +
-			mainContentBuf.String()
-
-		w.Write([]byte(responseBody))
 		return
 	}
 
@@ -551,8 +643,7 @@ func (h *AppHandlers) ViewStory(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte("</html>"))
 		return
 	}
-	// Note: data.ToolbarHTML and data.StoryArgsEditorHTML were pre-rendered earlier in this function.
-	// The 'full-body-content' template will use these.
+	h.renderPanels(&data, "ViewStory")
 	if err := h.Templates.ExecuteTemplate(w, "full-body-content", data); err != nil {
 		log.Printf("ViewStory: Error executing full-body-content template: %v", err)
 		// Attempt to close HTML tag gracefully if body failed.
@@ -564,26 +655,51 @@ func (h *AppHandlers) ViewStory(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// ServeSandboxContent serves the content for the iframe, deciding between CSR bootstrap and SSR content.
+// renderPanels renders the toolbar and the args editor of data, which full-body-content
+// embeds. Errors are logged with logPrefix and leave a panel empty.
+func (h *AppHandlers) renderPanels(data *models.PageData, logPrefix string) {
+	var toolbarBuf bytes.Buffer
+	if err := h.Templates.ExecuteTemplate(&toolbarBuf, "toolbar-content", data); err != nil {
+		log.Printf("%s: Error rendering toolbar: %v", logPrefix, err)
+		toolbarBuf.Reset()
+	}
+	data.ToolbarHTML = template.HTML(toolbarBuf.String())
+
+	var argsEditorBuf bytes.Buffer
+	if err := h.Templates.ExecuteTemplate(&argsEditorBuf, "story-args-editor-content", data); err != nil {
+		log.Printf("%s: Error rendering args editor: %v", logPrefix, err)
+		argsEditorBuf.Reset()
+	}
+	data.StoryArgsEditorHTML = template.HTML(argsEditorBuf.String())
+}
+
+// pageArgs decodes the args of a story from a manager page URL: the declared ones for the
+// editor, the undeclared ones the frame of a story without args gets, and everything the
+// decoder rejected or sanitized. Problems are logged with logPrefix.
+func (h *AppHandlers) pageArgs(query url.Values, spec args.Spec, logPrefix string) (declared, undeclared map[string]interface{}, problems []models.ArgProblem) {
+	declared, declaredErrs := h.Args.Decode(query, spec)
+	undeclared, undeclaredErrs := h.Args.Undeclared(query, spec)
+	for _, argErr := range append(declaredErrs, undeclaredErrs...) {
+		log.Printf("%s: %v", logPrefix, argErr)
+		problems = append(problems, models.ArgProblem{Name: argErr.Name, Value: argErr.Value, Reason: argErr.Reason})
+	}
+	return declared, undeclared, problems
+}
+
+// ServeSandboxContent serves the content for the iframe: the document of the render mode the
+// query names, see RenderMode.
 func (h *AppHandlers) ServeSandboxContent(w http.ResponseWriter, r *http.Request) {
+	h = h.snapshot()
 	// Extract component and story from path parameters
 	componentName := r.PathValue("componentName")
 	storyKey := r.PathValue("storyKey") // Will be empty if route was /sandbox-content/{componentName}
 
 	query := r.URL.Query()
 	renderMode := query.Get("renderMode")
-	theme := query.Get("theme")
-	// componentName := query.Get("componentName") // No longer from query
-	// storyKey := query.Get("storyKey") // No longer from query
-
-	if theme == "" {
-		theme = "light"
-	}
+	theme := h.theme(query.Get("theme"))
 	if componentName == "" || componentName == "fallback" { // Handle fallback case explicitly if needed
-		// If componentName is empty or 'fallback', maybe serve a specific fallback page or CSR frame?
-		// For now, let's assume a valid componentName is required here.
-		// The Home handler sets iframe src to ...componentName=fallback, maybe change that?
-		// Let's treat 'fallback' as an indicator to serve the CSR fallback frame.
+		// The Home handler sets the iframe src to /sandbox-content/fallback, which serves the
+		// CSR fallback frame.
 		if componentName == "fallback" {
 			log.Printf("ServeSandboxContent: Handling fallback request.")
 			h.serveCsrFallbackFrame(w, r, theme)
@@ -593,284 +709,38 @@ func (h *AppHandlers) ServeSandboxContent(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// --- Handle CSR Mode ---
-	if renderMode == "csr" {
-		log.Printf("ServeSandboxContent: Handling CSR request for %s/%s", componentName, storyKey)
-
-		// Prepare config for sandbox-config script tag
-		config := make(map[string]interface{})
-		currentArgsForCSR := make(map[string]interface{})
-		var scriptToLoad string
-		isFallback := false // Determine if this is a fallback scenario
-
-		var targetComponentInDB *models.ComponentGroup
-		var targetStoryVariantInDB *models.StoryVariant
-
-		// Find component and story variant from DB to get default args and paths
-		for i := range h.Components {
-			if h.Components[i].Name == componentName {
-				comp := h.Components[i]
-				targetComponentInDB = &comp
-				if storyKey != "" {
-					for j := range comp.Variants {
-						if comp.Variants[j].Key == storyKey {
-							variant := comp.Variants[j]
-							targetStoryVariantInDB = &variant
-							break
-						}
-					}
-				}
-				if storyKey == "" || targetStoryVariantInDB == nil {
-					isFallback = true
-				}
-				break
-			}
-		}
-
-		if targetComponentInDB == nil {
-			log.Printf("ServeSandboxContent (CSR): Component '%s' not found in DB.", componentName)
-			http.Error(w, "Component not found", http.StatusNotFound)
-			return
-		}
-
-		// Populate config based on whether it's a story or fallback/component view
-		config["componentName"] = targetComponentInDB.Name
-		config["renderMode"] = "csr"                      // Pass effective mode to client script
-		if !isFallback && targetStoryVariantInDB != nil { // Specific Story
-			config["storyKey"] = targetStoryVariantInDB.Key
-			if targetComponentInDB.Path != "" {
-				config["storyModulePath"] = "/static/" + targetComponentInDB.Path
-			}
-			scriptToLoad = "/static/modules/sandbox/iframe-client.js"
-
-			if targetStoryVariantInDB.Args != nil {
-				for k, v := range targetStoryVariantInDB.Args {
-					currentArgsForCSR[k] = v
-				}
-			}
-			for queryKey, queryValues := range query {
-				if len(queryValues) == 0 {
-					continue
-				}
-				if defaultValue, knownArg := currentArgsForCSR[queryKey]; knownArg {
-					paramStrValue := queryValues[0]
-					switch defaultValue.(type) {
-					case bool:
-						currentArgsForCSR[queryKey] = (strings.ToLower(paramStrValue) == "true")
-					case int, int64, float32, float64:
-						if num, err := strconv.ParseFloat(paramStrValue, 64); err == nil {
-							currentArgsForCSR[queryKey] = num
-						} else {
-							currentArgsForCSR[queryKey] = paramStrValue
-						}
-					default:
-						currentArgsForCSR[queryKey] = paramStrValue
-					}
-				} else if queryKey != "renderMode" && queryKey != "theme" && queryKey != "componentName" && queryKey != "storyKey" {
-					currentArgsForCSR[queryKey] = queryValues[0]
-				}
-			}
-			if targetStoryVariantInDB.Args != nil {
-				for defaultArgName, defaultValue := range targetStoryVariantInDB.Args {
-					if _, isBool := defaultValue.(bool); isBool {
-						if _, presentInQuery := query[defaultArgName]; !presentInQuery {
-							if _, setFromDefaults := currentArgsForCSR[defaultArgName]; !setFromDefaults {
-								currentArgsForCSR[defaultArgName] = false
-							}
-						}
-					}
-				}
-			}
-
-		} else { // Fallback / Component View
-			isFallback = true
-			config["componentTitle"] = targetComponentInDB.Title
-			if targetComponentInDB.Path != "" {
-				config["componentPath"] = "/static/" + targetComponentInDB.Path
-			}
-			scriptToLoad = "/static/modules/sandbox/sandbox-fallback.js"
-			for queryKey, queryValues := range query {
-				if len(queryValues) > 0 && queryKey != "renderMode" && queryKey != "theme" && queryKey != "componentName" && queryKey != "storyKey" {
-					currentArgsForCSR[queryKey] = queryValues[0]
-				}
-			}
-		}
-
-		if len(currentArgsForCSR) > 0 {
-			config["currentArgs"] = currentArgsForCSR
-		}
-
-		configJSON, err := json.Marshal(config)
-		if err != nil {
-			log.Printf("ServeSandboxContent (CSR): Error marshalling config: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
-
-		// Render the CSR Frame HTML
-		frameData := models.CSRFrameData{
-			Theme:               theme,
-			SandboxConfigJSON:   template.JS(configJSON),
-			SandboxScriptToLoad: scriptToLoad,
-			IsFallback:          isFallback,
-		}
-
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		if _, err := w.Write([]byte("<!DOCTYPE html>\n<html lang=\"en\">\n<head>")); err != nil {
-			return
-		}
-		if err := h.Templates.ExecuteTemplate(w, "_csr_frame_head", frameData); err != nil {
-			log.Printf("ServeSandboxContent (CSR): Error executing _csr_frame_head: %v", err)
-			return
-		}
-		if _, err := w.Write([]byte("</head>\n<body class=\"" + template.HTMLEscapeString(frameData.Theme) + "-theme\">")); err != nil {
-			return
-		}
-		if err := h.Templates.ExecuteTemplate(w, "_csr_frame_body_elements", frameData); err != nil {
-			log.Printf("ServeSandboxContent (CSR): Error executing _csr_frame_body_elements: %v", err)
-			return
-		}
-		if _, err := w.Write([]byte("</body>\n</html>")); err != nil {
-			return
-		}
-		return // --- CSR handled ---
+	mode := h.RenderModes.Lookup(renderMode)
+	if mode == nil {
+		log.Printf("ServeSandboxContent: Invalid or missing renderMode '%s'", renderMode)
+		http.Error(w, "Invalid renderMode specified", http.StatusBadRequest)
+		return
 	}
 
-	// --- Handle SSR Mode ---
-	if renderMode == "ssr" {
-		log.Printf("ServeSandboxContent: Handling SSR request for %s/%s", componentName, storyKey)
-
-		if storyKey == "" {
-			log.Printf("ServeSandboxContent (SSR): StoryKey is required for SSR mode.")
-			h.serveSSRNotFoundErrorPage(w, componentName, "N/A", "StoryKey is required for SSR mode.")
-			return
-		}
-
-		var selectedComponent *models.ComponentGroup
-		var selectedStoryVariant *models.StoryVariant
-
-		for i := range h.Components {
-			if h.Components[i].Name == componentName {
-				comp := h.Components[i]
-				selectedComponent = &comp
-				for j := range comp.Variants {
-					if comp.Variants[j].Key == storyKey {
-						variant := comp.Variants[j]
-						selectedStoryVariant = &variant
-						break
-					}
-				}
-				break
-			}
-		}
-
-		if selectedComponent == nil {
-			log.Printf("ServeSandboxContent (SSR): Component '%s' not found.", componentName)
-			h.serveSSRNotFoundErrorPage(w, componentName, storyKey, "Component not found.")
-			return
-		}
-		if selectedStoryVariant == nil {
-			log.Printf("ServeSandboxContent (SSR): Story '%s' for component '%s' not found.", storyKey, componentName)
-			h.serveSSRNotFoundErrorPage(w, componentName, storyKey, "Story not found.")
-			return
-		}
-
-		storyTemplate := h.Templates.Lookup(selectedStoryVariant.Key)
-		if !selectedStoryVariant.HasSSR || storyTemplate == nil {
-			errorMessage := fmt.Sprintf("SSR template definition '%s' not found or story not marked for SSR.", selectedStoryVariant.Key)
-			log.Printf("ServeSandboxContent (SSR): Story %s/%s not SSR ready. HasSSR=%t, Template Found=%t", componentName, storyKey, selectedStoryVariant.HasSSR, storyTemplate != nil)
-			h.serveSSRNotFoundErrorPage(w, componentName, storyKey, errorMessage)
-			return
-		}
-
-		// --- SSR Possible: Render the story ---
-		componentCSSPath := fmt.Sprintf("/static/components/%s/%s.css", selectedComponent.Name, selectedComponent.Name)
-		storyArgsForTemplate := make(map[string]interface{})
-
-		if selectedStoryVariant.Args != nil {
-			for k, v := range selectedStoryVariant.Args {
-				storyArgsForTemplate[k] = v
-			}
-		}
-		for queryKey, queryValues := range query {
-			if len(queryValues) == 0 {
-				continue
-			}
-			if defaultValue, knownArg := storyArgsForTemplate[queryKey]; knownArg {
-				paramStrValue := queryValues[0]
-				switch defaultValue.(type) {
-				case bool:
-					storyArgsForTemplate[queryKey] = (strings.ToLower(paramStrValue) == "true")
-				case int, int64, float32, float64:
-					if num, err := strconv.ParseFloat(paramStrValue, 64); err == nil {
-						storyArgsForTemplate[queryKey] = num
-					} else {
-						storyArgsForTemplate[queryKey] = paramStrValue
-					}
-				default:
-					storyArgsForTemplate[queryKey] = paramStrValue
-				}
-			} else if queryKey != "renderMode" && queryKey != "theme" && queryKey != "componentName" && queryKey != "storyKey" {
-				storyArgsForTemplate[queryKey] = queryValues[0] // Include other query params as potential args
-			}
-		}
-		if selectedStoryVariant.Args != nil {
-			for defaultArgName, defaultValue := range selectedStoryVariant.Args {
-				if _, isBool := defaultValue.(bool); isBool {
-					if _, presentInQuery := query[defaultArgName]; !presentInQuery {
-						if _, setFromDefaults := storyArgsForTemplate[defaultArgName]; !setFromDefaults {
-							storyArgsForTemplate[defaultArgName] = false
-						}
-					}
-				}
-			}
-		}
-
-		if children, ok := storyArgsForTemplate["Children"].(string); ok {
-			storyArgsForTemplate["Children"] = template.HTML(children)
-		}
-		storyArgsForTemplate["Theme"] = theme
-
-		var ssrOutput bytes.Buffer
-		if err := storyTemplate.Execute(&ssrOutput, storyArgsForTemplate); err != nil {
-			log.Printf("ServeSandboxContent (SSR): Error executing story template '%s': %v", storyKey, err)
-			h.serveSSRExecutionErrorPage(w, componentName, storyKey, err)
-			return
-		}
-
-		layoutData := struct {
-			SSRContent       template.HTML
-			Theme            string
-			ComponentCSSPath string
-		}{
-			SSRContent:       template.HTML(ssrOutput.String()),
-			Theme:            theme,
-			ComponentCSSPath: componentCSSPath,
-		}
-
-		var finalOutput bytes.Buffer
-		layoutTemplate := h.Templates.Lookup("_ssr_content_layout")
-		if layoutTemplate == nil {
-			log.Printf("ServeSandboxContent (SSR): Layout template '_ssr_content_layout' not found.")
-			http.Error(w, "Internal Server Error: SSR Layout missing", http.StatusInternalServerError)
-			return
-		}
-		if err := layoutTemplate.Execute(&finalOutput, layoutData); err != nil {
-			log.Printf("ServeSandboxContent (SSR): Error executing layout template: %v", err)
-			http.Error(w, "Internal Server Error: Failed to render SSR layout", http.StatusInternalServerError)
-			return
-		}
-
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		_, err := w.Write(finalOutput.Bytes())
-		if err != nil {
-			log.Printf("ServeSandboxContent (SSR): Error writing final response: %v", err)
-		}
-		return // --- SSR handled ---
+	component := h.findComponent(componentName)
+	if component == nil {
+		log.Printf("ServeSandboxContent (%s): Component '%s' not found.", renderMode, componentName)
+		http.Error(w, "Component not found", http.StatusNotFound)
+		return
 	}
-
-	log.Printf("ServeSandboxContent: Invalid or missing renderMode '%s'", renderMode)
-	http.Error(w, "Invalid renderMode specified", http.StatusBadRequest)
+	var variant *models.StoryVariant
+	for i := range component.Variants {
+		if storyKey != "" && component.Variants[i].Key == storyKey {
+			variant = &component.Variants[i]
+			break
+		}
+	}
+
+	log.Printf("ServeSandboxContent: Handling %s request for %s/%s", mode.Name(), componentName, storyKey)
+	mode.ServeFrame(w, r, &Frame{
+		Story:        Story{Component: component, Variant: variant, Templates: h.Templates},
+		StoryKey:     storyKey,
+		Theme:        theme,
+		Query:        query,
+		StaticExport: isStaticExport(r),
+		Nonce:        cspNonce(r),
+		Handlers:     h,
+		logPrefix:    "ServeSandboxContent (" + mode.Name() + ")",
+	})
 }
 
 // NEW Helper function to specifically serve the CSR fallback frame
@@ -879,12 +749,11 @@ func (h *AppHandlers) serveCsrFallbackFrame(w http.ResponseWriter, r *http.Reque
 	config := make(map[string]interface{})
 	config["componentName"] = "fallback"
 	config["renderMode"] = "csr"
-	scriptToLoad := "/static/modules/sandbox/sandbox-fallback.js"
+	scriptToLoad := h.BasePath + "/static/modules/sandbox/sandbox-fallback.js"
 	// Pass any other relevant query params to config if needed by fallback script
-	for k, v := range query {
-		if len(v) > 0 && k != "renderMode" && k != "theme" && k != "componentName" {
-			config[k] = v[0]
-		}
+	extra, _ := h.Args.Undeclared(query, args.Spec{})
+	for k, v := range extra {
+		config[k] = v
 	}
 
 	configJSON, err := json.Marshal(config)
@@ -897,33 +766,43 @@ func (h *AppHandlers) serveCsrFallbackFrame(w http.ResponseWriter, r *http.Reque
 	frameData := models.CSRFrameData{
 		Theme:               theme,
 		SandboxConfigJSON:   template.JS(configJSON),
+		ImportMapJSON:       h.importMapHTML(),
+		StaticExport:        isStaticExport(r),
+		LiveReload:          h.liveReload(r),
+		Nonce:               cspNonce(r),
+		BasePath:            h.BasePath,
+		StaticBaseURL:       h.BasePath + "/static",
 		SandboxScriptToLoad: scriptToLoad,
 		IsFallback:          true, // Explicitly set
 	}
 
 	// Render the standard CSR frame structure
+	h.writeCSRFrame(w, frameData, "serveCsrFallbackFrame")
+}
+
+// writeCSRFrame writes a frame document from the _csr_frame_head and _csr_frame_body_elements
+// templates. logPrefix names the caller in error logs.
+func (h *AppHandlers) writeCSRFrame(w http.ResponseWriter, frameData models.CSRFrameData, logPrefix string) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if _, err := w.Write([]byte("<!DOCTYPE html>\n<html lang=\"en\">\n<head>")); err != nil {
 		return
 	}
 	if err := h.Templates.ExecuteTemplate(w, "_csr_frame_head", frameData); err != nil {
-		log.Printf("serveCsrFallbackFrame: Error executing _csr_frame_head: %v", err)
+		log.Printf("%s: Error executing _csr_frame_head: %v", logPrefix, err)
 		return
 	}
 	if _, err := w.Write([]byte("</head>\n<body class=\"" + template.HTMLEscapeString(frameData.Theme) + "-theme\">")); err != nil {
 		return
 	}
 	if err := h.Templates.ExecuteTemplate(w, "_csr_frame_body_elements", frameData); err != nil {
-		log.Printf("serveCsrFallbackFrame: Error executing _csr_frame_body_elements: %v", err)
-		return
-	}
-	if _, err := w.Write([]byte("</body>\n</html>")); err != nil {
+		log.Printf("%s: Error executing _csr_frame_body_elements: %v", logPrefix, err)
 		return
 	}
+	_, _ = w.Write([]byte("</body>\n</html>"))
 }
 
 // Helper function to serve a standard SSR "Not Found" error page
-func (h *AppHandlers) serveSSRNotFoundErrorPage(w http.ResponseWriter, comp, story, reason string) {
+func (h *AppHandlers) serveSSRNotFoundErrorPage(w http.ResponseWriter, nonce, comp, story, reason string) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusNotFound) // Not found is appropriate if template is missing
 	fmt.Fprintf(w, `<!DOCTYPE html>
@@ -931,16 +810,18 @@ func (h *AppHandlers) serveSSRNotFoundErrorPage(w http.ResponseWriter, comp, sto
 <head>
     <meta charset="UTF-8">
     <title>SSR Template Error</title>
-    <link rel="stylesheet" href="/static/styles/global.css" />
-    <style>body{padding: var(--space-5); background-color: var(--sage-1); color: var(--sage-12); font-family: var(--default-font-family);}</style>
+    <link rel="stylesheet" href="%s/static/styles/global.css" />
+    <style nonce="%s">body{padding: var(--space-5); background-color: var(--sage-1); color: var(--sage-12); font-family: var(--default-font-family);}</style>
 </head>
 <body>
     <h1>Server-Side Rendering Error</h1>
     <p>Could not render story <strong>%s / %s</strong> using SSR.</p>
     <p><strong>Reason:</strong> %s</p>
-    <p>Check component name, story key, and ensure the corresponding Go template (<code>{{define "%s"}}</code>) exists and was parsed.</p>
+    <p>Check component name, story key, and ensure the corresponding Go template (<code>{{define "%s"}}</code> in the component's <code>.stories.gohtml</code> file) exists and was parsed.</p>
 </body>
 </html>`,
+		template.HTMLEscapeString(h.BasePath),
+		template.HTMLEscapeString(nonce),
 		template.HTMLEscapeString(comp),
 		template.HTMLEscapeString(story),
 		template.HTMLEscapeString(reason),
@@ -948,7 +829,7 @@ func (h *AppHandlers) serveSSRNotFoundErrorPage(w http.ResponseWriter, comp, sto
 }
 
 // Helper function to serve a standard SSR "Execution Error" page
-func (h *AppHandlers) serveSSRExecutionErrorPage(w http.ResponseWriter, comp, story string, execErr error) {
+func (h *AppHandlers) serveSSRExecutionErrorPage(w http.ResponseWriter, nonce, comp, story string, execErr error) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusInternalServerError)
 	fmt.Fprintf(w, `<!DOCTYPE html>
@@ -956,8 +837,8 @@ func (h *AppHandlers) serveSSRExecutionErrorPage(w http.ResponseWriter, comp, st
 <head>
     <meta charset="UTF-8">
     <title>SSR Execution Error</title>
-    <link rel="stylesheet" href="/static/styles/global.css" />
-    <style>body{padding: var(--space-5); background-color: var(--sage-1); color: var(--sage-12); font-family: var(--default-font-family);}</style>
+    <link rel="stylesheet" href="%s/static/styles/global.css" />
+    <style nonce="%s">body{padding: var(--space-5); background-color: var(--sage-1); color: var(--sage-12); font-family: var(--default-font-family);}</style>
 </head>
 <body>
     <h1>Server-Side Rendering Error</h1>
@@ -966,6 +847,8 @@ func (h *AppHandlers) serveSSRExecutionErrorPage(w http.ResponseWriter, comp, st
     <p>Check the Go template syntax and the data being passed to it. See server logs for more details.</p>
 </body>
 </html>`,
+		template.HTMLEscapeString(h.BasePath),
+		template.HTMLEscapeString(nonce),
 		template.HTMLEscapeString(comp),
 		template.HTMLEscapeString(story),
 		template.HTMLEscapeString(execErr.Error()))
@@ -973,11 +856,17 @@ func (h *AppHandlers) serveSSRExecutionErrorPage(w http.ResponseWriter, comp, st
 
 // ServeFullBodyContent serves the entire body content
 func (h *AppHandlers) ServeFullBodyContent(w http.ResponseWriter, r *http.Request) {
+	h = h.snapshot()
 	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
-	isMachRequest := r.Header.Get("X-Mach-Request") == "true"
+	mach, err := readMachRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	isMachRequest := mach.Partial
 
 	if len(pathParts) <= 1 || pathParts[1] == "" {
-		h.serveBodyOrFullPageForHome(w, r, isMachRequest)
+		h.serveBodyOrFullPageForHome(w, r, mach)
 		return
 	}
 
@@ -1056,29 +945,16 @@ func (h *AppHandlers) ServeFullBodyContent(w http.ResponseWriter, r *http.Reques
 		pageTitle += " - Info"
 	}
 
-	var availableModes []string
-	if selectedStoryVariant != nil && selectedStoryVariant.HasCSR {
-		availableModes = append(availableModes, "csr")
-	}
-	ssrAvailable := false
-	if selectedStoryVariant != nil && selectedStoryVariant.HasSSR && h.Templates.Lookup(selectedStoryVariant.Key) != nil {
-		availableModes = append(availableModes, "ssr")
-		ssrAvailable = true
-	}
-	if isFallbackScenario && currentComponent.Path != "" {
-		if !slices.Contains(availableModes, "csr") {
-			availableModes = append(availableModes, "csr")
-		}
-	}
+	availableModes := h.RenderModes.Available(Story{Component: currentComponent, Variant: selectedStoryVariant, Templates: h.Templates})
+	ssrAvailable := slices.Contains(availableModes, "ssr")
 
-	effectiveTheme := r.URL.Query().Get("theme")
-	if effectiveTheme != "light" && effectiveTheme != "dark" {
-		effectiveTheme = "light"
-	}
+	effectiveTheme := h.theme(r.URL.Query().Get("theme"))
 
 	effectiveRenderMode := r.URL.Query().Get("renderMode")
 	if !slices.Contains(availableModes, effectiveRenderMode) {
-		if slices.Contains(availableModes, "csr") {
+		if slices.Contains(availableModes, h.DefaultRenderMode) {
+			effectiveRenderMode = h.DefaultRenderMode
+		} else if slices.Contains(availableModes, "csr") {
 			effectiveRenderMode = "csr"
 		} else if slices.Contains(availableModes, "ssr") {
 			effectiveRenderMode = "ssr"
@@ -1092,19 +968,25 @@ func (h *AppHandlers) ServeFullBodyContent(w http.ResponseWriter, r *http.Reques
 		effectiveRenderMode = "csr"
 	}
 
-	viewStoryPath := "/sandbox/" + componentNameParam
+	viewStoryPath := h.BasePath + "/sandbox/" + componentNameParam
 	if storyKeyParam != "" {
 		viewStoryPath += "/" + storyKeyParam
 	}
 
-	handlerPath := r.URL.Path
+	handlerPath := h.BasePath + r.URL.Path
 
 	data := models.PageData{
 		Title:                 pageTitle,
 		Components:            pageComponents,
 		SelectedComponent:     currentComponent,
 		SelectedStoryKey:      storyKeyParam,
-		StaticBaseURL:         "/static",
+		BasePath:              h.BasePath,
+		StaticBaseURL:         h.BasePath + "/static",
+		Diagnostics:           h.Diagnostics,
+		ImportMapJSON:         h.importMapHTML(),
+		StaticExport:          isStaticExport(r),
+		LiveReload:            h.liveReload(r),
+		Nonce:                 cspNonce(r),
 		RenderMode:            effectiveRenderMode,
 		AvailableRenderModes:  availableModes,
 		SSRAvailable:          ssrAvailable,
@@ -1112,9 +994,12 @@ func (h *AppHandlers) ServeFullBodyContent(w http.ResponseWriter, r *http.Reques
 		CurrentPath:           viewStoryPath,
 		CanClientSideNavigate: true,
 	}
+	if effectiveRenderMode == "hydrate" {
+		data.HydrationReport = h.Hydration.Latest(componentNameParam, storyKeyParam)
+	}
 
 	// --- Construct IframeSrcURL with dynamic path ---
-	iframePath := "/sandbox-content/" + componentNameParam
+	iframePath := h.BasePath + "/sandbox-content/" + componentNameParam
 	if storyKeyParam != "" {
 		iframePath += "/" + storyKeyParam
 	}
@@ -1123,113 +1008,38 @@ func (h *AppHandlers) ServeFullBodyContent(w http.ResponseWriter, r *http.Reques
 	iframeQuery.Set("renderMode", data.RenderMode)
 	iframeQuery.Set("theme", data.Theme)
 
-	// Parse args for PageData (for editor) AND for iframe query
-	currentStoryArgs := make(map[string]interface{})
-
+	// The args for the editor and the iframe query
+	argSpec := args.ForVariant(selectedStoryVariant)
+	storyArgs, extraArgs, argProblems := h.pageArgs(r.URL.Query(), argSpec, "ServeFullBodyContent: "+componentNameParam+"/"+storyKeyParam)
 	if selectedStoryVariant != nil && selectedStoryVariant.Args != nil {
-		requestQueryParams := r.URL.Query()
-		for argName, defaultValue := range selectedStoryVariant.Args {
-			currentVal := defaultValue
-			stringValForQuery := fmt.Sprintf("%v", defaultValue)
-			isDefaultBool := false
-			if _, ok := defaultValue.(bool); ok {
-				isDefaultBool = true
-			}
-			if queryVals, ok := requestQueryParams[argName]; ok && len(queryVals) > 0 {
-				paramStrValue := queryVals[0]
-				stringValForQuery = paramStrValue
-				if isDefaultBool {
-					currentVal = (strings.ToLower(paramStrValue) == "true")
-				} else {
-					switch defaultValue.(type) {
-					case int, int64, float32, float64:
-						if num, err := strconv.ParseFloat(paramStrValue, 64); err == nil {
-							currentVal = num
-						} else {
-							currentVal = paramStrValue
-						}
-					default:
-						currentVal = paramStrValue
-					}
-				}
-			} else {
-				if isDefaultBool {
-					currentVal = false
-					stringValForQuery = "false"
-				}
-			}
-			currentStoryArgs[argName] = currentVal
-			iframeQuery.Set(argName, stringValForQuery)
-		}
-		if defaultChildrenVal, ok := selectedStoryVariant.Args["Children"]; ok {
-			if _, defaultIsHTML := defaultChildrenVal.(template.HTML); defaultIsHTML {
-				if currentChildrenVal, currentOk := currentStoryArgs["Children"]; currentOk {
-					if childrenStr, valIsString := currentChildrenVal.(string); valIsString {
-						currentStoryArgs["Children"] = template.HTML(childrenStr)
-					}
-				}
-			}
-		}
+		args.Encode(storyArgs, iframeQuery)
+		data.SelectedStoryArgs = storyArgs
 	} else {
-		// Still parse query params even if no defaults defined
-		requestQueryParams := r.URL.Query()
-		for key, vals := range requestQueryParams {
-			if len(vals) > 0 && key != "renderMode" && key != "theme" && key != "componentName" && key != "storyKey" {
-				iframeQuery.Set(key, vals[0])
-			}
-		}
+		args.Encode(extraArgs, iframeQuery)
 	}
-	data.SelectedStoryArgs = currentStoryArgs
+	data.ArgProblems = argProblems
 	data.IframeSrcURL = iframePath + "?" + iframeQuery.Encode()
 	// --- End IframeSrcURL construction ---
 
-	toggleThemeQuery := r.URL.Query()
-	newThemeForToggle := "dark"
-	if effectiveTheme == "light" {
-		newThemeForToggle = "dark"
-	} else {
-		newThemeForToggle = "light"
-	}
-	toggleThemeQuery.Set("theme", newThemeForToggle)
-	data.ToggleThemeURL = (&url.URL{Path: handlerPath, RawQuery: toggleThemeQuery.Encode()}).String()
+	h.themeSelect(&data, handlerPath, r.URL.Query())
 
 	resetArgsQuery := r.URL.Query()
-	if selectedStoryVariant != nil && selectedStoryVariant.Args != nil {
-		for argName := range selectedStoryVariant.Args {
-			resetArgsQuery.Del(argName)
-		}
-	}
+	args.Delete(resetArgsQuery, argSpec)
 	data.ResetArgsURL = (&url.URL{Path: handlerPath, RawQuery: resetArgsQuery.Encode()}).String()
 
-	var modeLinks []models.ModeSwitchLink
-	for _, mode := range data.AvailableRenderModes {
-		queryForModeSwitch := r.URL.Query()
-		queryForModeSwitch.Set("renderMode", mode)
-		modeURL := (&url.URL{Path: handlerPath, RawQuery: queryForModeSwitch.Encode()}).String()
-		isActive := (mode == data.RenderMode)
-		var text string
-		if isActive {
-			text = strings.ToUpper(mode) + " Active"
-		} else {
-			text = "Switch to " + strings.ToUpper(mode)
-		}
-		modeLinks = append(modeLinks, models.ModeSwitchLink{ModeKey: mode, URL: modeURL, IsActive: isActive, Text: text})
-	}
-	data.ModeSwitchLinks = modeLinks
+	data.ModeSwitchLinks = h.modeSwitchLinks(data.AvailableRenderModes, data.RenderMode, handlerPath, r.URL.Query())
+	h.viewportSelect(&data, handlerPath, r.URL.Query())
 
-	var toolbarBuf bytes.Buffer
-	if err := h.Templates.ExecuteTemplate(&toolbarBuf, "toolbar-content", data); err != nil {
-		log.Printf("ServeFullBodyContent: Error rendering toolbar: %v", err)
-	}
-	data.ToolbarHTML = template.HTML(toolbarBuf.String())
-
-	var argsEditorBuf bytes.Buffer
-	if err := h.Templates.ExecuteTemplate(&argsEditorBuf, "story-args-editor-content", data); err != nil {
-		log.Printf("ServeFullBodyContent: Error rendering args editor: %v", err)
+	w.Header().Set(MachTitleHeader, pageTitle)
+	if isMachRequest {
+		w.Header().Set(MachPushURLHeader, storyPageURL(viewStoryPath, r.URL.Query(), effectiveRenderMode, effectiveTheme))
+		if mach.Targets != nil {
+			log.Printf("ServeFullBodyContent: Mach request for %s. Serving %v.", r.URL.Path, mach.Targets)
+			h.writeMachFragments(w, data, mach.Targets, "ServeFullBodyContent")
+			return
+		}
 	}
-	data.StoryArgsEditorHTML = template.HTML(argsEditorBuf.String())
-
-	w.Header().Set("X-Mach-Title", pageTitle)
+	h.renderPanels(&data, "ServeFullBodyContent")
 
 	if isMachRequest {
 		log.Printf("ServeFullBodyContent: Mach request for %s. Serving BODY content.", r.URL.Path)
@@ -1239,55 +1049,43 @@ func (h *AppHandlers) ServeFullBodyContent(w http.ResponseWriter, r *http.Reques
 			http.Error(w, "Failed to render body content fragment", http.StatusInternalServerError)
 		}
 	} else {
-		log.Printf("ServeFullBodyContent: Non-Mach request for %s. Serving FULL page for HOME using _document_head and full-body-content.", r.URL.Path)
+		log.Printf("ServeFullBodyContent: Non-Mach request for %s. Serving FULL page using _document_head and full-body-content.", r.URL.Path)
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		if _, err := w.Write([]byte("<!DOCTYPE html>\n<html lang=\"en\">")); err != nil {
-			log.Printf("serveBodyOrFullPageForHome: Error writing initial HTML: %v", err)
+			log.Printf("ServeFullBodyContent: Error writing initial HTML: %v", err)
 			return
 		}
 		if err := h.Templates.ExecuteTemplate(w, "_document_head", data); err != nil {
-			log.Printf("serveBodyOrFullPageForHome: Error executing _document_head for HOME: %v", err)
+			log.Printf("ServeFullBodyContent: Error executing _document_head: %v", err)
 			_, _ = w.Write([]byte("</html>")) // Best effort
 			return
 		}
 		if err := h.Templates.ExecuteTemplate(w, "full-body-content", data); err != nil {
-			log.Printf("serveBodyOrFullPageForHome: Error executing full-body-content for HOME: %v", err)
+			log.Printf("ServeFullBodyContent: Error executing full-body-content: %v", err)
 			_, _ = w.Write([]byte("</html>")) // Best effort
 			return
 		}
 		if _, err := w.Write([]byte("</html>")); err != nil {
-			log.Printf("serveBodyOrFullPageForHome: Error writing closing HTML tag for HOME: %v", err)
+			log.Printf("ServeFullBodyContent: Error writing closing HTML tag: %v", err)
 		}
 	}
 }
 
 // serveBodyOrFullPageForHome handles requests to /sandbox-body-swap/ (home context)
-func (h *AppHandlers) serveBodyOrFullPageForHome(w http.ResponseWriter, r *http.Request, isMachRequest bool) {
-	currentTheme := r.URL.Query().Get("theme")
-	if currentTheme != "light" && currentTheme != "dark" {
-		currentTheme = "light"
-	}
+func (h *AppHandlers) serveBodyOrFullPageForHome(w http.ResponseWriter, r *http.Request, mach machRequest) {
+	currentTheme := h.theme(r.URL.Query().Get("theme"))
 
 	pageComponents := make([]models.ComponentGroup, len(h.Components))
 	copy(pageComponents, h.Components) // Use a copy
 	for i := range pageComponents {
 		pageComponents[i].IsSelected = false
+		pageComponents[i].Variants = slices.Clone(pageComponents[i].Variants) // Shared with other requests
 		for j := range pageComponents[i].Variants {
 			pageComponents[i].Variants[j].IsSelected = false
 		}
 	}
 
-	handlerPath := "/sandbox-body-swap/" // Base path for home context of this handler
-
-	homeToggleThemeQuery := r.URL.Query()
-	newThemeForToggle := "dark"
-	if currentTheme == "light" {
-		newThemeForToggle = "dark"
-	} else {
-		newThemeForToggle = "light"
-	}
-	homeToggleThemeQuery.Set("theme", newThemeForToggle)
-	homeToggleThemeURL := url.URL{Path: handlerPath, RawQuery: homeToggleThemeQuery.Encode()}
+	handlerPath := h.BasePath + "/sandbox-body-swap/" // Base path for home context of this handler
 
 	homeResetArgsQuery := r.URL.Query()
 	homeResetArgsURL := url.URL{Path: handlerPath, RawQuery: homeResetArgsQuery.Encode()}
@@ -1295,31 +1093,34 @@ func (h *AppHandlers) serveBodyOrFullPageForHome(w http.ResponseWriter, r *http.
 	data := models.PageData{
 		Title:                 "Component Playground",
 		Components:            pageComponents,
-		StaticBaseURL:         "/static",
+		BasePath:              h.BasePath,
+		StaticBaseURL:         h.BasePath + "/static",
+		Diagnostics:           h.Diagnostics,
+		ImportMapJSON:         h.importMapHTML(),
+		StaticExport:          isStaticExport(r),
+		LiveReload:            h.liveReload(r),
+		Nonce:                 cspNonce(r),
 		Theme:                 currentTheme,
-		ToggleThemeURL:        homeToggleThemeURL.String(),
 		ResetArgsURL:          homeResetArgsURL.String(),
-		CurrentPath:           "/",
+		CurrentPath:           h.BasePath + "/",
 		RenderMode:            "csr",
-		IframeSrcURL:          "/sandbox-content/fallback?renderMode=csr&theme=" + currentTheme,
+		IframeSrcURL:          h.BasePath + "/sandbox-content/fallback?renderMode=csr&theme=" + url.QueryEscape(currentTheme),
 		CanClientSideNavigate: true,
 	}
+	h.themeSelect(&data, handlerPath, r.URL.Query())
 
-	var toolbarBuf bytes.Buffer
-	if err := h.Templates.ExecuteTemplate(&toolbarBuf, "toolbar-content", data); err != nil {
-		log.Printf("serveBodyOrFullPageForHome: Error rendering toolbar: %v", err)
-	}
-	data.ToolbarHTML = template.HTML(toolbarBuf.String())
-
-	var argsEditorBuf bytes.Buffer
-	if err := h.Templates.ExecuteTemplate(&argsEditorBuf, "story-args-editor-content", data); err != nil {
-		log.Printf("serveBodyOrFullPageForHome: Error rendering args editor: %v", err)
+	w.Header().Set(MachTitleHeader, data.Title)
+	if mach.Partial {
+		w.Header().Set(MachPushURLHeader, (&url.URL{Path: h.BasePath + "/", RawQuery: r.URL.RawQuery}).String())
+		if mach.Targets != nil {
+			log.Printf("serveBodyOrFullPageForHome: Mach request for %s. Serving %v for HOME.", r.URL.Path, mach.Targets)
+			h.writeMachFragments(w, data, mach.Targets, "serveBodyOrFullPageForHome")
+			return
+		}
 	}
-	data.StoryArgsEditorHTML = template.HTML(argsEditorBuf.String())
+	h.renderPanels(&data, "serveBodyOrFullPageForHome")
 
-	w.Header().Set("X-Mach-Title", data.Title)
-
-	if isMachRequest {
+	if mach.Partial {
 		log.Printf("serveBodyOrFullPageForHome: Mach request for %s. Serving BODY content for HOME.", r.URL.Path)
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		if err := h.Templates.ExecuteTemplate(w, "full-body-content", data); err != nil {
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+)
+
+// Hydrate mode shows a story the way production pages work: the frame carries the SSR
+// template output, and iframe-client.js hydrates the Preact story over it with the same args.
+// While hydrating, the client compares what Preact renders with the server markup and posts
+// the mismatches to /sandbox/api/hydration/{componentName}/{storyKey}; the toolbar shows the
+// latest report of the selected story.
+
+// maxHydrationReportSize bounds the body of a posted report.
+const maxHydrationReportSize = 1 << 20
+
+// HydrationReports keeps the latest hydration report of every story.
+type HydrationReports struct {
+	mu      sync.Mutex
+	reports map[string]models.HydrationReport // By "componentName/storyKey"
+}
+
+// NewHydrationReports returns an empty report store.
+func NewHydrationReports() *HydrationReports {
+	return &HydrationReports{reports: make(map[string]models.HydrationReport)}
+}
+
+// Latest returns the last report of a story, or nil if none was posted yet.
+func (s *HydrationReports) Latest(componentName, storyKey string) *models.HydrationReport {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	report, ok := s.reports[componentName+"/"+storyKey]
+	if !ok {
+		return nil
+	}
+	return &report
+}
+
+func (s *HydrationReports) record(report models.HydrationReport) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.reports[report.Component+"/"+report.StoryKey] = report
+	s.mu.Unlock()
+}
+
+// hydrationReportURL is where a hydrate frame posts its report.
+func (h *AppHandlers) hydrationReportURL(componentName, storyKey string) string {
+	return h.BasePath + "/sandbox/api/hydration/" + url.PathEscape(componentName) + "/" + url.PathEscape(storyKey)
+}
+
+// hydrateMode serves the CSR frame with the SSR output already in its mount point and the
+// args in its config.
+type hydrateMode struct{}
+
+func (hydrateMode) Name() string  { return "hydrate" }
+func (hydrateMode) Label() string { return "Hydrate" }
+
+// Available needs both a Preact story module and a parsed SSR template.
+func (hydrateMode) Available(story Story) bool {
+	return story.Component.Path != "" && story.Variant != nil && story.Variant.HasCSR && story.HasSSRTemplate()
+}
+
+func (mode hydrateMode) ServeFrame(w http.ResponseWriter, r *http.Request, frame *Frame) {
+	h := frame.Handlers
+	if frame.Variant == nil {
+		frame.NotFound(w, "Story not found.")
+		return
+	}
+	if !mode.Available(frame.Story) {
+		frame.NotFound(w, fmt.Sprintf("Hydration needs both a story module and the SSR template definition '%s'.", frame.Variant.SSRTemplateName))
+		return
+	}
+
+	args := frame.Args()
+	ssrOutput, err := frame.RenderSSR(args)
+	if err != nil {
+		log.Printf("%s: Error executing story template '%s': %v", frame.logPrefix, frame.StoryKey, err)
+		h.serveSSRExecutionErrorPage(w, frame.Nonce, frame.Component.Name, frame.StoryKey, err)
+		return
+	}
+
+	config := map[string]interface{}{
+		"componentName":   frame.Component.Name,
+		"storyKey":        frame.Variant.Key,
+		"storyModulePath": h.BasePath + "/static/" + frame.Component.Path,
+		"renderMode":      "hydrate",
+		"theme":           frame.Theme,
+		"currentArgs":     args,
+	}
+	if !frame.StaticExport {
+		config["hydrationReportURL"] = h.hydrationReportURL(frame.Component.Name, frame.Variant.Key)
+	}
+	frameData, err := frame.CSRFrameData(config, h.BasePath+"/static/modules/sandbox/iframe-client.js")
+	if err != nil {
+		log.Printf("%s: Error marshalling config: %v", frame.logPrefix, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	frameData.SSRContent = ssrOutput
+	frameData.ComponentCSSPath = frame.ComponentCSSPath()
+	frame.WriteCSRFrame(w, frameData)
+}
+
+// HydrationReport serves /sandbox/api/hydration/{componentName}/{storyKey}: hydrate frames
+// POST their report to it, and GET returns the latest one.
+func (h *AppHandlers) HydrationReport(w http.ResponseWriter, r *http.Request) {
+	h = h.snapshot()
+	componentName, storyKey := r.PathValue("componentName"), r.PathValue("storyKey")
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		report := h.Hydration.Latest(componentName, storyKey)
+		if report == nil {
+			writeJSONError(w, http.StatusNotFound, "no hydration report for "+componentName+"/"+storyKey)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	case http.MethodPost:
+		component := h.findComponent(componentName)
+		if component == nil {
+			writeJSONError(w, http.StatusNotFound, "component not found: "+componentName)
+			return
+		}
+		// Only stories that exist are recorded, so made-up keys cannot grow the store
+		if !slices.ContainsFunc(component.Variants, func(variant models.StoryVariant) bool { return variant.Key == storyKey }) {
+			writeJSONError(w, http.StatusNotFound, "story not found: "+componentName+"/"+storyKey)
+			return
+		}
+		var report models.HydrationReport
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxHydrationReportSize))
+		if err == nil {
+			err = json.Unmarshal(body, &report)
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid hydration report: "+err.Error())
+			return
+		}
+		report.Component, report.StoryKey = component.Name, storyKey
+		report.ReceivedAt = time.Now()
+		if report.Mismatches == nil {
+			report.Mismatches = []models.ParityDifference{}
+		}
+		h.Hydration.record(report)
+		switch {
+		case report.Error != "":
+			log.Printf("Hydration: %s/%s failed: %s", componentName, storyKey, report.Error)
+		case len(report.Mismatches) > 0:
+			log.Printf("Hydration: %s/%s: %d mismatches between the SSR markup and the Preact render", componentName, storyKey, len(report.Mismatches))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
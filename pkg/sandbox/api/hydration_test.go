@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+)
+
+func TestHydrationReportPost(t *testing.T) {
+	tests := []struct {
+		name          string
+		componentName string
+		storyKey      string
+		body          string
+		want          int
+		stored        int // Reports in the store afterwards
+	}{
+		{name: "story", componentName: "button", storyKey: "Primary", body: `{"mismatches": []}`, want: http.StatusNoContent, stored: 1},
+		{name: "unknown story", componentName: "button", storyKey: "Made-up", body: `{"mismatches": []}`, want: http.StatusNotFound},
+		{name: "unknown component", componentName: "made-up", storyKey: "Primary", body: `{"mismatches": []}`, want: http.StatusNotFound},
+		{name: "invalid report", componentName: "button", storyKey: "Primary", body: `{`, want: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := &AppHandlers{
+				Components: []models.ComponentGroup{{Name: "button", Variants: []models.StoryVariant{{Key: "Primary"}}}},
+				Hydration:  NewHydrationReports(),
+			}
+			r := httptest.NewRequest(http.MethodPost, "/sandbox/api/hydration/"+test.componentName+"/"+test.storyKey, strings.NewReader(test.body))
+			r.SetPathValue("componentName", test.componentName)
+			r.SetPathValue("storyKey", test.storyKey)
+			w := httptest.NewRecorder()
+			h.HydrationReport(w, r)
+			if w.Code != test.want {
+				t.Errorf("POST %s/%s = %d, want %d", test.componentName, test.storyKey, w.Code, test.want)
+			}
+			if stored := len(h.Hydration.reports); stored != test.stored {
+				t.Errorf("POST %s/%s left %d reports, want %d", test.componentName, test.storyKey, stored, test.stored)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+)
+
+// The X-Mach protocol is how the mach-link and mach-form components, and the sandbox's own
+// mach-targets.js, update the manager page in place instead of loading a new one. The story
+// pages (/sandbox/...) and the body swaps (/sandbox-body-swap/...) speak it.
+//
+// A partial request carries
+//
+//	X-Mach-Request: true
+//	X-Mach-Target: toolbar, iframe
+//	X-Mach-Nonce: <the page's CSP nonce>
+//
+// X-Mach-Target names the regions of the page the client wants back, comma-separated: nav,
+// toolbar, args-editor and iframe (the story frame and its viewport container; the frame's src
+// carries the render mode, theme and args). Without it the handlers send the whole body,
+// full-body-content. An unknown region is a 400. X-Mach-Nonce is the nonce of the page the
+// fragments go into, from its <meta name="mach-nonce">; the fragments' inline scripts and
+// styles carry it, so the page's policy allows them (see withCSP).
+//
+// The response body holds one out-of-band fragment per region, in the requested order:
+//
+//	<div data-mach-target-selector="#mach-region-toolbar" data-mach-region="toolbar">…</div>
+//
+// The fragment's content replaces the content of the element the selector matches, a
+// display: contents wrapper around the region in full-body-content. The response headers are
+//
+//	X-Mach-Title     the document title
+//	X-Mach-Push-URL  the URL to push onto the history: the shareable story page, also for
+//	                 body swaps, with the render mode and theme made explicit
+//	X-Mach-Redirect  the URL to navigate to instead, with an empty 204 response; fetch would
+//	                 follow a 302 and show the new page's fragments under the old URL
+//
+// Responses vary on X-Mach-Request, X-Mach-Target and X-Mach-Nonce.
+const (
+	MachRequestHeader  = "X-Mach-Request"
+	MachTargetHeader   = "X-Mach-Target"
+	MachNonceHeader    = "X-Mach-Nonce"
+	MachTitleHeader    = "X-Mach-Title"
+	MachPushURLHeader  = "X-Mach-Push-URL"
+	MachRedirectHeader = "X-Mach-Redirect"
+)
+
+// The regions of the manager page a partial response can replace.
+const (
+	RegionNav        = "nav"
+	RegionToolbar    = "toolbar"
+	RegionArgsEditor = "args-editor"
+	RegionIframe     = "iframe"
+)
+
+// machRegion is how a region is rendered and where its fragment goes.
+type machRegion struct {
+	selector string // The element whose content the fragment replaces
+	template string // Renders the fragment from models.PageData
+}
+
+var machRegions = map[string]machRegion{
+	RegionNav:        {selector: "#mach-region-nav", template: "navigation-content"},
+	RegionToolbar:    {selector: "#mach-region-toolbar", template: "toolbar-content"},
+	RegionArgsEditor: {selector: "#mach-region-args-editor", template: "story-args-editor-content"},
+	RegionIframe:     {selector: "#mach-region-iframe", template: "story-iframe"},
+}
+
+// machRequest is what a request asks for under the protocol.
+type machRequest struct {
+	Partial bool     // X-Mach-Request: true
+	Targets []string // The regions of X-Mach-Target; nil means the handler's default
+}
+
+// readMachRequest returns the protocol headers of r and marks the response as varying on
+// them. An X-Mach-Target with an unknown region is an error.
+func readMachRequest(w http.ResponseWriter, r *http.Request) (machRequest, error) {
+	w.Header().Add("Vary", MachRequestHeader+", "+MachTargetHeader+", "+MachNonceHeader)
+	mach := machRequest{Partial: r.Header.Get(MachRequestHeader) == "true"}
+	if !mach.Partial {
+		return mach, nil
+	}
+	for _, header := range r.Header.Values(MachTargetHeader) {
+		for _, name := range strings.Split(header, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if _, ok := machRegions[name]; !ok {
+				return mach, fmt.Errorf("unknown %s region %q", MachTargetHeader, name)
+			}
+			if !slices.Contains(mach.Targets, name) {
+				mach.Targets = append(mach.Targets, name)
+			}
+		}
+	}
+	return mach, nil
+}
+
+// renderMachFragments renders the named regions of data as out-of-band fragments.
+func (h *AppHandlers) renderMachFragments(data models.PageData, regions []string) ([]byte, error) {
+	var out bytes.Buffer
+	for _, name := range regions {
+		region := machRegions[name]
+		fmt.Fprintf(&out, "<div data-mach-target-selector=\"%s\" data-mach-region=\"%s\">", template.HTMLEscapeString(region.selector), name)
+		if err := h.Templates.ExecuteTemplate(&out, region.template, data); err != nil {
+			return nil, fmt.Errorf("region %s: %w", name, err)
+		}
+		out.WriteString("</div>")
+	}
+	return out.Bytes(), nil
+}
+
+// writeMachFragments writes the named regions of data as a partial response. logPrefix names
+// the caller in error logs.
+func (h *AppHandlers) writeMachFragments(w http.ResponseWriter, data models.PageData, regions []string, logPrefix string) {
+	fragments, err := h.renderMachFragments(data, regions)
+	if err != nil {
+		log.Printf("%s: Error rendering partial response: %v", logPrefix, err)
+		http.Error(w, "Failed to render partial content", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := w.Write(fragments); err != nil {
+		log.Printf("%s: Error writing partial response: %v", logPrefix, err)
+	}
+}
+
+// machRedirect redirects a full page load with a 302, and a partial request with
+// X-Mach-Redirect.
+func machRedirect(w http.ResponseWriter, r *http.Request, mach machRequest, target string) {
+	if !mach.Partial {
+		http.Redirect(w, r, target, http.StatusFound)
+		return
+	}
+	w.Header().Set(MachRedirectHeader, target)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// storyPageURL is the shareable URL of a story page at path: query with the render mode and
+// theme made explicit.
+func storyPageURL(path string, query url.Values, renderMode, theme string) string {
+	canonical := url.Values{}
+	for name, values := range query {
+		canonical[name] = slices.Clone(values)
+	}
+	canonical.Set("renderMode", renderMode)
+	canonical.Set("theme", theme)
+	return (&url.URL{Path: path, RawQuery: canonical.Encode()}).String()
+}
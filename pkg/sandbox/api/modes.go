@@ -0,0 +1,334 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"kormsen.com/machine-ui/pkg/sandbox/args"
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+	"kormsen.com/machine-ui/pkg/sandbox/renderer"
+)
+
+// RenderMode is one way of showing a story in the sandbox iframe, selected by the
+// `renderMode` query parameter. The built-in modes are CSR, SSR and hydrate (see
+// NewRenderModeRegistry); more can be registered on AppHandlers.RenderModes, e.g. an AMP or
+// email-safe HTML rendering, and show up in the toolbar of every story they are available for.
+type RenderMode interface {
+	// Name is the query value, e.g. "csr". It is also part of exported file names.
+	Name() string
+	// Label is the toolbar text, e.g. "CSR".
+	Label() string
+	// Available reports whether the mode can show a story. Story.Variant is nil for a
+	// component without stories.
+	Available(story Story) bool
+	// ServeFrame writes the iframe document for a story. It is called for any story of an
+	// existing component, including ones the mode is not available for, and reports those
+	// itself.
+	ServeFrame(w http.ResponseWriter, r *http.Request, frame *Frame)
+}
+
+// Story is a story a render mode is asked about.
+type Story struct {
+	Component *models.ComponentGroup
+	Variant   *models.StoryVariant // nil when the component has no story of the requested key
+	Templates *template.Template   // The parsed SSR templates
+}
+
+// HasSSRTemplate reports whether the story's SSR template was parsed.
+func (s Story) HasSSRTemplate() bool {
+	return s.Variant != nil && s.Variant.HasSSR && s.Templates != nil && s.Templates.Lookup(s.Variant.SSRTemplateName) != nil
+}
+
+// Frame is the request for a story frame, /sandbox-content/{componentName}/{storyKey}.
+type Frame struct {
+	Story
+	StoryKey     string       // As requested; Variant is nil if no story has it
+	Theme        string       // The requested theme, or the default
+	Query        url.Values   // The frame URL's query: render mode, theme and args
+	StaticExport bool         // The frame is written to disk by the export command
+	Nonce        string       // The CSP nonce of the response, for inline scripts and styles
+	Handlers     *AppHandlers // The handlers serving the request, for BasePath, the import map etc.
+	logPrefix    string       // "ServeSandboxContent (csr)"
+}
+
+// Args returns the args the story renders with: its defaults with the query applied, and any
+// other query value that is not one of the frame's own parameters.
+func (f *Frame) Args() map[string]interface{} {
+	spec := args.ForVariant(f.Variant)
+	values, problems := f.Handlers.Args.Decode(f.Query, spec)
+	extra, extraProblems := f.Handlers.Args.Undeclared(f.Query, spec)
+	for _, problem := range append(problems, extraProblems...) {
+		log.Printf("%s: %s/%s: %v", f.logPrefix, f.Component.Name, f.StoryKey, problem)
+	}
+	for name, value := range extra {
+		values[name] = value
+	}
+	return values
+}
+
+// RenderSSR executes the story's SSR template with args.
+func (f *Frame) RenderSSR(args map[string]interface{}) (template.HTML, error) {
+	return renderer.RenderStory(f.Templates, f.Variant.SSRTemplateName, args, f.Theme)
+}
+
+// LiveReload reports whether the frame loads live-reload.js, see AppHandlers.liveReload.
+func (f *Frame) LiveReload() bool {
+	return f.Handlers.Events != nil && !f.StaticExport
+}
+
+// ComponentCSSPath is the URL of the component's stylesheet, linked by server-rendered frames.
+func (f *Frame) ComponentCSSPath() string {
+	return fmt.Sprintf("%s/static/components/%s/%s.css", f.Handlers.BasePath, f.Component.Name, f.Component.Name)
+}
+
+// CSRFrameData returns the data of a CSR frame document that loads script with config as
+// its #sandbox-config.
+func (f *Frame) CSRFrameData(config map[string]interface{}, script string) (models.CSRFrameData, error) {
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return models.CSRFrameData{}, err
+	}
+	return models.CSRFrameData{
+		Theme:               f.Theme,
+		SandboxConfigJSON:   template.JS(configJSON),
+		ImportMapJSON:       f.Handlers.importMapHTML(),
+		StaticExport:        f.StaticExport,
+		LiveReload:          f.LiveReload(),
+		Nonce:               f.Nonce,
+		BasePath:            f.Handlers.BasePath,
+		StaticBaseURL:       f.Handlers.BasePath + "/static",
+		SandboxScriptToLoad: script,
+	}, nil
+}
+
+// WriteCSRFrame writes a frame document from the _csr_frame_head and _csr_frame_body_elements
+// templates.
+func (f *Frame) WriteCSRFrame(w http.ResponseWriter, data models.CSRFrameData) {
+	f.Handlers.writeCSRFrame(w, data, f.logPrefix)
+}
+
+// NotFound writes the error page of a story the mode cannot show, with a 404 status.
+func (f *Frame) NotFound(w http.ResponseWriter, reason string) {
+	log.Printf("%s: %s/%s: %s", f.logPrefix, f.Component.Name, f.StoryKey, reason)
+	f.Handlers.serveSSRNotFoundErrorPage(w, f.Nonce, f.Component.Name, f.StoryKey, reason)
+}
+
+// RenderModeRegistry lists the render modes in toolbar order. Register modes before the
+// handlers serve requests; the registry is not safe for concurrent modification.
+type RenderModeRegistry struct {
+	modes []RenderMode
+}
+
+// NewRenderModeRegistry returns a registry with the built-in modes: CSR, SSR and hydrate.
+func NewRenderModeRegistry() *RenderModeRegistry {
+	registry := &RenderModeRegistry{}
+	registry.Register(csrMode{})
+	registry.Register(ssrMode{})
+	registry.Register(hydrateMode{})
+	return registry
+}
+
+// Register adds a mode after the existing ones, or replaces the mode of the same name in place.
+func (m *RenderModeRegistry) Register(mode RenderMode) {
+	for i, existing := range m.modes {
+		if existing.Name() == mode.Name() {
+			m.modes[i] = mode
+			return
+		}
+	}
+	m.modes = append(m.modes, mode)
+}
+
+// Lookup returns the mode of a name, or nil.
+func (m *RenderModeRegistry) Lookup(name string) RenderMode {
+	if m == nil {
+		return nil
+	}
+	for _, mode := range m.modes {
+		if mode.Name() == name {
+			return mode
+		}
+	}
+	return nil
+}
+
+// Names lists the names of all registered modes.
+func (m *RenderModeRegistry) Names() []string {
+	if m == nil {
+		return nil
+	}
+	names := make([]string, len(m.modes))
+	for i, mode := range m.modes {
+		names[i] = mode.Name()
+	}
+	return names
+}
+
+// Available lists the names of the modes available for a story, in registry order.
+func (m *RenderModeRegistry) Available(story Story) []string {
+	if m == nil || story.Component == nil {
+		return nil
+	}
+	var names []string
+	for _, mode := range m.modes {
+		if mode.Available(story) {
+			names = append(names, mode.Name())
+		}
+	}
+	return names
+}
+
+// modeSwitchLinks builds the toolbar's render mode buttons: one link per available mode to
+// path with the query's renderMode replaced.
+func (h *AppHandlers) modeSwitchLinks(available []string, active, path string, query url.Values) []models.ModeSwitchLink {
+	var links []models.ModeSwitchLink
+	for _, name := range available {
+		label := strings.ToUpper(name)
+		if mode := h.RenderModes.Lookup(name); mode != nil {
+			label = mode.Label()
+		}
+		modeQuery := url.Values{}
+		for key, values := range query {
+			modeQuery[key] = values
+		}
+		modeQuery.Set("renderMode", name)
+		isActive := name == active
+		text := "Switch to " + label
+		if isActive {
+			text = label + " Active"
+		}
+		links = append(links, models.ModeSwitchLink{
+			ModeKey:  name,
+			URL:      (&url.URL{Path: path, RawQuery: modeQuery.Encode()}).String(),
+			IsActive: isActive,
+			Text:     text,
+		})
+	}
+	return links
+}
+
+// csrMode renders the story with Preact in the frame. A component without stories gets the
+// fallback frame, which lists what the component module exports.
+type csrMode struct{}
+
+func (csrMode) Name() string  { return "csr" }
+func (csrMode) Label() string { return "CSR" }
+
+func (csrMode) Available(story Story) bool {
+	if story.Variant == nil {
+		return story.Component.Path != ""
+	}
+	return story.Variant.HasCSR
+}
+
+func (csrMode) ServeFrame(w http.ResponseWriter, r *http.Request, frame *Frame) {
+	h := frame.Handlers
+	config := map[string]interface{}{
+		"componentName": frame.Component.Name,
+		"renderMode":    "csr", // Pass effective mode to client script
+	}
+	currentArgs := make(map[string]interface{})
+	var scriptToLoad string
+	if frame.Variant != nil { // Specific Story
+		config["storyKey"] = frame.Variant.Key
+		if frame.Component.Path != "" {
+			config["storyModulePath"] = h.BasePath + "/static/" + frame.Component.Path
+		}
+		scriptToLoad = h.BasePath + "/static/modules/sandbox/iframe-client.js"
+		currentArgs = frame.Args()
+	} else { // Fallback / Component View
+		config["componentTitle"] = frame.Component.Title
+		if frame.Component.Path != "" {
+			config["componentPath"] = h.BasePath + "/static/" + frame.Component.Path
+		}
+		scriptToLoad = h.BasePath + "/static/modules/sandbox/sandbox-fallback.js"
+		currentArgs, _ = h.Args.Undeclared(frame.Query, args.Spec{})
+	}
+	if len(currentArgs) > 0 {
+		config["currentArgs"] = currentArgs
+	}
+
+	frameData, err := frame.CSRFrameData(config, scriptToLoad)
+	if err != nil {
+		log.Printf("%s: Error marshalling config: %v", frame.logPrefix, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	frameData.IsFallback = frame.Variant == nil
+	frame.WriteCSRFrame(w, frameData)
+}
+
+// ssrMode renders the story's Go template into _ssr_content_layout.
+type ssrMode struct{}
+
+func (ssrMode) Name() string  { return "ssr" }
+func (ssrMode) Label() string { return "SSR" }
+
+func (ssrMode) Available(story Story) bool {
+	return story.HasSSRTemplate()
+}
+
+func (ssrMode) ServeFrame(w http.ResponseWriter, r *http.Request, frame *Frame) {
+	h := frame.Handlers
+	if frame.StoryKey == "" {
+		frame.NotFound(w, "StoryKey is required for SSR mode.")
+		return
+	}
+	if frame.Variant == nil {
+		frame.NotFound(w, "Story not found.")
+		return
+	}
+	if !frame.HasSSRTemplate() {
+		frame.NotFound(w, fmt.Sprintf("SSR template definition '%s' (from %s) not found or story not marked for SSR.", frame.Variant.SSRTemplateName, frame.Component.SSRGoHTMLPath))
+		return
+	}
+
+	ssrOutput, err := frame.RenderSSR(frame.Args())
+	if err != nil {
+		log.Printf("%s: Error executing story template '%s': %v", frame.logPrefix, frame.StoryKey, err)
+		h.serveSSRExecutionErrorPage(w, frame.Nonce, frame.Component.Name, frame.StoryKey, err)
+		return
+	}
+
+	layoutData := struct {
+		SSRContent       template.HTML
+		Theme            string
+		ComponentCSSPath string
+		StaticExport     bool
+		LiveReload       bool
+		Nonce            string
+		StaticBaseURL    string
+	}{
+		SSRContent:       ssrOutput,
+		Theme:            frame.Theme,
+		ComponentCSSPath: frame.ComponentCSSPath(),
+		StaticExport:     frame.StaticExport,
+		LiveReload:       frame.LiveReload(),
+		Nonce:            frame.Nonce,
+		StaticBaseURL:    h.BasePath + "/static",
+	}
+
+	var finalOutput bytes.Buffer
+	layoutTemplate := h.Templates.Lookup("_ssr_content_layout")
+	if layoutTemplate == nil {
+		log.Printf("%s: Layout template '_ssr_content_layout' not found.", frame.logPrefix)
+		http.Error(w, "Internal Server Error: SSR Layout missing", http.StatusInternalServerError)
+		return
+	}
+	if err := layoutTemplate.Execute(&finalOutput, layoutData); err != nil {
+		log.Printf("%s: Error executing layout template: %v", frame.logPrefix, err)
+		http.Error(w, "Internal Server Error: Failed to render SSR layout", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := w.Write(finalOutput.Bytes()); err != nil {
+		log.Printf("%s: Error writing final response: %v", frame.logPrefix, err)
+	}
+}
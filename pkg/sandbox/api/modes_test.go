@@ -0,0 +1,49 @@
+package api
+
+import (
+	"html/template"
+	"net/url"
+	"testing"
+
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+)
+
+func TestFrameRenderSSREscapesURLArgs(t *testing.T) {
+	templates := template.Must(template.New("button/Default").Parse(`<button>{{.label}}{{.Children}}</button>`))
+	tests := []struct {
+		name     string
+		defaults map[string]interface{}
+		query    string
+		want     string
+	}{
+		{name: "undeclared Children", defaults: map[string]interface{}{"label": "Hello"}, query: "Children=<script>alert(1)</script>", want: "<button>Hello&lt;script&gt;alert(1)&lt;/script&gt;</button>"},
+		{name: "undeclared string", defaults: map[string]interface{}{}, query: "label=<img src=x onerror=alert(1)>", want: "<button>&lt;img src=x onerror=alert(1)&gt;</button>"},
+		{name: "declared HTML Children is sanitized", defaults: map[string]interface{}{"label": "", "Children": template.HTML("<b>Hi</b>")}, query: "Children=<b>Bold</b><script>alert(1)</script>", want: "<button><b>Bold</b></button>"},
+		{name: "declared string Children", defaults: map[string]interface{}{"label": "", "Children": "Hi"}, query: "Children=<script>alert(1)</script>", want: "<button>&lt;script&gt;alert(1)&lt;/script&gt;</button>"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := url.ParseQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			frame := &Frame{
+				Story: Story{
+					Component: &models.ComponentGroup{Name: "button"},
+					Variant:   &models.StoryVariant{Key: "Default", HasSSR: true, SSRTemplateName: "button/Default", Args: test.defaults},
+					Templates: templates,
+				},
+				StoryKey: "Default",
+				Query:    query,
+				Handlers: &AppHandlers{},
+			}
+			got, err := frame.RenderSSR(frame.Args())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("RenderSSR(%q) = %q, want %q", test.query, got, test.want)
+			}
+		})
+	}
+}
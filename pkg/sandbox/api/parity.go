@@ -0,0 +1,98 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+	"kormsen.com/machine-ui/pkg/sandbox/parity"
+)
+
+// Parity renders the stories of componentName (all components when empty) with their Go
+// templates and their Preact components and compares the markup, see package parity.
+func (h *AppHandlers) Parity(componentName string) []models.ParityReport {
+	h = h.snapshot()
+	components := h.Components
+	if componentName != "" {
+		components = nil
+		if component := h.findComponent(componentName); component != nil {
+			components = []models.ComponentGroup{*component}
+		}
+	}
+	return parity.Check(components, parity.Options{
+		Static:    h.Static,
+		ImportMap: h.ImportMap,
+		Templates: h.Templates,
+		Theme:     h.theme(""),
+	})
+}
+
+// ViewParity renders the CSR/SSR parity report at /sandbox/__parity. Like the diagnostics
+// report, `?component=name` limits it to one component and `?format=json` (or an
+// `Accept: application/json` header) returns the reports as JSON. Every request renders the
+// stories again, so the page always reflects the files on disk.
+func (h *AppHandlers) ViewParity(w http.ResponseWriter, r *http.Request) {
+	componentFilter := r.URL.Query().Get("component")
+	reports := h.Parity(componentFilter)
+	h = h.snapshot()
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		if reports == nil {
+			reports = []models.ParityReport{} // Encode as [] rather than null
+		}
+		writeJSON(w, http.StatusOK, reports)
+		return
+	}
+
+	// Components are copied so IsSelected can be set without touching the shared slice
+	pageComponents := make([]models.ComponentGroup, len(h.Components))
+	copy(pageComponents, h.Components)
+	for i := range pageComponents {
+		pageComponents[i].IsSelected = pageComponents[i].Name == componentFilter
+	}
+
+	pageTitle := "Parity"
+	if componentFilter != "" {
+		pageTitle = "Parity: " + componentFilter
+	}
+
+	data := models.PageData{
+		Title:                 pageTitle,
+		Components:            pageComponents,
+		BasePath:              h.BasePath,
+		StaticBaseURL:         h.BasePath + "/static",
+		Diagnostics:           h.Diagnostics,
+		ImportMapJSON:         h.importMapHTML(),
+		StaticExport:          isStaticExport(r),
+		LiveReload:            h.liveReload(r),
+		Nonce:                 cspNonce(r),
+		Theme:                 h.theme(r.URL.Query().Get("theme")),
+		CurrentPath:           h.BasePath + r.URL.Path,
+		CanClientSideNavigate: true,
+		RenderMode:            "csr", // Used by the navigation links
+		IsParityPage:          true,
+		ParityFilter:          componentFilter,
+		ParityReports:         reports,
+	}
+
+	// No toolbar or args editor: the report replaces the whole main area (see full-body-content)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := w.Write([]byte("<!DOCTYPE html>\n<html lang=\"en\">")); err != nil {
+		log.Printf("ViewParity: Error writing initial HTML: %v", err)
+		return
+	}
+	if err := h.Templates.ExecuteTemplate(w, "_document_head", data); err != nil {
+		log.Printf("ViewParity: Error executing _document_head template: %v", err)
+		_, _ = w.Write([]byte("</html>"))
+		return
+	}
+	if err := h.Templates.ExecuteTemplate(w, "full-body-content", data); err != nil {
+		log.Printf("ViewParity: Error executing full-body-content template: %v", err)
+		_, _ = w.Write([]byte("</html>"))
+		return
+	}
+	if _, err := w.Write([]byte("</html>")); err != nil {
+		log.Printf("ViewParity: Error writing closing HTML tag: %v", err)
+	}
+}
@@ -1,43 +1,68 @@
 package api
 
 import (
-	"html/template"
+	"io/fs"
 	"net/http"
 
-	"kormsen.com/machine-ui/pkg/sandbox/models"
+	"kormsen.com/machine-ui/pkg/sandbox/transpile"
 )
 
 // NewRouter creates and configures the main HTTP router for the application.
 // It sets up static file serving and registers handlers for application routes.
-func NewRouter(staticDir string, templateSet *template.Template, components []models.ComponentGroup) *http.ServeMux {
+// staticFS is served under /static/, usually the project's static directory layered over the
+// sandbox's embedded modules.
+func NewRouter(staticFS fs.FS, appHandlers *AppHandlers) *http.ServeMux {
 	router := http.NewServeMux()
 
-	// Initialize handlers with dependencies
-	appHandlers := &AppHandlers{
-		Components: components,
-		Templates:  templateSet,
-	}
-
-	// Serve static files
-	fs := http.FileServer(http.Dir(staticDir))
-	router.Handle("/static/", http.StripPrefix("/static/", fs))
+	// Serve static files; .ts, .tsx and .jsx modules are transpiled on request
+	fs := transpile.NewHandler(staticFS)
+	router.Handle("/static/", http.StripPrefix("/static/", revalidate(fs)))
 
-	// Register application routes
-	router.HandleFunc("/", appHandlers.Home)
-	router.HandleFunc("/sandbox/", appHandlers.Home) // Redirect /sandbox/ to / to show component list
-	router.HandleFunc("/sandbox/{componentName}", appHandlers.ViewStory)
-	router.HandleFunc("/sandbox/{componentName}/{storyKey}", appHandlers.ViewStory)
+	// Register application routes. Pages and frames get a CSP nonce, see csp.go
+	page := appHandlers.withCSP
+	router.HandleFunc("/", page(appHandlers.Home))
+	router.HandleFunc("/sandbox/", page(appHandlers.Home)) // Redirect /sandbox/ to / to show component list
+	// Discovery report; the literal segment takes precedence over the {componentName} wildcard
+	if !appHandlers.HideDiagnostics {
+		router.HandleFunc("/sandbox/__diagnostics", page(appHandlers.ViewDiagnostics))
+	}
+	router.HandleFunc("/sandbox/__parity", page(appHandlers.ViewParity)) // CSR/SSR comparison, see parity.go
+	// Stories as JSON (see stories.go); like the report, these literal paths win over the story routes
+	router.HandleFunc("/sandbox/api/index.json", appHandlers.StoryIndex)
+	router.HandleFunc("/sandbox/api/components/{componentName}", appHandlers.ComponentInfo)
+	router.HandleFunc("/sandbox/api/stories/{storyID}", appHandlers.StoryInfo)
+	router.HandleFunc("/sandbox/api/stories/{componentName}/{storyKey}", appHandlers.StoryInfo)
+	router.HandleFunc("/sandbox/api/hydration/{componentName}/{storyKey}", appHandlers.HydrationReport) // Posted by hydrate frames, see hydration.go
+	router.HandleFunc("/sandbox/api/csp-report", appHandlers.CSPReport)                                 // Violations of the CSP, see csp.go
+	router.HandleFunc("/sandbox/{componentName}", page(appHandlers.ViewStory))
+	router.HandleFunc("/sandbox/{componentName}/{storyKey}", page(appHandlers.ViewStory))
 
 	// New Universal Endpoint for Iframe Content (Dynamic)
-	router.HandleFunc("/sandbox-content/{componentName}", appHandlers.ServeSandboxContent)
-	router.HandleFunc("/sandbox-content/{componentName}/{storyKey}", appHandlers.ServeSandboxContent)
+	router.HandleFunc("/sandbox-content/{componentName}", page(appHandlers.ServeSandboxContent))
+	router.HandleFunc("/sandbox-content/{componentName}/{storyKey}", page(appHandlers.ServeSandboxContent))
 
 	// Removed old iframe content routes:
 	// router.HandleFunc("/sandbox-frame-csr", appHandlers.ServeCSRFramePage) // Remove old
 	// router.HandleFunc("/sandbox-ssr-content/{componentName}/{storyKey}", appHandlers.ServeSSRStoryContent) // Remove old
 
 	// Route for full body content swapping (e.g., for theme changes)
-	router.HandleFunc("/sandbox-body-swap/", appHandlers.ServeFullBodyContent) // Trailing slash for path prefix matching
+	router.HandleFunc("/sandbox-body-swap/", page(appHandlers.ServeFullBodyContent)) // Trailing slash for path prefix matching
+
+	// Live reload notifications (Server-Sent Events), see live-reload.js
+	if appHandlers.Events != nil {
+		router.Handle("/sandbox-events", appHandlers.Events)
+	}
 
 	return router
 }
+
+// revalidate makes browsers check static files with the server before reusing them.
+// Without it, ES modules imported by a reloaded story frame can come from the HTTP cache
+// and a live reload would keep showing the old component code. Unchanged files are
+// answered with 304 Not Modified, so this costs a round trip, not a download.
+func revalidate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+)
+
+// The stories API lists what discovery found as JSON, so test runners, docs sites and editor
+// plugins can enumerate stories without scraping the sandbox pages:
+//
+//	/sandbox/api/index.json                           every story, in Storybook's index.json format
+//	/sandbox/api/components/{componentName}           one component and its stories
+//	/sandbox/api/stories/{componentName}/{storyKey}   one story
+//	/sandbox/api/stories/{storyID}                    one story by its Storybook ID
+//
+// Import paths are relative to the sandbox root (BasePath), as Storybook's are relative to the
+// project root: "./static/components/button/button.stories.js".
+
+// storyIndex is Storybook's index.json, version 5.
+type storyIndex struct {
+	V       int                        `json:"v"`
+	Entries map[string]storyIndexEntry `json:"entries"`
+}
+
+type storyIndexEntry struct {
+	ID         string   `json:"id"`
+	Title      string   `json:"title"`
+	Name       string   `json:"name"`
+	ImportPath string   `json:"importPath"`
+	ExportName string   `json:"exportName"`
+	Tags       []string `json:"tags"`
+	Type       string   `json:"type"` // Always "story": the sandbox has no docs entries
+}
+
+// componentInfo is a component as returned by /sandbox/api/components/{componentName}.
+type componentInfo struct {
+	Name       string      `json:"name"`
+	Title      string      `json:"title"` // Slash-separated, as in the index
+	ImportPath string      `json:"importPath"`
+	CanSSR     bool        `json:"canSSR"`
+	SSRPath    string      `json:"ssrTemplatePath,omitempty"` // The .stories.gohtml file, relative to the static root
+	Stories    []storyInfo `json:"stories"`
+}
+
+// storyInfo is a story as returned by /sandbox/api/stories/... and inside componentInfo.
+type storyInfo struct {
+	ID              string                        `json:"id"`
+	ComponentName   string                        `json:"componentName"`
+	ExportName      string                        `json:"exportName"`
+	Name            string                        `json:"name"`
+	Title           string                        `json:"title"`
+	ImportPath      string                        `json:"importPath"`
+	Tags            []string                      `json:"tags"`
+	Args            map[string]interface{}        `json:"args"`
+	ArgTypes        map[string]models.ArgTypeInfo `json:"argTypes"`
+	RenderModes     []string                      `json:"renderModes"`               // Modes the story can be shown in right now
+	SSRTemplateName string                        `json:"ssrTemplateName,omitempty"` // Only when SSR is available
+	URL             string                        `json:"url"`                       // The story in the sandbox UI
+	FrameURLs       map[string]string             `json:"frameURLs"`                 // The bare story frame per render mode
+}
+
+// StoryIndex serves /sandbox/api/index.json.
+func (h *AppHandlers) StoryIndex(w http.ResponseWriter, r *http.Request) {
+	h = h.snapshot()
+	index := storyIndex{V: 5, Entries: make(map[string]storyIndexEntry)}
+	for _, component := range h.Components {
+		for _, variant := range component.Variants {
+			id := models.StoryID(component.FullTitle(), variant.Key)
+			if _, taken := index.Entries[id]; taken {
+				log.Printf("StoryIndex: Story ID %s is used twice, skipping %s/%s", id, component.Name, variant.Key)
+				continue
+			}
+			index.Entries[id] = storyIndexEntry{
+				ID:         id,
+				Title:      component.FullTitle(),
+				Name:       variant.Title,
+				ImportPath: importPath(&component),
+				ExportName: variant.Key,
+				Tags:       nonNil(variant.Tags),
+				Type:       "story",
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, index)
+}
+
+// ComponentInfo serves /sandbox/api/components/{componentName}.
+func (h *AppHandlers) ComponentInfo(w http.ResponseWriter, r *http.Request) {
+	h = h.snapshot()
+	component := h.findComponent(r.PathValue("componentName"))
+	if component == nil {
+		writeJSONError(w, http.StatusNotFound, "component not found: "+r.PathValue("componentName"))
+		return
+	}
+	info := componentInfo{
+		Name:       component.Name,
+		Title:      component.FullTitle(),
+		ImportPath: importPath(component),
+		CanSSR:     component.CanSSR,
+		Stories:    make([]storyInfo, 0, len(component.Variants)),
+	}
+	if component.CanSSR {
+		info.SSRPath = component.SSRGoHTMLPath
+	}
+	for i := range component.Variants {
+		info.Stories = append(info.Stories, h.storyInfo(component, &component.Variants[i]))
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// StoryInfo serves /sandbox/api/stories/{componentName}/{storyKey} and /sandbox/api/stories/{storyID}.
+func (h *AppHandlers) StoryInfo(w http.ResponseWriter, r *http.Request) {
+	h = h.snapshot()
+	componentName, storyKey, storyID := r.PathValue("componentName"), r.PathValue("storyKey"), r.PathValue("storyID")
+	for i := range h.Components {
+		component := &h.Components[i]
+		if storyID == "" && component.Name != componentName {
+			continue
+		}
+		for j := range component.Variants {
+			variant := &component.Variants[j]
+			if (storyID == "" && variant.Key == storyKey) || (storyID != "" && models.StoryID(component.FullTitle(), variant.Key) == storyID) {
+				writeJSON(w, http.StatusOK, h.storyInfo(component, variant))
+				return
+			}
+		}
+	}
+	writeJSONError(w, http.StatusNotFound, "story not found")
+}
+
+func (h *AppHandlers) storyInfo(component *models.ComponentGroup, variant *models.StoryVariant) storyInfo {
+	storyPath := "/" + url.PathEscape(component.Name) + "/" + url.PathEscape(variant.Key)
+	info := storyInfo{
+		ID:            models.StoryID(component.FullTitle(), variant.Key),
+		ComponentName: component.Name,
+		ExportName:    variant.Key,
+		Name:          variant.Title,
+		Title:         component.FullTitle(),
+		ImportPath:    importPath(component),
+		Tags:          nonNil(variant.Tags),
+		Args:          variant.Args,
+		ArgTypes:      variant.ArgTypes,
+		RenderModes:   []string{},
+		URL:           h.BasePath + "/sandbox" + storyPath,
+		FrameURLs:     make(map[string]string),
+	}
+	if info.Args == nil {
+		info.Args = map[string]interface{}{}
+	}
+	if info.ArgTypes == nil {
+		info.ArgTypes = map[string]models.ArgTypeInfo{}
+	}
+	story := Story{Component: component, Variant: variant, Templates: h.Templates}
+	info.RenderModes = append(info.RenderModes, h.RenderModes.Available(story)...)
+	if story.HasSSRTemplate() {
+		info.SSRTemplateName = variant.SSRTemplateName
+	}
+	for _, mode := range info.RenderModes {
+		info.FrameURLs[mode] = h.BasePath + "/sandbox-content" + storyPath + "?renderMode=" + mode
+	}
+	return info
+}
+
+func (h *AppHandlers) findComponent(name string) *models.ComponentGroup {
+	for i := range h.Components {
+		if h.Components[i].Name == name {
+			return &h.Components[i]
+		}
+	}
+	return nil
+}
+
+// importPath returns the story file of a component relative to the sandbox root.
+func importPath(component *models.ComponentGroup) string {
+	return "./static/" + component.Path
+}
+
+// nonNil makes an empty list encode as [] rather than null.
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		log.Printf("writeJSON: Error encoding %T: %v", value, err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
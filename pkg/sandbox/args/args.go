@@ -0,0 +1,315 @@
+// Package args converts story args to and from query strings. The manager page, the args
+// editor and the story frames all carry the current args in their URLs, one query parameter
+// per arg, and decode them against the story's defaults and argTypes:
+//
+//	spec := args.ForVariant(variant)
+//	values, problems := args.Decode(r.URL.Query(), spec)
+//	query := url.Values{"renderMode": {"ssr"}}
+//	args.Encode(values, query)
+//
+// An arg whose name is one of the sandbox's own query parameters (see IsReserved) is passed
+// with the Prefix instead, e.g. "args.theme"; any arg may be.
+//
+// Args from a URL are untrusted: a shared link must not inject markup into the page. HTML
+// args are cleaned by a Sanitizer, and a strict Decoder accepts only the declared args.
+package args
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+)
+
+// Prefix namespaces an arg in a query string.
+const Prefix = "args."
+
+// reserved are the query parameters the sandbox reads itself. "args" holds the manager
+// page's client-side args, see url-utils.js.
+var reserved = []string{"renderMode", "theme", "viewport", "componentName", "storyKey", "args"}
+
+// IsReserved reports whether name is a query parameter of the sandbox rather than an arg.
+func IsReserved(name string) bool {
+	return slices.Contains(reserved, name)
+}
+
+// Param is the query parameter that carries an arg: its name, or Prefix and its name for a
+// reserved one.
+func Param(name string) string {
+	if IsReserved(name) || strings.HasPrefix(name, Prefix) {
+		return Prefix + name
+	}
+	return name
+}
+
+// Spec is what a story declares about its args.
+type Spec struct {
+	Defaults map[string]interface{}        // The story's args; their names are the declared args
+	Types    map[string]models.ArgTypeInfo // Types, options and bounds; may be nil
+}
+
+// ForVariant returns the spec of a story; a nil variant declares no args.
+func ForVariant(variant *models.StoryVariant) Spec {
+	if variant == nil {
+		return Spec{}
+	}
+	return Spec{Defaults: variant.Args, Types: variant.ArgTypes}
+}
+
+// Type returns the type of a declared arg: from its argType, or else from its default value.
+func (s Spec) Type(name string) models.ArgType {
+	if info, ok := s.Types[name]; ok && info.Type != "" {
+		return info.Type
+	}
+	switch s.Defaults[name].(type) {
+	case bool:
+		return models.ArgTypeBoolean
+	case int, int64, float32, float64:
+		return models.ArgTypeNumber
+	case template.HTML:
+		return models.ArgTypeHTML
+	case []interface{}:
+		return models.ArgTypeArray
+	case map[string]interface{}:
+		return models.ArgTypeObject
+	}
+	return models.ArgTypeString
+}
+
+// Error is a query value that was not accepted for an arg as given: rejected, so the arg keeps
+// its default (or, if undeclared, is left out), or cleaned by the Sanitizer.
+type Error struct {
+	Name   string // The arg
+	Value  string // The query value
+	Reason string // e.g. "is not a number"
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("arg %s: %q %s", e.Name, e.Value, e.Reason)
+}
+
+// Decoder decodes args from a query under a policy. The zero Decoder accepts undeclared args
+// and cleans HTML args with the default allowlist.
+type Decoder struct {
+	Strict    bool       // Reject the query values of undeclared args instead of passing them on
+	Sanitizer *Sanitizer // Cleans HTML args; nil means DefaultTags and DefaultAttributes
+}
+
+// Decode is Decoder.Decode with the zero Decoder.
+func Decode(query url.Values, spec Spec) (map[string]interface{}, []Error) {
+	return Decoder{}.Decode(query, spec)
+}
+
+// Undeclared is Decoder.Undeclared with the zero Decoder.
+func Undeclared(query url.Values, spec Spec) map[string]interface{} {
+	extra, _ := Decoder{}.Undeclared(query, spec)
+	return extra
+}
+
+// Decode returns the declared args of spec with the query applied: each arg's query value,
+// converted to its type, or its default. Values that cannot be converted, are not one of the
+// arg's options or are empty for a required arg are reported and leave the default; numbers
+// outside the arg's min and max are clamped. HTML args are sanitized and become
+// template.HTML; what the sanitizer removed is reported as well.
+//
+// The args editor is a GET form, and browsers leave unchecked checkboxes out of it: when the
+// query names any declared arg, a boolean arg it does not name is false.
+func (d Decoder) Decode(query url.Values, spec Spec) (map[string]interface{}, []Error) {
+	submitted := false
+	for name := range spec.Defaults {
+		if _, ok := lookup(query, name); ok {
+			submitted = true
+			break
+		}
+	}
+
+	values := make(map[string]interface{}, len(spec.Defaults))
+	var problems []Error
+	for name, defaultValue := range spec.Defaults {
+		argType := spec.Type(name)
+		raw, ok := lookup(query, name)
+		if !ok {
+			if submitted && argType == models.ArgTypeBoolean {
+				values[name] = false
+			} else {
+				values[name] = normalize(defaultValue, argType)
+			}
+			continue
+		}
+		value, err := decodeValue(raw, argType, spec.Types[name])
+		if err != nil {
+			problems = append(problems, Error{Name: name, Value: raw, Reason: err.Error()})
+			values[name] = normalize(defaultValue, argType)
+			continue
+		}
+		if argType == models.ArgTypeHTML {
+			clean, removed := d.sanitizer().Sanitize(raw)
+			if len(removed) > 0 {
+				problems = append(problems, Error{Name: name, Value: raw, Reason: "had " + strings.Join(removed, ", ") + " removed"})
+			}
+			value = template.HTML(clean)
+		}
+		values[name] = value
+	}
+	slices.SortFunc(problems, func(a, b Error) int { return strings.Compare(a.Name, b.Name) })
+	return values, problems
+}
+
+// Undeclared returns the query values that are neither declared args of spec nor reserved
+// parameters, as strings, under their names without the Prefix. They are never HTML, not
+// even a "Children": nothing declares them as such, so templates escape them. A strict
+// Decoder returns none and reports each of them instead.
+func (d Decoder) Undeclared(query url.Values, spec Spec) (map[string]interface{}, []Error) {
+	extra := make(map[string]interface{})
+	var problems []Error
+	for param, values := range query {
+		if len(values) == 0 {
+			continue
+		}
+		name := strings.TrimPrefix(param, Prefix)
+		if name == param && IsReserved(name) {
+			continue
+		}
+		if _, declared := spec.Defaults[name]; declared {
+			continue
+		}
+		if d.Strict {
+			problems = append(problems, Error{Name: name, Value: values[0], Reason: "is not a declared arg"})
+			continue
+		}
+		extra[name] = values[0]
+	}
+	slices.SortFunc(problems, func(a, b Error) int { return strings.Compare(a.Name, b.Name) })
+	return extra, problems
+}
+
+func (d Decoder) sanitizer() *Sanitizer {
+	if d.Sanitizer == nil {
+		return defaultSanitizer
+	}
+	return d.Sanitizer
+}
+
+// decodeValue converts one query value to an arg of argType and checks it against info.
+// HTML is not sanitized yet.
+func decodeValue(raw string, argType models.ArgType, info models.ArgTypeInfo) (interface{}, error) {
+	if raw == "" && info.Required {
+		return nil, fmt.Errorf("is required")
+	}
+	if len(info.Options) > 0 && raw != "" && !slices.Contains(info.Options, raw) {
+		return nil, fmt.Errorf("is not one of %s", strings.Join(info.Options, ", "))
+	}
+	switch argType {
+	case models.ArgTypeBoolean:
+		switch strings.ToLower(raw) {
+		case "true", "on", "1":
+			return true, nil
+		case "false", "off", "0", "":
+			return false, nil
+		}
+		return nil, fmt.Errorf("is not a boolean")
+	case models.ArgTypeNumber:
+		number, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+			return nil, fmt.Errorf("is not a number")
+		}
+		if info.Min != nil && number < *info.Min {
+			number = *info.Min
+		}
+		if info.Max != nil && number > *info.Max {
+			number = *info.Max
+		}
+		return number, nil
+	case models.ArgTypeArray, models.ArgTypeObject:
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(raw), &decoded); err != nil {
+			return nil, fmt.Errorf("is not valid JSON")
+		}
+		switch decoded.(type) {
+		case []interface{}:
+			if argType == models.ArgTypeArray {
+				return decoded, nil
+			}
+		case map[string]interface{}:
+			if argType == models.ArgTypeObject {
+				return decoded, nil
+			}
+		}
+		return nil, fmt.Errorf("is not a JSON %s", argType)
+	case models.ArgTypeHTML:
+		return template.HTML(raw), nil
+	}
+	return raw, nil
+}
+
+// Encode sets the query parameter of every arg in values, see Param and Format.
+func Encode(values map[string]interface{}, query url.Values) {
+	for name, value := range values {
+		query.Set(Param(name), Format(value))
+	}
+}
+
+// Delete removes the query parameters of the declared args of spec, e.g. to reset them.
+func Delete(query url.Values, spec Spec) {
+	for name := range spec.Defaults {
+		query.Del(Prefix + name)
+		if !IsReserved(name) {
+			query.Del(name)
+		}
+	}
+}
+
+// Format formats an arg value for a query string. Arrays and objects are encoded as JSON so
+// they survive the round trip through the /sandbox/ and /sandbox-content/ URLs; everything
+// else keeps its fmt representation.
+func Format(value interface{}) string {
+	switch value.(type) {
+	case []interface{}, map[string]interface{}:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			log.Printf("args.Format: Error encoding structured arg as JSON: %v", err)
+			return ""
+		}
+		return string(encoded)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// lookup returns the query value of an arg: under its Prefix, or else under its name unless
+// that is reserved.
+func lookup(query url.Values, name string) (string, bool) {
+	if values, ok := query[Prefix+name]; ok && len(values) > 0 {
+		return values[0], true
+	}
+	if IsReserved(name) {
+		return "", false
+	}
+	if values, ok := query[name]; ok && len(values) > 0 {
+		return values[0], true
+	}
+	return "", false
+}
+
+// normalize gives a default value the Go type Decode returns for argType.
+func normalize(value interface{}, argType models.ArgType) interface{} {
+	switch v := value.(type) {
+	case string:
+		if argType == models.ArgTypeHTML {
+			return template.HTML(v)
+		}
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	}
+	return value
+}
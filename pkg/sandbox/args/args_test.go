@@ -0,0 +1,227 @@
+package args
+
+import (
+	"html/template"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+)
+
+func float(v float64) *float64 { return &v }
+
+var buttonSpec = Spec{
+	Defaults: map[string]interface{}{
+		"label":    "Click",
+		"primary":  true,
+		"count":    3.0,
+		"size":     "medium",
+		"tags":     []interface{}{"a"},
+		"style":    map[string]interface{}{"gap": 1.0},
+		"Children": "<b>hi</b>",
+		"theme":    "brand",
+	},
+	Types: map[string]models.ArgTypeInfo{
+		"count":    {Type: models.ArgTypeNumber, Min: float(0), Max: float(10)},
+		"size":     {Type: models.ArgTypeString, Control: "select", Options: []string{"small", "medium", "large"}},
+		"Children": {Type: models.ArgTypeHTML},
+	},
+}
+
+func TestDecode(t *testing.T) {
+	defaults := map[string]interface{}{
+		"label":    "Click",
+		"primary":  true,
+		"count":    3.0,
+		"size":     "medium",
+		"tags":     []interface{}{"a"},
+		"style":    map[string]interface{}{"gap": 1.0},
+		"Children": template.HTML("<b>hi</b>"),
+		"theme":    "brand",
+	}
+	with := func(changes map[string]interface{}) map[string]interface{} {
+		values := make(map[string]interface{}, len(defaults))
+		for name, value := range defaults {
+			values[name] = value
+		}
+		for name, value := range changes {
+			values[name] = value
+		}
+		return values
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		want     map[string]interface{}
+		problems []string // Names of the rejected args
+	}{
+		{name: "no args keeps the defaults", query: "renderMode=ssr&theme=dark", want: defaults},
+		{name: "string", query: "label=Go&primary=true", want: with(map[string]interface{}{"label": "Go"})},
+		{name: "unchecked checkbox is false", query: "label=Go", want: with(map[string]interface{}{"label": "Go", "primary": false})},
+		{name: "boolean spellings", query: "primary=on", want: defaults},
+		{name: "invalid boolean", query: "primary=maybe", want: defaults, problems: []string{"primary"}},
+		{name: "number", query: "count=7.5&primary=true", want: with(map[string]interface{}{"count": 7.5})},
+		{name: "number above max is clamped", query: "count=99&primary=true", want: with(map[string]interface{}{"count": 10.0})},
+		{name: "number below min is clamped", query: "count=-1&primary=true", want: with(map[string]interface{}{"count": 0.0})},
+		{name: "invalid number", query: "count=lots&primary=true", want: defaults, problems: []string{"count"}},
+		{name: "NaN is not a number", query: "count=NaN&primary=true", want: defaults, problems: []string{"count"}},
+		{name: "option", query: "size=large&primary=true", want: with(map[string]interface{}{"size": "large"})},
+		{name: "unknown option", query: "size=huge&primary=true", want: defaults, problems: []string{"size"}},
+		{name: "empty option", query: "size=&primary=true", want: with(map[string]interface{}{"size": ""})},
+		{name: "array", query: `tags=["x","y"]&primary=true`, want: with(map[string]interface{}{"tags": []interface{}{"x", "y"}})},
+		{name: "object for an array", query: `tags={"x":1}&primary=true`, want: defaults, problems: []string{"tags"}},
+		{name: "invalid JSON", query: `style={&primary=true`, want: defaults, problems: []string{"style"}},
+		{name: "html", query: "Children=<i>x</i>&primary=true", want: with(map[string]interface{}{"Children": template.HTML("<i>x</i>")})},
+		{name: "reserved name needs the prefix", query: "theme=dark&primary=true&label=Click", want: defaults},
+		{name: "prefixed reserved name", query: "args.theme=plain&primary=true", want: with(map[string]interface{}{"theme": "plain"})},
+		{name: "prefix wins", query: "args.label=A&label=B&primary=true", want: with(map[string]interface{}{"label": "A"})},
+		{name: "problems are sorted", query: "size=huge&count=x&primary=no", want: defaults, problems: []string{"count", "primary", "size"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := url.ParseQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, problems := Decode(query, buttonSpec)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Decode(%q) = %v, want %v", test.query, got, test.want)
+			}
+			var names []string
+			for _, problem := range problems {
+				names = append(names, problem.Name)
+			}
+			if !reflect.DeepEqual(names, test.problems) {
+				t.Errorf("Decode(%q) rejected %v, want %v (%v)", test.query, names, test.problems, problems)
+			}
+		})
+	}
+}
+
+func TestDecodeRequired(t *testing.T) {
+	spec := Spec{
+		Defaults: map[string]interface{}{"label": "Click"},
+		Types:    map[string]models.ArgTypeInfo{"label": {Type: models.ArgTypeString, Required: true}},
+	}
+	got, problems := Decode(url.Values{"label": {""}}, spec)
+	if got["label"] != "Click" || len(problems) != 1 {
+		t.Errorf("Decode(label=) = %v, %v; want the default and one problem", got, problems)
+	}
+}
+
+func TestUndeclared(t *testing.T) {
+	query := url.Values{
+		"renderMode": {"csr"},
+		"theme":      {"dark"},
+		"storyKey":   {"Primary"},
+		"label":      {"Go"},
+		"extra":      {"1"},
+		"args.theme": {"brand"},
+		"args.other": {"2"},
+		"empty":      {},
+	}
+	got := Undeclared(query, Spec{Defaults: map[string]interface{}{"label": "Click"}})
+	want := map[string]interface{}{"extra": "1", "theme": "brand", "other": "2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Undeclared = %v, want %v", got, want)
+	}
+}
+
+func TestUndeclaredHTMLStaysText(t *testing.T) {
+	query := url.Values{"Children": {"<script>alert(1)</script>"}}
+	got := Undeclared(query, Spec{Defaults: map[string]interface{}{"label": "Click"}})
+	if _, isString := got["Children"].(string); !isString {
+		t.Errorf("Undeclared Children = %#v, want a string, which templates escape", got["Children"])
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		param string
+		want  string
+	}{
+		{name: "label", value: "Go", param: "label", want: "Go"},
+		{name: "primary", value: false, param: "primary", want: "false"},
+		{name: "count", value: 2.5, param: "count", want: "2.5"},
+		{name: "tags", value: []interface{}{"x"}, param: "tags", want: `["x"]`},
+		{name: "style", value: map[string]interface{}{"gap": 1.0}, param: "style", want: `{"gap":1}`},
+		{name: "Children", value: template.HTML("<b>hi</b>"), param: "Children", want: "<b>hi</b>"},
+		{name: "theme", value: "brand", param: "args.theme", want: "brand"},
+		{name: "args.x", value: "y", param: "args.args.x", want: "y"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query := url.Values{}
+			Encode(map[string]interface{}{test.name: test.value}, query)
+			if got := query.Get(test.param); got != test.want || len(query) != 1 {
+				t.Errorf("Encode(%s=%v) = %v, want %s=%s", test.name, test.value, query, test.param, test.want)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	values, _ := Decode(url.Values{}, buttonSpec)
+	query := url.Values{"theme": {"dark"}}
+	Encode(values, query)
+	decoded, problems := Decode(query, buttonSpec)
+	if len(problems) > 0 || !reflect.DeepEqual(decoded, values) {
+		t.Errorf("Decode(Encode(%v)) = %v, %v", values, decoded, problems)
+	}
+	Delete(query, buttonSpec)
+	if want := (url.Values{"theme": {"dark"}}); !reflect.DeepEqual(query, want) {
+		t.Errorf("Delete left %v, want %v", query, want)
+	}
+}
+
+func TestDecoderHTML(t *testing.T) {
+	query := url.Values{"Children": {`<b>ok</b><img src=x onerror="alert(1)">`}, "primary": {"true"}}
+	got, problems := Decode(query, buttonSpec)
+	if want := template.HTML("<b>ok</b>"); got["Children"] != want {
+		t.Errorf("Children = %q, want %q", got["Children"], want)
+	}
+	if len(problems) != 1 || problems[0].Name != "Children" || problems[0].Reason != "had <img> removed" {
+		t.Errorf("problems = %v, want the removed <img> of Children", problems)
+	}
+
+	// Only a URL's HTML is sanitized; the story's own default is trusted
+	got, _ = Decode(url.Values{}, Spec{
+		Defaults: map[string]interface{}{"Children": `<div onclick="go()">x</div>`},
+		Types:    map[string]models.ArgTypeInfo{"Children": {Type: models.ArgTypeHTML}},
+	})
+	if want := template.HTML(`<div onclick="go()">x</div>`); got["Children"] != want {
+		t.Errorf("default Children = %q, want %q", got["Children"], want)
+	}
+}
+
+func TestDecoderStrict(t *testing.T) {
+	query := url.Values{"label": {"Go"}, "extra": {"<script>"}, "args.other": {"1"}, "theme": {"dark"}}
+	tests := []struct {
+		name     string
+		decoder  Decoder
+		want     map[string]interface{}
+		problems []string
+	}{
+		{name: "lenient", decoder: Decoder{}, want: map[string]interface{}{"extra": "<script>", "other": "1"}},
+		{name: "strict", decoder: Decoder{Strict: true}, want: map[string]interface{}{}, problems: []string{"extra", "other"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, problems := test.decoder.Undeclared(query, buttonSpec)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Undeclared = %v, want %v", got, test.want)
+			}
+			var names []string
+			for _, problem := range problems {
+				names = append(names, problem.Name)
+			}
+			if !reflect.DeepEqual(names, test.problems) {
+				t.Errorf("Undeclared rejected %v, want %v", names, test.problems)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package args
+
+import (
+	"bytes"
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// DefaultTags and DefaultAttributes are the allowlist of the default Sanitizer: inline
+// formatting, paragraphs, lists and links.
+var (
+	DefaultTags = []string{
+		"a", "abbr", "b", "br", "code", "em", "i", "kbd", "li", "mark", "ol", "p",
+		"s", "small", "span", "strong", "sub", "sup", "u", "ul",
+	}
+	DefaultAttributes = []string{"class", "href", "title", "lang", "dir", "aria-*"}
+)
+
+// removedWithContent are elements whose content is not text to show: they are removed as a
+// whole, while other elements that are not allowed are replaced by their content. They can
+// never be allowed.
+var removedWithContent = []string{
+	"script", "style", "iframe", "frame", "frameset", "object", "embed", "template",
+	"noscript", "noembed", "textarea", "title", "svg", "math", "base", "meta", "link",
+}
+
+// urlAttributes hold URLs; their values are kept only with a safe scheme.
+var urlAttributes = []string{"href", "src", "action", "formaction", "cite", "poster", "xlink:href"}
+
+// IsDangerousTag reports whether an element can never be allowed in HTML args.
+func IsDangerousTag(tag string) bool {
+	return slices.Contains(removedWithContent, strings.ToLower(tag))
+}
+
+// IsDangerousAttribute reports whether an attribute can never be allowed in HTML args: event
+// handlers and inline styles.
+func IsDangerousAttribute(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasPrefix(name, "on") || name == "style"
+}
+
+// Sanitizer cleans the HTML args a URL supplies before they are rendered as template.HTML: it
+// keeps the elements and attributes of its allowlist and removes everything else. The story
+// files' own HTML args are trusted and not sanitized.
+type Sanitizer struct {
+	tags       []string
+	attributes []string
+}
+
+// NewSanitizer returns a sanitizer that allows tags and attributes. An attribute ending in
+// "*" allows every attribute with that prefix, e.g. "aria-*". Event handler attributes,
+// inline styles and the elements of IsDangerousTag are removed even when listed.
+func NewSanitizer(tags, attributes []string) *Sanitizer {
+	s := &Sanitizer{}
+	for _, tag := range tags {
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" && !IsDangerousTag(tag) {
+			s.tags = append(s.tags, tag)
+		}
+	}
+	for _, name := range attributes {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" && !IsDangerousAttribute(name) {
+			s.attributes = append(s.attributes, name)
+		}
+	}
+	return s
+}
+
+var defaultSanitizer = NewSanitizer(DefaultTags, DefaultAttributes)
+
+// Sanitize returns fragment with only the allowed elements and attributes, and what it
+// removed, e.g. ["<script>", "onclick"], sorted. Comments are removed as "<!---->".
+func (s *Sanitizer) Sanitize(fragment string) (string, []string) {
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), context)
+	if err != nil {
+		return html.EscapeString(fragment), []string{"invalid HTML"}
+	}
+
+	removed := make(map[string]bool)
+	var out bytes.Buffer
+	for _, node := range nodes {
+		for _, kept := range s.clean(node, removed) {
+			if err := html.Render(&out, kept); err != nil {
+				return html.EscapeString(fragment), []string{"invalid HTML"}
+			}
+		}
+	}
+
+	var names []string
+	for name := range removed {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return out.String(), names
+}
+
+// clean returns the nodes that replace node: itself with its attributes and children
+// cleaned, its cleaned children if the element is not allowed, or nothing.
+func (s *Sanitizer) clean(node *html.Node, removed map[string]bool) []*html.Node {
+	switch node.Type {
+	case html.TextNode:
+		return []*html.Node{node}
+	case html.ElementNode:
+	case html.CommentNode:
+		removed["<!---->"] = true
+		return nil
+	default:
+		return nil
+	}
+
+	var children []*html.Node
+	for child := node.FirstChild; child != nil; {
+		next := child.NextSibling
+		node.RemoveChild(child)
+		children = append(children, s.clean(child, removed)...)
+		child = next
+	}
+
+	tag := strings.ToLower(node.Data)
+	if node.Namespace != "" || !slices.Contains(s.tags, tag) {
+		removed["<"+tag+">"] = true
+		if IsDangerousTag(tag) || node.Namespace != "" {
+			return nil
+		}
+		return children
+	}
+
+	attributes := node.Attr[:0]
+	for _, attr := range node.Attr {
+		name := strings.ToLower(attr.Key)
+		if attr.Namespace != "" || !s.allowsAttribute(name) || (slices.Contains(urlAttributes, name) && !safeURL(attr.Val)) {
+			removed[name] = true
+			continue
+		}
+		attributes = append(attributes, attr)
+	}
+	node.Attr = attributes
+	for _, child := range children {
+		node.AppendChild(child)
+	}
+	return []*html.Node{node}
+}
+
+func (s *Sanitizer) allowsAttribute(name string) bool {
+	if IsDangerousAttribute(name) {
+		return false
+	}
+	for _, allowed := range s.attributes {
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok && strings.HasPrefix(name, prefix) || allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// safeURL reports whether a URL attribute value is relative or uses http, https or mailto.
+func safeURL(value string) bool {
+	parsed, err := url.Parse(strings.TrimSpace(value))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "", "http", "https", "mailto":
+		return true
+	}
+	return false
+}
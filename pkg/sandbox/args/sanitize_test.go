@@ -0,0 +1,54 @@
+package args
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name    string
+		html    string
+		want    string
+		removed []string
+	}{
+		{name: "text", html: "Save & close", want: "Save &amp; close"},
+		{name: "allowed markup", html: `<b class="x">Bold</b> <a href="/docs" title="Docs">link</a>`, want: `<b class="x">Bold</b> <a href="/docs" title="Docs">link</a>`},
+		{name: "script", html: `Hi<script>alert(1)</script>`, want: "Hi", removed: []string{"<script>"}},
+		{name: "event handler", html: `<b onclick="alert(1)">x</b>`, want: "<b>x</b>", removed: []string{"onclick"}},
+		{name: "inline style", html: `<span style="position:fixed">x</span>`, want: "<span>x</span>", removed: []string{"style"}},
+		{name: "javascript URL", html: `<a href="javascript:alert(1)">x</a>`, want: "<a>x</a>", removed: []string{"href"}},
+		{name: "mixed case scheme", html: `<a href=" JaVaScRiPt:alert(1)">x</a>`, want: "<a>x</a>", removed: []string{"href"}},
+		{name: "mailto URL", html: `<a href="mailto:a@example.com">x</a>`, want: `<a href="mailto:a@example.com">x</a>`},
+		{name: "unknown element keeps its content", html: `<div><em>x</em></div>`, want: "<em>x</em>", removed: []string{"<div>"}},
+		{name: "img", html: `<img src=x onerror="alert(1)">`, want: "", removed: []string{"<img>"}},
+		{name: "iframe with content", html: `<iframe src="https://example.com">fallback</iframe>ok`, want: "ok", removed: []string{"<iframe>"}},
+		{name: "svg", html: `<svg><script>alert(1)</script></svg>`, want: "", removed: []string{"<script>", "<svg>"}},
+		{name: "comment", html: `a<!-- x -->b`, want: "ab", removed: []string{"<!---->"}},
+		{name: "aria prefix", html: `<span aria-label="l" data-x="1">x</span>`, want: `<span aria-label="l">x</span>`, removed: []string{"data-x"}},
+		{name: "unclosed tag", html: `<b>bold`, want: "<b>bold</b>"},
+		{name: "nested removal", html: `<b>a<font><i>b</i></font></b>`, want: "<b>a<i>b</i></b>", removed: []string{"<font>"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, removed := defaultSanitizer.Sanitize(test.html)
+			if got != test.want {
+				t.Errorf("Sanitize(%q) = %q, want %q", test.html, got, test.want)
+			}
+			if !reflect.DeepEqual(removed, test.removed) {
+				t.Errorf("Sanitize(%q) removed %v, want %v", test.html, removed, test.removed)
+			}
+		})
+	}
+}
+
+func TestNewSanitizerRefusesDangerousEntries(t *testing.T) {
+	sanitizer := NewSanitizer([]string{"b", "script", "IMG"}, []string{"onclick", "style", "src", "data-*"})
+	got, removed := sanitizer.Sanitize(`<b onclick="x" style="y" data-id="1">x</b><script>y</script><img src="/a.png">`)
+	if want := `<b data-id="1">x</b><img src="/a.png"/>`; got != want {
+		t.Errorf("Sanitize = %q, want %q", got, want)
+	}
+	if want := []string{"<script>", "onclick", "style"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("Sanitize removed %v, want %v", removed, want)
+	}
+}
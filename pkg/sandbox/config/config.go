@@ -0,0 +1,463 @@
+// Package config holds the sandbox's settings and reads them from, in increasing order of
+// precedence: built-in defaults, a JSON config file (sandbox.json), SANDBOX_* environment
+// variables and command line flags. A setting given in a later source replaces the earlier
+// value as a whole, lists included.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"kormsen.com/machine-ui/pkg/sandbox/args"
+	"kormsen.com/machine-ui/pkg/sandbox/themes"
+	"kormsen.com/machine-ui/pkg/sandbox/viewport"
+)
+
+// DefaultPath is the config file read when neither -config nor SANDBOX_CONFIG names one,
+// relative to the project directory (Root). It is optional; an explicitly named file has to
+// exist, and is relative to the working directory.
+const DefaultPath = "sandbox.json"
+
+// RenderModes are the values DefaultRenderMode accepts.
+var RenderModes = []string{"csr", "ssr", "hydrate"}
+
+// themeName is what a theme name may look like: the start of a CSS class, "<name>-theme".
+var themeName = regexp.MustCompile(`^-?[_a-zA-Z][-_a-zA-Z0-9]*$`)
+
+// Config is the sandbox configuration. The JSON names are the keys of the config file.
+// All paths are slash-separated and relative: Root to the working directory, StaticDir,
+// TemplateDirs and ImportMap to Root, and ComponentDirs to StaticDir, because story modules
+// are loaded by the browser from under /static/.
+type Config struct {
+	Listen            string              `json:"listen"`            // Address the server listens on, e.g. ":8080"
+	Root              string              `json:"root"`              // Project directory
+	StaticDir         string              `json:"staticDir"`         // Served under /static/
+	ComponentDirs     []string            `json:"componentDirs"`     // Searched for story files
+	TemplateDirs      []string            `json:"templateDirs"`      // Searched for .gohtml/.html templates
+	ImportMap         string              `json:"importMap"`         // Import map file for the page and frame heads
+	Themes            []string            `json:"themes"`            // Offered in the toolbar; empty discovers them, see package themes
+	DefaultTheme      string              `json:"defaultTheme"`      // Theme of a page whose URL names none
+	DefaultRenderMode string              `json:"defaultRenderMode"` // Render mode of a story whose URL names none, if the story has it
+	Viewports         []viewport.Viewport `json:"viewports"`         // Story frame sizes offered in the toolbar; empty means viewport.Presets
+	Features          Features            `json:"features"`
+	HTMLArgs          HTMLArgs            `json:"htmlArgs"`
+	CSP               CSP                 `json:"csp"`
+}
+
+// Features switches optional parts of the sandbox on and off.
+type Features struct {
+	LiveReload  bool `json:"liveReload"`  // Watch the project and reload browsers on changes
+	Diagnostics bool `json:"diagnostics"` // Show discovery problems in the sidebar and the report page; they are always logged
+	StrictArgs  bool `json:"strictArgs"`  // Accept only the args a story declares from URLs; others are rejected and reported
+}
+
+// HTMLArgs is the allowlist HTML args from URLs are sanitized with, see args.Sanitizer.
+type HTMLArgs struct {
+	Tags       []string `json:"tags"`       // Elements kept; others are replaced by their content
+	Attributes []string `json:"attributes"` // Attributes kept; "aria-*" allows a prefix
+}
+
+// CSP is the Content-Security-Policy of the sandbox's pages and frames, see api.CSP. The
+// templates carry a nonce either way.
+type CSP struct {
+	Enabled    bool   `json:"enabled"`    // Send the policy
+	Policy     string `json:"policy"`     // "{nonce}" stands for the request's nonce; empty means api.DefaultCSPPolicy
+	ReportOnly bool   `json:"reportOnly"` // Only report violations to /sandbox/api/csp-report, don't block
+}
+
+// Default returns the settings used when nothing else is configured: the layout of this
+// repository.
+func Default() Config {
+	return Config{
+		Listen:            ":8080",
+		Root:              ".",
+		StaticDir:         "static",
+		ComponentDirs:     []string{"components"},
+		TemplateDirs:      []string{"cmd/sandbox/templates", "static"},
+		ImportMap:         "importmap.json",
+		DefaultTheme:      "light",
+		DefaultRenderMode: "",
+		Viewports:         slices.Clone(viewport.Presets),
+		Features:          Features{LiveReload: true, Diagnostics: true},
+		HTMLArgs:          HTMLArgs{Tags: slices.Clone(args.DefaultTags), Attributes: slices.Clone(args.DefaultAttributes)},
+	}
+}
+
+// option is one setting that can be given as a flag and an environment variable. Values are
+// strings in both; lists are comma-separated and booleans use strconv.ParseBool.
+type option struct {
+	key    string // In the config file
+	flag   string
+	env    string
+	usage  string
+	isBool bool
+	get    func(c *Config) string // For the default shown in the flag usage
+	set    func(c *Config, value string) error
+}
+
+var options = []option{
+	{
+		key: "listen", flag: "listen", env: "SANDBOX_LISTEN", usage: "`address` to listen on",
+		get: func(c *Config) string { return c.Listen },
+		set: func(c *Config, v string) error { c.Listen = v; return nil },
+	},
+	{
+		key: "root", flag: "root", env: "SANDBOX_ROOT", usage: "project `directory`",
+		get: func(c *Config) string { return c.Root },
+		set: func(c *Config, v string) error { c.Root = v; return nil },
+	},
+	{
+		key: "staticDir", flag: "static", env: "SANDBOX_STATIC_DIR", usage: "static `directory`, relative to the project",
+		get: func(c *Config) string { return c.StaticDir },
+		set: func(c *Config, v string) error { c.StaticDir = v; return nil },
+	},
+	{
+		key: "componentDirs", flag: "components", env: "SANDBOX_COMPONENT_DIRS", usage: "comma-separated component `directories`, relative to the static directory",
+		get: func(c *Config) string { return strings.Join(c.ComponentDirs, ",") },
+		set: func(c *Config, v string) error { c.ComponentDirs = splitList(v); return nil },
+	},
+	{
+		key: "templateDirs", flag: "templates", env: "SANDBOX_TEMPLATE_DIRS", usage: "comma-separated template `directories`, relative to the project",
+		get: func(c *Config) string { return strings.Join(c.TemplateDirs, ",") },
+		set: func(c *Config, v string) error { c.TemplateDirs = splitList(v); return nil },
+	},
+	{
+		key: "importMap", flag: "importmap", env: "SANDBOX_IMPORT_MAP", usage: "import map `file`, relative to the project",
+		get: func(c *Config) string { return c.ImportMap },
+		set: func(c *Config, v string) error { c.ImportMap = v; return nil },
+	},
+	{
+		key: "themes", flag: "themes", env: "SANDBOX_THEMES", usage: "comma-separated `themes`; empty discovers the *-theme classes of the static directory's " + themes.Dir + "/",
+		get: func(c *Config) string { return strings.Join(c.Themes, ",") },
+		set: func(c *Config, v string) error { c.Themes = splitList(v); return nil },
+	},
+	{
+		key: "defaultTheme", flag: "theme", env: "SANDBOX_DEFAULT_THEME", usage: "default `theme`, one of the themes",
+		get: func(c *Config) string { return c.DefaultTheme },
+		set: func(c *Config, v string) error { c.DefaultTheme = v; return nil },
+	},
+	{
+		key: "defaultRenderMode", flag: "render-mode", env: "SANDBOX_DEFAULT_RENDER_MODE", usage: "default render `mode` (" + strings.Join(RenderModes, ", ") + "); empty shows SSR on full page loads and CSR otherwise",
+		get: func(c *Config) string { return c.DefaultRenderMode },
+		set: func(c *Config, v string) error { c.DefaultRenderMode = v; return nil },
+	},
+	{
+		key: "viewports", flag: "viewports", env: "SANDBOX_VIEWPORTS", usage: "comma-separated viewport `presets`, name=WIDTHxHEIGHT, e.g. mobile=375x667",
+		get: func(c *Config) string {
+			presets := make([]string, len(c.Viewports))
+			for i, preset := range c.Viewports {
+				presets[i] = preset.String()
+			}
+			return strings.Join(presets, ",")
+		},
+		set: func(c *Config, v string) error {
+			var presets []viewport.Viewport
+			for _, item := range splitList(v) {
+				preset, err := viewport.Parse(item)
+				if err != nil {
+					return err
+				}
+				presets = append(presets, preset)
+			}
+			c.Viewports = presets
+			return nil
+		},
+	},
+	{
+		key: "features", flag: "live-reload", env: "SANDBOX_LIVE_RELOAD", usage: "watch files and reload browsers", isBool: true,
+		get: func(c *Config) string { return strconv.FormatBool(c.Features.LiveReload) },
+		set: func(c *Config, v string) error { return setBool(&c.Features.LiveReload, v) },
+	},
+	{
+		key: "features", flag: "diagnostics", env: "SANDBOX_DIAGNOSTICS", usage: "show discovery diagnostics in the UI", isBool: true,
+		get: func(c *Config) string { return strconv.FormatBool(c.Features.Diagnostics) },
+		set: func(c *Config, v string) error { return setBool(&c.Features.Diagnostics, v) },
+	},
+	{
+		key: "features", flag: "strict-args", env: "SANDBOX_STRICT_ARGS", usage: "accept only declared story args from URLs", isBool: true,
+		get: func(c *Config) string { return strconv.FormatBool(c.Features.StrictArgs) },
+		set: func(c *Config, v string) error { return setBool(&c.Features.StrictArgs, v) },
+	},
+	{
+		key: "htmlArgs", flag: "html-tags", env: "SANDBOX_HTML_TAGS", usage: "comma-separated `elements` HTML args from URLs may contain",
+		get: func(c *Config) string { return strings.Join(c.HTMLArgs.Tags, ",") },
+		set: func(c *Config, v string) error { c.HTMLArgs.Tags = splitList(v); return nil },
+	},
+	{
+		key: "htmlArgs", flag: "html-attributes", env: "SANDBOX_HTML_ATTRIBUTES", usage: "comma-separated `attributes` HTML args from URLs may contain",
+		get: func(c *Config) string { return strings.Join(c.HTMLArgs.Attributes, ",") },
+		set: func(c *Config, v string) error { c.HTMLArgs.Attributes = splitList(v); return nil },
+	},
+	{
+		key: "csp", flag: "csp", env: "SANDBOX_CSP", usage: "send a Content-Security-Policy", isBool: true,
+		get: func(c *Config) string { return strconv.FormatBool(c.CSP.Enabled) },
+		set: func(c *Config, v string) error { return setBool(&c.CSP.Enabled, v) },
+	},
+	{
+		key: "csp", flag: "csp-policy", env: "SANDBOX_CSP_POLICY", usage: "Content-Security-Policy `policy`, with {nonce} for the request's nonce; empty uses the built-in one",
+		get: func(c *Config) string { return c.CSP.Policy },
+		set: func(c *Config, v string) error { c.CSP.Policy = v; return nil },
+	},
+	{
+		key: "csp", flag: "csp-report-only", env: "SANDBOX_CSP_REPORT_ONLY", usage: "only report Content-Security-Policy violations, don't block", isBool: true,
+		get: func(c *Config) string { return strconv.FormatBool(c.CSP.ReportOnly) },
+		set: func(c *Config, v string) error { return setBool(&c.CSP.ReportOnly, v) },
+	},
+}
+
+// Flags are the configuration flags defined on a flag set by Bind.
+type Flags struct {
+	configPath *optionValue
+	values     map[string]*optionValue // By flag name
+}
+
+// Bind defines -config and a flag per setting on flags. Call Load after flags.Parse.
+func Bind(flags *flag.FlagSet) *Flags {
+	bound := &Flags{configPath: &optionValue{}, values: make(map[string]*optionValue)}
+	flags.Var(bound.configPath, "config", "config `file` (default "+DefaultPath+" in the project directory, env SANDBOX_CONFIG)")
+	defaults := Default()
+	for _, o := range options {
+		value := &optionValue{isBool: o.isBool}
+		bound.values[o.flag] = value
+		usage := fmt.Sprintf("%s (env %s, default %q)", o.usage, o.env, o.get(&defaults))
+		flags.Var(value, o.flag, usage)
+	}
+	return bound
+}
+
+// Load resolves the configuration: defaults, then the config file, then the environment
+// (looked up with getenv, usually os.LookupEnv), then the flags that were set. The result is
+// validated; every problem is reported, each naming the source of the bad value.
+func (f *Flags) Load(getenv func(string) (string, bool)) (Config, error) {
+	cfg := Default()
+	sources := make(map[string]string) // Config file key -> where its value came from
+
+	configPath, required := filepath.Join(filepath.FromSlash(f.root(cfg.Root, getenv)), DefaultPath), false
+	if value, ok := getenv("SANDBOX_CONFIG"); ok && value != "" {
+		configPath, required = value, true
+	}
+	if f.configPath.set {
+		configPath, required = f.configPath.value, true
+	}
+	keys, err := cfg.readFile(configPath, required)
+	if err != nil {
+		return cfg, err
+	}
+	for _, key := range keys {
+		sources[key] = "config file " + configPath
+	}
+
+	var errs []error
+	for _, o := range options {
+		if value, ok := getenv(o.env); ok {
+			source := "environment variable " + o.env
+			sources[o.key] = source
+			if err := o.set(&cfg, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", source, err))
+			}
+		}
+	}
+	for _, o := range options {
+		if value := f.values[o.flag]; value.set {
+			source := "flag -" + o.flag
+			sources[o.key] = source
+			if err := o.set(&cfg, value.value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", source, err))
+			}
+		}
+	}
+
+	for _, p := range cfg.problems() {
+		if source, ok := sources[p.key]; ok {
+			errs = append(errs, fmt.Errorf("%s: %s", source, p.message))
+		} else {
+			errs = append(errs, errors.New(p.message))
+		}
+	}
+	return cfg, errors.Join(errs...)
+}
+
+// root returns the project directory the environment and the flags give, or else
+// defaultRoot, to find the default config file in before anything else is read. A root set in
+// that file is only used when neither gives one, so the file is then in the working directory
+// the root is relative to.
+func (f *Flags) root(defaultRoot string, getenv func(string) (string, bool)) string {
+	root := defaultRoot
+	for _, o := range options {
+		if o.key != "root" {
+			continue
+		}
+		if value, ok := getenv(o.env); ok {
+			root = value
+		}
+		if value := f.values[o.flag]; value.set {
+			root = value.value
+		}
+	}
+	return root
+}
+
+// readFile applies a JSON config file over c and returns the keys it set. Keys that are not
+// settings are an error, so a misspelt key does not go unnoticed.
+func (c *Config) readFile(filePath string, required bool) ([]string, error) {
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", filePath, err)
+	}
+
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", filePath, err)
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// problem is a setting that cannot work, by its config file key.
+type problem struct {
+	key     string
+	message string
+}
+
+// Validate reports every setting that cannot work.
+func (c Config) Validate() error {
+	var errs []error
+	for _, p := range c.problems() {
+		errs = append(errs, errors.New(p.message))
+	}
+	return errors.Join(errs...)
+}
+
+func (c Config) problems() []problem {
+	var problems []problem
+	invalid := func(key, format string, args ...interface{}) {
+		problems = append(problems, problem{key: key, message: fmt.Sprintf(format, args...)})
+	}
+	checkPath := func(key, value string) {
+		if !fs.ValidPath(path.Clean(value)) {
+			invalid(key, "%s: %q must be a relative path inside the project", key, value)
+		}
+	}
+
+	if c.Listen == "" {
+		invalid("listen", "listen: address is empty")
+	}
+	if c.Root == "" {
+		invalid("root", "root: directory is empty")
+	}
+	if c.StaticDir == "" {
+		invalid("staticDir", "staticDir: directory is empty")
+	} else {
+		checkPath("staticDir", c.StaticDir)
+	}
+	if len(c.ComponentDirs) == 0 {
+		invalid("componentDirs", "componentDirs: at least one component directory is needed")
+	}
+	for _, dir := range c.ComponentDirs {
+		checkPath("componentDirs", dir)
+	}
+	for _, dir := range c.TemplateDirs {
+		checkPath("templateDirs", dir)
+	}
+	if c.ImportMap == "" {
+		invalid("importMap", "importMap: file is empty")
+	} else {
+		checkPath("importMap", c.ImportMap)
+	}
+	for _, theme := range c.Themes {
+		if !themeName.MatchString(theme) {
+			invalid("themes", "themes: %q is not a valid theme name", theme)
+		}
+	}
+	if c.DefaultTheme == "" {
+		invalid("defaultTheme", "defaultTheme: theme is empty")
+	} else if len(c.Themes) > 0 && !slices.Contains(c.Themes, c.DefaultTheme) {
+		// Discovered themes are checked once the stylesheets are read
+		invalid("defaultTheme", "defaultTheme: %q is not one of %s", c.DefaultTheme, strings.Join(c.Themes, ", "))
+	}
+	if c.DefaultRenderMode != "" && !slices.Contains(RenderModes, c.DefaultRenderMode) {
+		invalid("defaultRenderMode", "defaultRenderMode: %q is not one of %s", c.DefaultRenderMode, strings.Join(RenderModes, ", "))
+	}
+	var viewportNames []string
+	for _, preset := range c.Viewports {
+		if err := preset.Check(); err != nil {
+			invalid("viewports", "viewports: %v", err)
+		} else if slices.Contains(viewportNames, preset.Name) {
+			invalid("viewports", "viewports: %q is defined twice", preset.Name)
+		}
+		viewportNames = append(viewportNames, preset.Name)
+	}
+	for _, tag := range c.HTMLArgs.Tags {
+		if args.IsDangerousTag(tag) {
+			invalid("htmlArgs", "htmlArgs: the element %q cannot be allowed", tag)
+		}
+	}
+	for _, name := range c.HTMLArgs.Attributes {
+		if args.IsDangerousAttribute(name) {
+			invalid("htmlArgs", "htmlArgs: the attribute %q cannot be allowed", name)
+		}
+	}
+	if strings.ContainsAny(c.CSP.Policy, "\r\n") {
+		invalid("csp", "csp: the policy must be a single line")
+	}
+	return problems
+}
+
+// optionValue is a flag.Value that remembers whether the flag was given at all, so an unset
+// flag does not override the config file or the environment with its default.
+type optionValue struct {
+	value  string
+	set    bool
+	isBool bool
+}
+
+func (v *optionValue) String() string { return v.value }
+
+func (v *optionValue) Set(value string) error {
+	v.value, v.set = value, true
+	return nil
+}
+
+// IsBoolFlag lets boolean settings be given as -live-reload or -live-reload=false.
+func (v *optionValue) IsBoolFlag() bool { return v.isBool }
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func setBool(target *bool, value string) error {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("%q is not a boolean", value)
+	}
+	*target = parsed
+	return nil
+}
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// load binds the flags, parses args and loads the configuration from env. "$BASE" in files,
+// args and env stands for a temporary directory; the project is $BASE/app.
+func load(t *testing.T, files map[string]string, args []string, env map[string]string) (Config, error) {
+	t.Helper()
+	base := t.TempDir()
+	expand := func(s string) string { return strings.ReplaceAll(s, "$BASE", base) }
+	for name, content := range files {
+		file := filepath.Join(base, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flags := flag.NewFlagSet("sandbox", flag.ContinueOnError)
+	bound := Bind(flags)
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		expanded[i] = expand(arg)
+	}
+	if err := flags.Parse(expanded); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := bound.Load(func(name string) (string, bool) {
+		value, ok := env[name]
+		return expand(value), ok
+	})
+	if err != nil {
+		err = &expandedError{err: err, base: base}
+	}
+	return cfg, err
+}
+
+// expandedError shows the temporary directory of load as "$BASE" again.
+type expandedError struct {
+	err  error
+	base string
+}
+
+func (e *expandedError) Error() string {
+	return strings.ReplaceAll(e.err.Error(), e.base, "$BASE")
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	appConfig := `{"listen": ":1000", "componentDirs": ["a", "b"], "features": {"liveReload": false}}`
+	tests := []struct {
+		name       string
+		files      map[string]string
+		args       []string
+		env        map[string]string
+		listen     string
+		components []string
+		liveReload bool
+	}{
+		{name: "defaults", args: []string{"-root", "$BASE/app"}, listen: ":8080", components: []string{"components"}, liveReload: true},
+		{
+			name:  "config file of -root",
+			files: map[string]string{"app/sandbox.json": appConfig},
+			args:  []string{"-root", "$BASE/app"},
+			// Settings the file leaves out keep their defaults, features.diagnostics included
+			listen: ":1000", components: []string{"a", "b"}, liveReload: false,
+		},
+		{
+			name:   "config file of SANDBOX_ROOT",
+			files:  map[string]string{"app/sandbox.json": appConfig},
+			env:    map[string]string{"SANDBOX_ROOT": "$BASE/app"},
+			listen: ":1000", components: []string{"a", "b"}, liveReload: false,
+		},
+		{
+			name:   "-root over SANDBOX_ROOT",
+			files:  map[string]string{"app/sandbox.json": appConfig, "other/sandbox.json": `{"listen": ":1111"}`},
+			args:   []string{"-root", "$BASE/app"},
+			env:    map[string]string{"SANDBOX_ROOT": "$BASE/other"},
+			listen: ":1000", components: []string{"a", "b"}, liveReload: false,
+		},
+		{
+			name:   "-config instead of the project's file",
+			files:  map[string]string{"app/sandbox.json": appConfig, "shared.json": `{"listen": ":1111"}`},
+			args:   []string{"-root", "$BASE/app", "-config", "$BASE/shared.json"},
+			listen: ":1111", components: []string{"components"}, liveReload: true,
+		},
+		{
+			name:   "SANDBOX_CONFIG instead of the project's file",
+			files:  map[string]string{"app/sandbox.json": appConfig, "shared.json": `{"listen": ":1111"}`},
+			args:   []string{"-root", "$BASE/app"},
+			env:    map[string]string{"SANDBOX_CONFIG": "$BASE/shared.json"},
+			listen: ":1111", components: []string{"components"}, liveReload: true,
+		},
+		{
+			name:   "environment over config file",
+			files:  map[string]string{"app/sandbox.json": appConfig},
+			args:   []string{"-root", "$BASE/app"},
+			env:    map[string]string{"SANDBOX_LISTEN": ":2000", "SANDBOX_COMPONENT_DIRS": "c", "SANDBOX_LIVE_RELOAD": "true"},
+			listen: ":2000", components: []string{"c"}, liveReload: true,
+		},
+		{
+			name:   "flags over environment",
+			files:  map[string]string{"app/sandbox.json": appConfig},
+			args:   []string{"-root", "$BASE/app", "-listen", ":3000", "-components", "d, e", "-live-reload=false"},
+			env:    map[string]string{"SANDBOX_LISTEN": ":2000", "SANDBOX_COMPONENT_DIRS": "c", "SANDBOX_LIVE_RELOAD": "true"},
+			listen: ":3000", components: []string{"d", "e"}, liveReload: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := load(t, test.files, test.args, test.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Listen != test.listen || !reflect.DeepEqual(cfg.ComponentDirs, test.components) || cfg.Features.LiveReload != test.liveReload {
+				t.Errorf("Load() = listen %q, componentDirs %q, liveReload %v; want %q, %q, %v",
+					cfg.Listen, cfg.ComponentDirs, cfg.Features.LiveReload, test.listen, test.components, test.liveReload)
+			}
+			if !cfg.Features.Diagnostics {
+				t.Errorf("Load() turned off features.diagnostics, which nothing sets")
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		args  []string
+		env   map[string]string
+		want  []string // Every one is part of the error
+	}{
+		{
+			name: "missing -config",
+			args: []string{"-config", "$BASE/missing.json"},
+			want: []string{"config file: open $BASE/missing.json"},
+		},
+		{
+			name:  "unknown config file key",
+			files: map[string]string{"app/sandbox.json": `{"colour": "red"}`},
+			args:  []string{"-root", "$BASE/app"},
+			want:  []string{`config file $BASE/app/sandbox.json: json: unknown field "colour"`},
+		},
+		{
+			name:  "config file value of the wrong type",
+			files: map[string]string{"app/sandbox.json": `{"listen": 8080}`},
+			args:  []string{"-root", "$BASE/app"},
+			want:  []string{"config file $BASE/app/sandbox.json: json: cannot unmarshal number"},
+		},
+		{
+			name:  "invalid config file value",
+			files: map[string]string{"app/sandbox.json": `{"themes": ["light"], "defaultTheme": "dark"}`},
+			args:  []string{"-root", "$BASE/app"},
+			want:  []string{`config file $BASE/app/sandbox.json: defaultTheme: "dark" is not one of light`},
+		},
+		{
+			name: "invalid environment variables",
+			env:  map[string]string{"SANDBOX_LIVE_RELOAD": "maybe", "SANDBOX_VIEWPORTS": "mobile", "SANDBOX_CSP_POLICY": "default-src 'self'\r\nX-Injected: 1"},
+			want: []string{
+				`environment variable SANDBOX_LIVE_RELOAD: "maybe" is not a boolean`,
+				`environment variable SANDBOX_VIEWPORTS: "mobile" is not name=WIDTHxHEIGHT`,
+				"environment variable SANDBOX_CSP_POLICY: csp: the policy must be a single line",
+			},
+		},
+		{
+			name: "invalid flags",
+			args: []string{"-render-mode", "fast", "-static", "../outside", "-html-tags", "b,script", "-listen", ""},
+			want: []string{
+				`flag -render-mode: defaultRenderMode: "fast" is not one of csr, ssr, hydrate`,
+				`flag -static: staticDir: "../outside" must be a relative path inside the project`,
+				`flag -html-tags: htmlArgs: the element "script" cannot be allowed`,
+				"flag -listen: listen: address is empty",
+			},
+		},
+		{
+			name: "flag over a valid environment variable",
+			args: []string{"-themes", "light,1dark"},
+			env:  map[string]string{"SANDBOX_THEMES": "light,dark"},
+			want: []string{`flag -themes: themes: "1dark" is not a valid theme name`},
+		},
+		{
+			name: "default made invalid by another setting",
+			args: []string{"-themes", "dark"},
+			want: []string{`defaultTheme: "light" is not one of dark`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := load(t, test.files, test.args, test.env)
+			if err == nil {
+				t.Fatalf("Load() = nil error, want %q", test.want)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Load() error = %q, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
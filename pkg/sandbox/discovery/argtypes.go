@@ -0,0 +1,230 @@
+package discovery
+
+import (
+	"fmt"
+	"strconv"
+
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+)
+
+// declaredArgType is an argType as declared in a story file. hasRequired tells a declared
+// `required: false` from none, so a story can make an arg its component requires optional.
+type declaredArgType struct {
+	models.ArgTypeInfo
+	hasRequired bool
+}
+
+// parseArgTypes reads a Storybook-style argTypes object, for example:
+//
+//	argTypes: {
+//	  size:  { control: "select", options: ["1", "2", "3"] },
+//	  count: { control: { type: "range", min: 0, max: 10, step: 1 } },
+//	  label: { type: { name: "string", required: true } },
+//	}
+//
+// Only the metadata the sandbox can use is kept; unknown keys are ignored.
+func parseArgTypes(argTypesValue interface{}, diags *fileDiagnostics, storyKey string, line int) map[string]declaredArgType {
+	argTypes := make(map[string]declaredArgType)
+	if argTypesValue == nil {
+		return argTypes
+	}
+	argTypesObject, ok := argTypesValue.(map[string]interface{})
+	if !ok {
+		diags.add(models.SeverityWarning, storyKey, line, 0, "argTypes is not a static object literal (%T), ignoring", argTypesValue)
+		return argTypes
+	}
+
+	for argName, declValue := range argTypesObject {
+		decl, ok := declValue.(map[string]interface{})
+		if !ok {
+			diags.add(models.SeverityWarning, storyKey, line, 0, "argTypes.%s is not an object, ignoring", argName)
+			continue
+		}
+		var info declaredArgType
+
+		// type: "string" | { name: "string", required: true }
+		switch t := decl["type"].(type) {
+		case string:
+			info.Type = argTypeFromName(t)
+		case map[string]interface{}:
+			if name, ok := t["name"].(string); ok {
+				info.Type = argTypeFromName(name)
+			}
+			if required, ok := t["required"].(bool); ok {
+				info.Required, info.hasRequired = required, true
+			}
+		}
+		if required, ok := decl["required"].(bool); ok {
+			info.Required, info.hasRequired = required, true
+		}
+
+		// control: "select" | { type: "range", min, max, step, options }
+		switch c := decl["control"].(type) {
+		case string:
+			info.Control = c
+		case map[string]interface{}:
+			if controlType, ok := c["type"].(string); ok {
+				info.Control = controlType
+			}
+			info.Min = floatPtr(c["min"])
+			info.Max = floatPtr(c["max"])
+			info.Step = floatPtr(c["step"])
+			if options, ok := c["options"].([]interface{}); ok {
+				info.Options = optionStrings(options)
+			}
+		}
+		if options, ok := decl["options"].([]interface{}); ok {
+			info.Options = optionStrings(options)
+		}
+		if min := floatPtr(decl["min"]); min != nil {
+			info.Min = min
+		}
+		if max := floatPtr(decl["max"]); max != nil {
+			info.Max = max
+		}
+
+		if defaultValue, ok := decl["defaultValue"]; ok && defaultValue != nil {
+			defaultValStr := fmt.Sprintf("%v", defaultValue)
+			info.Default = &defaultValStr
+		}
+
+		argTypes[argName] = info
+	}
+	return argTypes
+}
+
+// mergeArgTypes overlays declared argTypes onto base, field by field, and returns a new map.
+// Fields left empty in the overlay keep the base value, so a story can refine a single
+// property (e.g. only `options`) of an argType declared on the component or inferred from args.
+// `required` is applied whenever it is declared, false included.
+func mergeArgTypes(base map[string]models.ArgTypeInfo, overlay map[string]declaredArgType) map[string]models.ArgTypeInfo {
+	merged := make(map[string]models.ArgTypeInfo, len(base)+len(overlay))
+	for name, info := range base {
+		merged[name] = info
+	}
+	for name, over := range overlay {
+		info := merged[name]
+		if over.Type != "" {
+			info.Type = over.Type
+		}
+		if over.hasRequired {
+			info.Required = over.Required
+		}
+		if over.Control != "" {
+			info.Control = over.Control
+		}
+		if over.Options != nil {
+			info.Options = over.Options
+		}
+		if over.Min != nil {
+			info.Min = over.Min
+		}
+		if over.Max != nil {
+			info.Max = over.Max
+		}
+		if over.Step != nil {
+			info.Step = over.Step
+		}
+		if over.Default != nil {
+			info.Default = over.Default
+		}
+		merged[name] = info
+	}
+	return merged
+}
+
+// applyDeclaredArgTypes makes sure every declared argType has a type and a value in args,
+// so the args editor can render a control for it even when the story sets no value.
+func applyDeclaredArgTypes(args map[string]interface{}, argTypes map[string]models.ArgTypeInfo) {
+	for name, info := range argTypes {
+		if info.Type == "" {
+			info.Type = argTypeFromControl(info.Control)
+			argTypes[name] = info
+		}
+		if _, ok := args[name]; ok {
+			continue
+		}
+		switch info.Type {
+		case models.ArgTypeBoolean:
+			args[name] = info.Default != nil && *info.Default == "true"
+		case models.ArgTypeNumber:
+			value := 0.0
+			if info.Default != nil {
+				if parsed, err := strconv.ParseFloat(*info.Default, 64); err == nil {
+					value = parsed
+				}
+			} else if info.Min != nil {
+				value = *info.Min
+			}
+			args[name] = value
+		case models.ArgTypeArray:
+			args[name] = []interface{}{}
+		case models.ArgTypeObject:
+			args[name] = map[string]interface{}{}
+		default:
+			value := ""
+			if info.Default != nil {
+				value = *info.Default
+			} else if info.Required && len(info.Options) > 0 {
+				value = info.Options[0]
+			}
+			args[name] = value
+		}
+	}
+}
+
+// argTypeFromName maps Storybook SBType names onto sandbox arg types.
+func argTypeFromName(name string) models.ArgType {
+	switch name {
+	case "boolean":
+		return models.ArgTypeBoolean
+	case "number":
+		return models.ArgTypeNumber
+	case "string", "enum":
+		return models.ArgTypeString
+	case "html":
+		return models.ArgTypeHTML
+	case "array":
+		return models.ArgTypeArray
+	case "object":
+		return models.ArgTypeObject
+	}
+	return ""
+}
+
+// argTypeFromControl derives an arg type for argTypes that only declare a control.
+func argTypeFromControl(control string) models.ArgType {
+	switch control {
+	case "boolean":
+		return models.ArgTypeBoolean
+	case "number", "range":
+		return models.ArgTypeNumber
+	case "object":
+		return models.ArgTypeObject
+	}
+	return models.ArgTypeString
+}
+
+func floatPtr(value interface{}) *float64 {
+	if f, ok := value.(float64); ok {
+		return &f
+	}
+	return nil
+}
+
+// optionStrings converts evaluated option literals to strings, skipping values that
+// cannot be represented in a form field.
+func optionStrings(options []interface{}) []string {
+	values := make([]string, 0, len(options))
+	for _, option := range options {
+		switch o := option.(type) {
+		case string:
+			values = append(values, o)
+		case float64:
+			values = append(values, strconv.FormatFloat(o, 'f', -1, 64))
+		case bool:
+			values = append(values, strconv.FormatBool(o))
+		}
+	}
+	return values
+}
@@ -0,0 +1,425 @@
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/sobek/ast"
+	"github.com/grafana/sobek/file"
+	"github.com/grafana/sobek/parser"
+
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+)
+
+// StoryFileError describes a problem in a story file together with its source position.
+// Syntax errors reported by the JavaScript parser are converted to this type so callers
+// can point users at the exact line and column.
+type StoryFileError struct {
+	Path    string // Path of the story file as passed to discovery
+	Line    int    // 1-based line, 0 if unknown
+	Column  int    // 1-based column, 0 if unknown
+	Message string
+}
+
+func (e *StoryFileError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.Path, e.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.Path, e.Line, e.Column, e.Message)
+}
+
+// csfModule is the part of a Component Story Format module that discovery cares about:
+// the evaluated default export (component meta) and every named export holding a story object.
+type csfModule struct {
+	Meta    map[string]interface{} // Evaluated `export default {...}`, nil if absent or not an object
+	Stories []csfStory             // Named story exports, in source order
+	Skipped []csfSkippedExport     // Named exports that are not story objects
+	Imports []models.ModuleImport  // Import declarations, in source order
+}
+
+// csfSkippedExport is a named export that discovery cannot treat as a story.
+type csfSkippedExport struct {
+	Key    string
+	Line   int
+	Reason string
+}
+
+// csfStory is a single `export const Key = {...}` story.
+type csfStory struct {
+	Key    string
+	Line   int
+	Object map[string]interface{} // Statically evaluated story object
+}
+
+// htmlTemplate is the raw body of an html`...` tagged template literal, kept verbatim
+// (including any ${...} placeholders) so it can be handed to Go templates as markup.
+type htmlTemplate string
+
+// opaqueValue marks an expression that cannot be evaluated statically, such as a
+// function, a call or a reference to an imported binding. Discovery skips these.
+type opaqueValue struct {
+	Kind   string // e.g. "function", "identifier", "expression"
+	Source string // Source text of the expression, for logging
+	Line   int
+	Column int
+}
+
+// parseCSF parses the source of a story file as an ES module and statically evaluates
+// its default export and named object exports. Values that are not plain literals are
+// returned as opaqueValue so callers can decide whether to report or ignore them.
+// hasSourceMap is set for transpiled sources; their inline source map maps positions back
+// to the original file. Source maps in hand-written files are ignored.
+//
+// The parser is sobek's: esbuild, which transpiles TypeScript and JSX stories first, keeps
+// its parser and AST in internal packages, so only its transform and bundle API is usable.
+func parseCSF(path, src string, hasSourceMap bool) (*csfModule, error) {
+	fileSet := &file.FileSet{}
+	options := []parser.Option{parser.IsModule}
+	if !hasSourceMap {
+		options = append(options, parser.WithDisableSourceMaps)
+	}
+	program, err := parser.ParseFile(fileSet, path, src, 0, options...)
+	if err != nil {
+		return nil, storyFileErrorFrom(path, err)
+	}
+
+	ev := &csfEvaluator{
+		src:          src,
+		file:         program.File,
+		hasSourceMap: hasSourceMap,
+		bindings:     make(map[string]ast.Expression),
+		cache:        make(map[string]interface{}),
+		visiting:     make(map[string]bool),
+	}
+
+	// Collect every top-level binding first so stories can reference each other
+	// (e.g. `args: { ...Primary.args }`) regardless of declaration order.
+	for _, stmt := range program.Body {
+		switch s := stmt.(type) {
+		case *ast.LexicalDeclaration:
+			ev.addBindings(s.List)
+		case *ast.VariableStatement:
+			ev.addBindings(s.List)
+		case *ast.ExportDeclaration:
+			if s.LexicalDeclaration != nil {
+				ev.addBindings(s.LexicalDeclaration.List)
+			}
+			if s.Variable != nil {
+				ev.addBindings(s.Variable.List)
+			}
+		}
+	}
+
+	module := &csfModule{}
+	for _, stmt := range program.Body {
+		if importDecl, ok := stmt.(*ast.ImportDeclaration); ok {
+			specifier := importDecl.ModuleSpecifier.String()
+			if importDecl.FromClause != nil {
+				specifier = importDecl.FromClause.ModuleSpecifier.String()
+			}
+			module.Imports = append(module.Imports, models.ModuleImport{Specifier: specifier, Line: ev.position(importDecl.Idx).Line})
+			continue
+		}
+
+		exportDecl, ok := stmt.(*ast.ExportDeclaration)
+		if !ok {
+			continue
+		}
+
+		if exportDecl.IsDefault && exportDecl.AssignExpression != nil {
+			if meta, isObject := ev.eval(exportDecl.AssignExpression).(map[string]interface{}); isObject {
+				module.Meta = meta
+			}
+			continue
+		}
+
+		if exportDecl.HoistableDeclaration != nil && exportDecl.HoistableDeclaration.FunctionDeclaration != nil {
+			function := exportDecl.HoistableDeclaration.FunctionDeclaration.Function
+			if function.Name != nil {
+				module.Skipped = append(module.Skipped, csfSkippedExport{
+					Key:    function.Name.Name.String(),
+					Line:   ev.position(function.Idx0()).Line,
+					Reason: "function (CSF2) stories are not supported, export an object with a render function instead",
+				})
+			}
+			continue
+		}
+
+		if exportDecl.FromClause != nil {
+			module.Skipped = append(module.Skipped, csfSkippedExport{
+				Key:    "*",
+				Line:   ev.position(exportDecl.Idx).Line,
+				Reason: fmt.Sprintf("re-exports from %q cannot be evaluated, declare the stories in this file", exportDecl.FromClause.ModuleSpecifier),
+			})
+			continue
+		}
+
+		// `export { Primary, meta as default }`, the form esbuild emits for transpiled files
+		if exportDecl.NamedExports != nil {
+			for _, spec := range exportDecl.NamedExports.ExportsList {
+				local := spec.IdentifierName.String()
+				name := spec.Alias.String()
+				if name == "" {
+					name = local
+				}
+				if name == "default" {
+					if meta, isObject := ev.lookup(local).(map[string]interface{}); isObject {
+						module.Meta = meta
+					}
+					continue
+				}
+				line := 0
+				if initializer, ok := ev.bindings[local]; ok {
+					line = ev.position(initializer.Idx0()).Line
+				}
+				module.addExport(ev, name, local, line)
+			}
+			continue
+		}
+		var list []*ast.Binding
+		if exportDecl.LexicalDeclaration != nil {
+			list = exportDecl.LexicalDeclaration.List
+		} else if exportDecl.Variable != nil {
+			list = exportDecl.Variable.List
+		}
+		for _, binding := range list {
+			ident, isIdent := binding.Target.(*ast.Identifier)
+			if !isIdent || binding.Initializer == nil {
+				continue
+			}
+			name := ident.Name.String()
+			module.addExport(ev, name, name, ev.position(ident.Idx).Line)
+		}
+	}
+
+	return module, nil
+}
+
+// addExport records the named export `name` of the top-level binding `local` as a story,
+// or as skipped if its value is not a story object.
+func (m *csfModule) addExport(ev *csfEvaluator, name, local string, line int) {
+	value := ev.lookup(local)
+	storyObject, isObject := value.(map[string]interface{})
+	if !isObject {
+		// CSF2-style function stories and non-story exports are not supported by iframe-client.js.
+		reason := "value is not a story object literal"
+		if opaque, ok := value.(opaqueValue); ok && opaque.Kind == "function" {
+			reason = "function (CSF2) stories are not supported, export an object with a render function instead"
+		}
+		m.Skipped = append(m.Skipped, csfSkippedExport{Key: name, Line: line, Reason: reason})
+		return
+	}
+	m.Stories = append(m.Stories, csfStory{Key: name, Line: line, Object: storyObject})
+}
+
+// storyFileErrorFrom converts a parser error (usually a parser.ErrorList) into a StoryFileError.
+func storyFileErrorFrom(path string, err error) error {
+	var errList parser.ErrorList
+	if errors.As(err, &errList) && len(errList) > 0 {
+		first := errList[0]
+		message := first.Message
+		if len(errList) > 1 {
+			message = fmt.Sprintf("%s (and %d more errors)", message, len(errList)-1)
+		}
+		return &StoryFileError{Path: path, Line: first.Position.Line, Column: first.Position.Column, Message: message}
+	}
+	var single *parser.Error
+	if errors.As(err, &single) {
+		return &StoryFileError{Path: path, Line: single.Position.Line, Column: single.Position.Column, Message: single.Message}
+	}
+	return &StoryFileError{Path: path, Message: err.Error()}
+}
+
+// csfEvaluator statically evaluates literal expressions of a parsed module.
+// Identifiers are resolved against top-level bindings; everything else that is not a literal
+// evaluates to an opaqueValue.
+type csfEvaluator struct {
+	src          string
+	file         *file.File                // The parsed file; it carries the source map, the FileSet's copy does not
+	hasSourceMap bool                      // Positions are mapped back to a TypeScript/JSX original
+	bindings     map[string]ast.Expression // Top-level `const/let/var name = expr` initializers
+	cache        map[string]interface{}    // Evaluated bindings
+	visiting     map[string]bool           // Guards against self-referencing bindings
+}
+
+func (ev *csfEvaluator) addBindings(list []*ast.Binding) {
+	for _, binding := range list {
+		if ident, ok := binding.Target.(*ast.Identifier); ok && binding.Initializer != nil {
+			ev.bindings[ident.Name.String()] = binding.Initializer
+		}
+	}
+}
+
+func (ev *csfEvaluator) position(idx file.Idx) file.Position {
+	position := ev.file.Position(int(idx) - ev.file.Base())
+	if ev.hasSourceMap {
+		position.Column++ // Columns read from source maps are 0-based
+	}
+	return position
+}
+
+// source returns the source text of a node.
+func (ev *csfEvaluator) source(node ast.Node) string {
+	from, to := int(node.Idx0())-1, int(node.Idx1())-1
+	if from < 0 || to > len(ev.src) || from > to {
+		return ""
+	}
+	return ev.src[from:to]
+}
+
+func (ev *csfEvaluator) opaque(kind string, node ast.Node) opaqueValue {
+	pos := ev.position(node.Idx0())
+	return opaqueValue{Kind: kind, Source: ev.source(node), Line: pos.Line, Column: pos.Column}
+}
+
+// lookup evaluates a top-level binding by name.
+func (ev *csfEvaluator) lookup(name string) interface{} {
+	if value, ok := ev.cache[name]; ok {
+		return value
+	}
+	expr, ok := ev.bindings[name]
+	if !ok || ev.visiting[name] {
+		return nil
+	}
+	ev.visiting[name] = true
+	value := ev.eval(expr)
+	delete(ev.visiting, name)
+	ev.cache[name] = value
+	return value
+}
+
+// eval statically evaluates an expression into Go values:
+// string, float64, bool, nil, htmlTemplate, []interface{}, map[string]interface{} or opaqueValue.
+func (ev *csfEvaluator) eval(expr ast.Expression) interface{} {
+	switch e := expr.(type) {
+	case *ast.StringLiteral:
+		return e.Value.String()
+	case *ast.NumberLiteral:
+		switch n := e.Value.(type) {
+		case int64:
+			return float64(n)
+		case float64:
+			return n
+		}
+		return ev.opaque("number", e)
+	case *ast.BooleanLiteral:
+		return e.Value
+	case *ast.NullLiteral:
+		return nil
+	case *ast.UnaryExpression:
+		if operand, ok := ev.eval(e.Operand).(float64); ok && !e.Postfix {
+			switch e.Operator.String() {
+			case "-":
+				return -operand
+			case "+":
+				return operand
+			}
+		}
+		return ev.opaque("expression", e)
+	case *ast.TemplateLiteral:
+		return ev.evalTemplate(e)
+	case *ast.ArrayLiteral:
+		values := make([]interface{}, 0, len(e.Value))
+		for _, element := range e.Value {
+			if spread, ok := element.(*ast.SpreadElement); ok {
+				if spreadValues, isArray := ev.eval(spread.Expression).([]interface{}); isArray {
+					values = append(values, spreadValues...)
+					continue
+				}
+				values = append(values, ev.opaque("spread", spread))
+				continue
+			}
+			values = append(values, ev.eval(element))
+		}
+		return values
+	case *ast.ObjectLiteral:
+		return ev.evalObject(e)
+	case *ast.Identifier:
+		name := e.Name.String()
+		if name == "undefined" {
+			return nil
+		}
+		if _, ok := ev.bindings[name]; ok {
+			return ev.lookup(name)
+		}
+		return ev.opaque("identifier", e)
+	case *ast.DotExpression:
+		if object, ok := ev.eval(e.Left).(map[string]interface{}); ok {
+			if value, found := object[e.Identifier.Name.String()]; found {
+				return value
+			}
+			return nil
+		}
+		return ev.opaque("expression", e)
+	case *ast.FunctionLiteral, *ast.ArrowFunctionLiteral:
+		return ev.opaque("function", e)
+	default:
+		return ev.opaque("expression", expr)
+	}
+}
+
+func (ev *csfEvaluator) evalObject(object *ast.ObjectLiteral) interface{} {
+	values := make(map[string]interface{}, len(object.Value))
+	for _, property := range object.Value {
+		switch p := property.(type) {
+		case *ast.PropertyKeyed:
+			if p.Computed {
+				continue
+			}
+			key, ok := ev.propertyKey(p.Key)
+			if !ok {
+				continue
+			}
+			if p.Kind != ast.PropertyKindValue {
+				values[key] = ev.opaque("function", p)
+				continue
+			}
+			values[key] = ev.eval(p.Value)
+		case *ast.PropertyShort:
+			values[p.Name.Name.String()] = ev.eval(&p.Name)
+		case *ast.SpreadElement:
+			if spread, ok := ev.eval(p.Expression).(map[string]interface{}); ok {
+				for k, v := range spread {
+					values[k] = v
+				}
+			}
+		}
+	}
+	return values
+}
+
+// propertyKey returns the name of a non-computed object key (identifier, string or number).
+func (ev *csfEvaluator) propertyKey(key ast.Expression) (string, bool) {
+	switch k := key.(type) {
+	case *ast.StringLiteral:
+		return k.Value.String(), true
+	case *ast.Identifier:
+		return k.Name.String(), true
+	case *ast.NumberLiteral:
+		return k.Literal, true
+	}
+	return "", false
+}
+
+// evalTemplate handles both html`...` tagged templates and plain template strings.
+// Plain templates are only evaluated when they contain no ${...} substitutions.
+func (ev *csfEvaluator) evalTemplate(tmpl *ast.TemplateLiteral) interface{} {
+	if tmpl.Tag != nil {
+		if tag, ok := tmpl.Tag.(*ast.Identifier); ok && tag.Name.String() == "html" {
+			from, to := int(tmpl.OpenQuote), int(tmpl.CloseQuote)-1
+			if from >= 0 && to <= len(ev.src) && from <= to {
+				return htmlTemplate(strings.ReplaceAll(ev.src[from:to], "\\`", "`"))
+			}
+		}
+		return ev.opaque("expression", tmpl)
+	}
+	if len(tmpl.Expressions) > 0 {
+		return ev.opaque("expression", tmpl)
+	}
+	var sb strings.Builder
+	for _, element := range tmpl.Elements {
+		sb.WriteString(element.Parsed.String())
+	}
+	return sb.String()
+}
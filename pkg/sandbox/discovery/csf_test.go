@@ -0,0 +1,268 @@
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+	"kormsen.com/machine-ui/pkg/sandbox/transpile"
+)
+
+func TestParseCSFStoryObjects(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want map[string]interface{} // The object of the only story, Primary
+	}{
+		{
+			name: "literals",
+			src:  `export const Primary = { args: { label: 'Hi', count: 3, ratio: -0.5, primary: true, none: null, missing: undefined } };`,
+			want: map[string]interface{}{"args": map[string]interface{}{"label": "Hi", "count": 3.0, "ratio": -0.5, "primary": true, "none": nil, "missing": nil}},
+		},
+		{
+			name: "nested objects and arrays",
+			src:  `export const Primary = { args: { items: [1, "two", { three: [3] }], style: { color: "red", "font-size": 12 } } };`,
+			want: map[string]interface{}{"args": map[string]interface{}{
+				"items": []interface{}{1.0, "two", map[string]interface{}{"three": []interface{}{3.0}}},
+				"style": map[string]interface{}{"color": "red", "font-size": 12.0},
+			}},
+		},
+		{
+			name: "object spread of another story, declared later",
+			src: `export const Primary = { args: { ...Base.args, label: "Primary" } };
+const Base = { args: { label: "Base", size: "medium" } };`,
+			want: map[string]interface{}{"args": map[string]interface{}{"label": "Primary", "size": "medium"}},
+		},
+		{
+			name: "array spread",
+			src: `const sizes = ["small", "medium"];
+export const Primary = { args: { sizes: [...sizes, "large"] } };`,
+			want: map[string]interface{}{"args": map[string]interface{}{"sizes": []interface{}{"small", "medium", "large"}}},
+		},
+		{
+			name: "shorthand property",
+			src: `const label = "Short";
+export const Primary = { args: { label } };`,
+			want: map[string]interface{}{"args": map[string]interface{}{"label": "Short"}},
+		},
+		{
+			name: "template literals",
+			src:  "export const Primary = { args: { plain: `one\\ntwo`, children: html`<b class=\"x\">Bold \\`tick\\`</b>` } };",
+			want: map[string]interface{}{"args": map[string]interface{}{"plain": "one\ntwo", "children": htmlTemplate("<b class=\"x\">Bold `tick`</b>")}},
+		},
+		{
+			name: "comments",
+			src: `// A story
+export const Primary = {
+  /* the args */ args: { label: "Hi" /* , hidden: true */ }, // trailing
+};`,
+			want: map[string]interface{}{"args": map[string]interface{}{"label": "Hi"}},
+		},
+		{
+			name: "computed keys are skipped",
+			src:  `export const Primary = { args: { ["computed"]: 1, label: "Hi" } };`,
+			want: map[string]interface{}{"args": map[string]interface{}{"label": "Hi"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			module, err := parseCSF("button.stories.js", test.src, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(module.Stories) != 1 || module.Stories[0].Key != "Primary" {
+				t.Fatalf("stories = %+v, want Primary only", module.Stories)
+			}
+			if got := module.Stories[0].Object; !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Primary = %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseCSFOpaqueValues(t *testing.T) {
+	src := `import { icon } from "./icon.js";
+export const Primary = {
+  args: {
+    onClick: () => {},
+    icon: icon,
+    when: Date.now(),
+    label: ` + "`Hi ${name}`" + `,
+  },
+  render(args) { return args; },
+};`
+	module, err := parseCSF("button.stories.js", src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := module.Stories[0].Object["args"].(map[string]interface{})
+	tests := []struct {
+		name   string
+		value  interface{}
+		kind   string
+		source string
+		line   int
+	}{
+		{name: "arrow function", value: args["onClick"], kind: "function", source: "() => {}", line: 4},
+		{name: "import", value: args["icon"], kind: "identifier", source: "icon", line: 5},
+		{name: "call", value: args["when"], kind: "expression", source: "Date.now()", line: 6},
+		{name: "template with substitution", value: args["label"], kind: "expression", source: "`Hi ${name}`", line: 7},
+		{name: "method", value: module.Stories[0].Object["render"], kind: "function", line: 9},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opaque, ok := test.value.(opaqueValue)
+			if !ok {
+				t.Fatalf("value = %#v, want an opaqueValue", test.value)
+			}
+			if opaque.Kind != test.kind || opaque.Line != test.line || (test.source != "" && opaque.Source != test.source) {
+				t.Errorf("value = %+v, want kind %q, source %q on line %d", opaque, test.kind, test.source, test.line)
+			}
+		})
+	}
+}
+
+func TestParseCSFExports(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		meta    map[string]interface{}
+		stories []string // Keys with their line, "Key:line"
+		skipped []string // "Key:line"
+		imports []models.ModuleImport
+	}{
+		{
+			name: "default export and stories in source order",
+			src: `import { Button } from "./button.js";
+import "./button.css";
+export default { title: "Forms/Button", tags: ["autodocs"] };
+export const Secondary = { args: {} };
+export let Primary = { args: {} };`,
+			meta:    map[string]interface{}{"title": "Forms/Button", "tags": []interface{}{"autodocs"}},
+			stories: []string{"Secondary:4", "Primary:5"},
+			imports: []models.ModuleImport{{Specifier: "./button.js", Line: 1}, {Specifier: "./button.css", Line: 2}},
+		},
+		{
+			name: "named exports with a default alias",
+			src: `const meta = { title: "Button" };
+const Primary = { args: {} };
+const Hidden = { args: {} };
+export { meta as default, Primary, Hidden as Shown };`,
+			meta:    map[string]interface{}{"title": "Button"},
+			stories: []string{"Primary:2", "Shown:3"},
+		},
+		{
+			name: "skipped exports",
+			src: `export default { title: "Button" };
+export function Legacy() { return ""; }
+export const Arrow = () => "";
+export const count = 3;
+export * from "./more.stories.js";
+export const Primary = {};`,
+			meta:    map[string]interface{}{"title": "Button"},
+			stories: []string{"Primary:6"},
+			skipped: []string{"Legacy:2", "Arrow:3", "count:4", "*:5"},
+		},
+		{
+			name:    "no default export",
+			src:     `export const Primary = {};`,
+			stories: []string{"Primary:1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			module, err := parseCSF("button.stories.js", test.src, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stories, skipped []string
+			for _, story := range module.Stories {
+				stories = append(stories, fmt.Sprintf("%s:%d", story.Key, story.Line))
+			}
+			for _, export := range module.Skipped {
+				skipped = append(skipped, fmt.Sprintf("%s:%d", export.Key, export.Line))
+			}
+			if !reflect.DeepEqual(module.Meta, test.meta) {
+				t.Errorf("meta = %#v, want %#v", module.Meta, test.meta)
+			}
+			if !reflect.DeepEqual(stories, test.stories) {
+				t.Errorf("stories = %q, want %q", stories, test.stories)
+			}
+			if !reflect.DeepEqual(skipped, test.skipped) {
+				t.Errorf("skipped = %q, want %q", skipped, test.skipped)
+			}
+			if !reflect.DeepEqual(module.Imports, test.imports) {
+				t.Errorf("imports = %+v, want %+v", module.Imports, test.imports)
+			}
+		})
+	}
+}
+
+func TestParseCSFSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		src    string
+		line   int
+		column int
+	}{
+		{name: "unclosed object", path: "button.stories.js", src: "export const Primary = {\n  args: { label: 'Hi' \n};\n", line: 3, column: 2},
+		{name: "stray token", path: "button.stories.js", src: "export default {};\nexport const Primary = { args: } };\n", line: 2, column: 32},
+		{name: "unterminated string", path: "button.stories.js", src: "export const Primary = {\n  args: { label: 'Hi },\n};\n", line: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseCSF(test.path, test.src, false)
+			var fileErr *StoryFileError
+			if !errors.As(err, &fileErr) {
+				t.Fatalf("parseCSF() error = %v, want a *StoryFileError", err)
+			}
+			if fileErr.Path != test.path || fileErr.Line != test.line || (test.column != 0 && fileErr.Column != test.column) || fileErr.Message == "" {
+				t.Errorf("parseCSF() error = %+v, want %s:%d:%d", fileErr, test.path, test.line, test.column)
+			}
+		})
+	}
+}
+
+func TestParseCSFTranspiledPositions(t *testing.T) {
+	src := `import type { Meta } from "@storybook/preact";
+
+interface Args {
+  label: string;
+}
+
+export default { title: "Button" } satisfies Meta;
+
+export const Primary: { args: Args } = {
+  args: { label: "Hi" },
+};
+`
+	code, err := transpile.Module("button.stories.ts", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	module, err := parseCSF("button.stories.ts", string(code), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(module.Stories) != 1 || module.Stories[0].Line != 9 {
+		t.Errorf("stories = %+v, want Primary on line 9 of the TypeScript source", module.Stories)
+	}
+}
+
+func TestStoryFileErrorError(t *testing.T) {
+	tests := []struct {
+		err  StoryFileError
+		want string
+	}{
+		{err: StoryFileError{Path: "button.stories.js", Line: 3, Column: 7, Message: "Unexpected token"}, want: "button.stories.js:3:7: Unexpected token"},
+		{err: StoryFileError{Path: "button.stories.js", Message: "read failed"}, want: "button.stories.js: read failed"},
+	}
+	for _, test := range tests {
+		if got := test.err.Error(); got != test.want {
+			t.Errorf("Error() = %q, want %q", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package discovery
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+
+	"kormsen.com/machine-ui/pkg/sandbox/importmap"
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+)
+
+// fileDiagnostics collects the diagnostics for a single story file.
+// Every diagnostic is also logged, so server logs keep showing discovery problems.
+type fileDiagnostics struct {
+	file      string // Story file path, relative to the static root
+	component string // Component name derived from the file name
+	list      models.Diagnostics
+}
+
+func (d *fileDiagnostics) add(severity models.DiagnosticSeverity, storyKey string, line, column int, format string, args ...interface{}) {
+	diagnostic := models.Diagnostic{
+		Severity:  severity,
+		File:      d.file,
+		Line:      line,
+		Column:    column,
+		Component: d.component,
+		StoryKey:  storyKey,
+		Message:   fmt.Sprintf(format, args...),
+	}
+	log.Printf("    [%s] %s", severity, diagnostic.String())
+	d.list = append(d.list, diagnostic)
+}
+
+// forStory returns the diagnostics attributed to a single story.
+func (d *fileDiagnostics) forStory(storyKey string) models.Diagnostics {
+	var storyDiagnostics models.Diagnostics
+	for _, diagnostic := range d.list {
+		if diagnostic.StoryKey == storyKey {
+			storyDiagnostics = append(storyDiagnostics, diagnostic)
+		}
+	}
+	return storyDiagnostics
+}
+
+// CheckSSRTemplates reports stories that are marked as SSR capable but whose component-scoped
+// template (see models.SSRTemplateName) was not found in the parsed template set.
+// The diagnostics are attached to the affected components and variants and also returned.
+func CheckSSRTemplates(components []models.ComponentGroup, templates *template.Template) models.Diagnostics {
+	var diagnostics models.Diagnostics
+	if templates == nil {
+		return diagnostics
+	}
+	for i := range components {
+		component := &components[i]
+		for j := range component.Variants {
+			variant := &component.Variants[j]
+			if !variant.HasSSR || templates.Lookup(variant.SSRTemplateName) != nil {
+				continue
+			}
+			diagnostic := models.Diagnostic{
+				Severity:  models.SeverityWarning,
+				File:      component.SSRGoHTMLPath,
+				Component: component.Name,
+				StoryKey:  variant.Key,
+				Message:   fmt.Sprintf("no {{define %q}} found in %s, SSR is unavailable for this story", variant.Key, component.SSRGoHTMLPath),
+			}
+			log.Printf("    [%s] %s", diagnostic.Severity, diagnostic.String())
+			variant.Diagnostics = append(variant.Diagnostics, diagnostic)
+			component.Diagnostics = append(component.Diagnostics, diagnostic)
+			diagnostics = append(diagnostics, diagnostic)
+		}
+	}
+	return diagnostics
+}
+
+// CheckImports reports bare imports of story files (e.g. `import { html } from "htm/preact"`)
+// that the import map does not resolve; the browser would fail to load those stories.
+// importMapPath is the import map's file, named in the messages. Like CheckSSRTemplates, the
+// diagnostics are attached to the components and also returned.
+func CheckImports(components []models.ComponentGroup, importMap *importmap.ImportMap, importMapPath string) models.Diagnostics {
+	var diagnostics models.Diagnostics
+	for i := range components {
+		component := &components[i]
+		for _, imported := range component.Imports {
+			if !importmap.IsBare(imported.Specifier) || importMap.Resolves(imported.Specifier) {
+				continue
+			}
+			diagnostic := models.Diagnostic{
+				Severity:  models.SeverityError,
+				File:      component.Path,
+				Line:      imported.Line,
+				Component: component.Name,
+				Message:   fmt.Sprintf("import %q is not in the import map (%s), the browser cannot resolve it", imported.Specifier, importMapPath),
+			}
+			log.Printf("    [%s] %s", diagnostic.Severity, diagnostic.String())
+			component.Diagnostics = append(component.Diagnostics, diagnostic)
+			diagnostics = append(diagnostics, diagnostic)
+		}
+	}
+	return diagnostics
+}
@@ -1,29 +1,21 @@
 package discovery
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"log"
-	"os"
-	"path/filepath"
+	"path"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 
 	"kormsen.com/machine-ui/pkg/sandbox/models"
-)
-
-// Regular expressions to parse story files (unexported)
-var (
-	storyKeyRegex           = regexp.MustCompile(`export\s+const\s+([A-Za-z_][A-Za-z0-9_]*)\s*=\s*{`)
-	storyTitleRegex         = regexp.MustCompile(`title:\s*["']([^"']+)["']`)
-	defaultTitleRegex       = regexp.MustCompile(`export\s+default\s*{\s*title:\s*["']([^"']+)["']`)
-	storyArgsBlockRegex     = regexp.MustCompile(`(?s)args:\s*{([^{}]*)}`)
-	argKeyStringValueRegex  = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_]*):\s*\"((?:\\\"|[^"])*)\"`)
-	argKeyBooleanValueRegex = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_]*):\s*(true|false)`)
-	argKeyNumericValueRegex = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_]*):\s*([0-9]+(?:\.[0-9]+)?)`)
-	argKeyHTMLValueRegex    = regexp.MustCompile("children:\\s*html`((?:\\\\`|[^`])*)`")
-	storyIDRegex            = regexp.MustCompile(`id:\s*["']([^"']+)["']`)
-	pendingTextRegex        = regexp.MustCompile(`pendingText:\s*["']([^"']+)["']`)
+	"kormsen.com/machine-ui/pkg/sandbox/transpile"
 )
 
 // ArgType represents the data type of an argument
@@ -47,147 +39,276 @@ type ArgTypeInfo struct {
 	Default  *string  // Default value as string
 }
 
-// parseArgs extracts key-value pairs from a story's args block string.
-// This is a simplified parser and might not cover all JS object literal syntax.
-func parseArgs(argsBlock string) (map[string]interface{}, map[string]models.ArgTypeInfo) {
+// parseArgs converts a story's statically evaluated args object into sandbox args and
+// their inferred types. Arrays and objects are kept as JSON-like values; values that cannot be
+// represented (functions, references to imports) are skipped and reported with their source position.
+func parseArgs(argsObject map[string]interface{}, diags *fileDiagnostics, storyKey string, line int) (map[string]interface{}, map[string]models.ArgTypeInfo) {
 	args := make(map[string]interface{})
 	argTypes := make(map[string]models.ArgTypeInfo)
 
-	// Handle children: html`...` separately first because its value can contain colons/commas
-	htmlMatch := argKeyHTMLValueRegex.FindStringSubmatch(argsBlock)
-	if len(htmlMatch) > 1 {
-		// Replace backticks and escaped backticks correctly for Go string
-		childrenContent := strings.ReplaceAll(htmlMatch[1], "\\`", "`")
-		args["Children"] = childrenContent // Store as string, Go template will use template.HTML
-		argTypes["Children"] = models.ArgTypeInfo{Type: models.ArgTypeHTML, Required: false}
-		// Remove the html`...` part to avoid reprocessing by simpler regexes
-		argsBlock = strings.Replace(argsBlock, htmlMatch[0], "", 1)
-	}
-
-	// String values: key: "value"
-	matchesStr := argKeyStringValueRegex.FindAllStringSubmatch(argsBlock, -1)
-	for _, match := range matchesStr {
-		if len(match) == 3 {
-			value := strings.ReplaceAll(match[2], "\\\"", "\"")
-			args[match[1]] = value
-			// Store default value
-			defaultValStr := value
-			argTypes[match[1]] = models.ArgTypeInfo{Type: models.ArgTypeString, Required: false, Default: &defaultValStr}
+	for key, value := range argsObject {
+		switch v := value.(type) {
+		case htmlTemplate:
+			// html`...` children are exposed as "Children" so Go templates can render them as markup.
+			argName := key
+			if key == "children" {
+				argName = "Children"
+			}
+			args[argName] = string(v) // Store as string, converted to template.HTML by trustHTMLArgs
+			argTypes[argName] = models.ArgTypeInfo{Type: models.ArgTypeHTML, Required: false}
+		case string:
+			defaultValStr := v
+			args[key] = v
+			argTypes[key] = models.ArgTypeInfo{Type: models.ArgTypeString, Required: false, Default: &defaultValStr}
+		case bool:
+			defaultValStr := strconv.FormatBool(v)
+			args[key] = v
+			argTypes[key] = models.ArgTypeInfo{Type: models.ArgTypeBoolean, Required: false, Default: &defaultValStr}
+		case float64:
+			defaultValStr := strconv.FormatFloat(v, 'f', -1, 64)
+			args[key] = v
+			argTypes[key] = models.ArgTypeInfo{Type: models.ArgTypeNumber, Required: false, Default: &defaultValStr}
+		case []interface{}, map[string]interface{}:
+			structured, ok := jsonArgValue(v)
+			if !ok {
+				diags.add(models.SeverityWarning, storyKey, line, 0, "arg %q skipped: nested value contains functions or references that cannot be evaluated statically", key)
+				continue
+			}
+			argType := models.ArgTypeObject
+			if _, isArray := structured.([]interface{}); isArray {
+				argType = models.ArgTypeArray
+			}
+			encoded, err := json.Marshal(structured)
+			if err != nil {
+				diags.add(models.SeverityWarning, storyKey, line, 0, "arg %q skipped: %v", key, err)
+				continue
+			}
+			defaultValStr := string(encoded)
+			args[key] = structured
+			argTypes[key] = models.ArgTypeInfo{Type: argType, Required: false, Default: &defaultValStr}
+		case opaqueValue:
+			// Event handlers and other functions are expected in args; they just have no control.
+			diags.add(models.SeverityInfo, storyKey, v.Line, v.Column, "arg %q skipped: %s values cannot be evaluated statically", key, v.Kind)
+		default:
+			diags.add(models.SeverityWarning, storyKey, line, 0, "arg %q skipped: unsupported value of type %T", key, value)
 		}
 	}
 
-	// Boolean values: key: true or key: false
-	matchesBool := argKeyBooleanValueRegex.FindAllStringSubmatch(argsBlock, -1)
-	for _, match := range matchesBool {
-		if len(match) == 3 {
-			boolValue := (match[2] == "true")
-			args[match[1]] = boolValue
-			// Store default value
-			defaultValStr := match[2]
-			argTypes[match[1]] = models.ArgTypeInfo{Type: models.ArgTypeBoolean, Required: false, Default: &defaultValStr}
+	return args, argTypes
+}
+
+// jsonArgValue converts an evaluated array or object into a JSON-compatible value.
+// html`...` templates nested inside become plain strings; it reports false if the value
+// contains anything that cannot be evaluated statically.
+func jsonArgValue(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case nil, string, bool, float64:
+		return v, true
+	case htmlTemplate:
+		return string(v), true
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, element := range v {
+			converted, ok := jsonArgValue(element)
+			if !ok {
+				return nil, false
+			}
+			values[i] = converted
 		}
+		return values, true
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			converted, ok := jsonArgValue(element)
+			if !ok {
+				return nil, false
+			}
+			values[key] = converted
+		}
+		return values, true
 	}
+	return nil, false
+}
+
+// storyFileSuffixes are the story file names discovery accepts. TypeScript and JSX stories are
+// transpiled with esbuild before parsing, the same way the static handler serves them.
+var storyFileSuffixes = []string{".stories.js", ".stories.jsx", ".stories.ts", ".stories.tsx"}
 
-	// Numeric values: key: 123 or key: 12.34 (currently captures as string, could parse to float/int)
-	matchesNum := argKeyNumericValueRegex.FindAllStringSubmatch(argsBlock, -1)
-	for _, match := range matchesNum {
-		if len(match) == 3 {
-			args[match[1]] = match[2] // Store as string, template can handle
-			// Store default value
-			defaultValStr := match[2]
-			argTypes[match[1]] = models.ArgTypeInfo{Type: models.ArgTypeNumber, Required: false, Default: &defaultValStr}
+// storyFileSuffix returns the story suffix of a file name, or "" if it is not a story file.
+func storyFileSuffix(name string) string {
+	for _, suffix := range storyFileSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return suffix
 		}
 	}
-
-	return args, argTypes
+	return ""
 }
 
-// DiscoverStories scans the specified directory for component story files (*.stories.js)
-// and parses them to extract component and story variant information.
-func DiscoverStories(componentsDir string) ([]models.ComponentGroup, error) {
-	log.Printf("Discovering stories from directory: %s", componentsDir)
+// DiscoverStories scans componentDirs of the static file system (the tree served under
+// /static/) for component story files (*.stories.js, .jsx, .ts, .tsx) and parses them to
+// extract component and story variant information. Paths in the result are relative to staticFS.
+// Story files are parsed as ES modules. Problems in individual files (syntax errors, skipped
+// exports or args, missing SSR templates) do not stop discovery; they are returned as diagnostics
+// and also attached to the affected components and variants, as is a component directory that
+// does not exist. The error is reserved for failures walking or reading a components directory.
+// Component names must be unique across all directories.
+func DiscoverStories(staticFS fs.FS, componentDirs []string) ([]models.ComponentGroup, models.Diagnostics, error) {
 	var discoveredComponents []models.ComponentGroup
-	staticDirRoot := "static"
-
-	err := filepath.Walk(componentsDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			log.Printf("Error accessing path %s: %v", path, err)
-			return err
-		}
-		if info.IsDir() {
-			return nil
+	var diagnostics models.Diagnostics
+	componentFiles := make(map[string]string) // Component name -> story file that claimed it
+
+	for _, componentsDir := range componentDirs {
+		log.Printf("Discovering stories from directory: %s", componentsDir)
+		if _, err := fs.Stat(staticFS, componentsDir); err != nil {
+			log.Printf("Warning: Skipping components directory %s: %v", componentsDir, err)
+			diagnostics = append(diagnostics, models.Diagnostic{
+				Severity: models.SeverityError,
+				File:     componentsDir,
+				Message:  "components directory not readable, no stories discovered from it: " + err.Error(),
+			})
+			continue
 		}
 
-		if strings.HasSuffix(info.Name(), ".stories.js") {
-			log.Printf("Found .stories.js file: %s", path)
-			componentNameFromFile := strings.TrimSuffix(info.Name(), ".stories.js")
-
-			jsStoryPath, relErr := filepath.Rel(staticDirRoot, path)
-			if relErr != nil {
-				log.Printf("Could not make .stories.js path relative for %s (staticDirRoot: %s): %v", path, staticDirRoot, relErr)
-				jsStoryPath = filepath.ToSlash(path)
-			} else {
-				jsStoryPath = filepath.ToSlash(jsStoryPath)
+		err := fs.WalkDir(staticFS, componentsDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				log.Printf("Error accessing path %s: %v", path, err)
+				return err
+			}
+			if d.IsDir() {
+				return nil
 			}
 
-			gohtmlStoriesPath := strings.Replace(jsStoryPath, ".stories.js", ".stories.gohtml", 1)
-			componentGoHTMLPath := strings.Replace(jsStoryPath, ".stories.js", ".gohtml", 1)
-
-			// Determine if this component can be SSR'd based on existence of Go template files
-			// Basic check: are the paths non-empty? A more robust check would be os.Stat in main or handler.
-			componentCanSSR := false
-			// To truly check, we need the full path. Assuming staticDirRoot is cwd or accessible for Stat.
-			if _, err := os.Stat(filepath.Join(staticDirRoot, gohtmlStoriesPath)); err == nil { // Check if .stories.gohtml exists
-				if _, err := os.Stat(filepath.Join(staticDirRoot, componentGoHTMLPath)); err == nil { // Check if component .gohtml exists
-					componentCanSSR = true
-					log.Printf("    Component %s determined to support SSR (found %s and %s)", componentNameFromFile, gohtmlStoriesPath, componentGoHTMLPath)
+			if storySuffix := storyFileSuffix(d.Name()); storySuffix != "" {
+				log.Printf("Found %s file: %s", storySuffix, path)
+				componentNameFromFile := strings.TrimSuffix(d.Name(), storySuffix)
+				jsStoryPath := path // Already relative to the static root, as story URLs need it
+
+				diags := &fileDiagnostics{file: jsStoryPath, component: componentNameFromFile}
+				// Every diagnostic of this file ends up in the global list, whether or not a component is added.
+				defer func() { diagnostics = append(diagnostics, diags.list...) }()
+
+				// Story URLs and SSR template names are keyed by the file name alone, so two story files
+				// with the same name in different directories cannot both be served.
+				if firstFile, taken := componentFiles[componentNameFromFile]; taken {
+					diags.add(models.SeverityError, "", 0, 0, "component name %q is already used by %s, rename one of the story files", componentNameFromFile, firstFile)
+					return nil
+				}
+				componentFiles[componentNameFromFile] = jsStoryPath
+
+				gohtmlStoriesPath := strings.TrimSuffix(jsStoryPath, storySuffix) + ".stories.gohtml"
+				componentGoHTMLPath := strings.TrimSuffix(jsStoryPath, storySuffix) + ".gohtml"
+
+				// Determine if this component can be SSR'd based on existence of Go template files
+				componentCanSSR := false
+				if _, err := fs.Stat(staticFS, gohtmlStoriesPath); err == nil { // Check if .stories.gohtml exists
+					if _, err := fs.Stat(staticFS, componentGoHTMLPath); err == nil { // Check if component .gohtml exists
+						componentCanSSR = true
+						log.Printf("    Component %s determined to support SSR (found %s and %s)", componentNameFromFile, gohtmlStoriesPath, componentGoHTMLPath)
+					} else {
+						diags.add(models.SeverityWarning, "", 0, 0, "%s found, but component template %s is missing, SSR disabled", gohtmlStoriesPath, componentGoHTMLPath)
+					}
 				} else {
-					log.Printf("    Component %s SSR check: %s found, but component GoHTML %s not found (%v)", componentNameFromFile, gohtmlStoriesPath, componentGoHTMLPath, err)
+					diags.add(models.SeverityInfo, "", 0, 0, "no %s found, stories are CSR only", gohtmlStoriesPath)
 				}
-			} else {
-				log.Printf("    Component %s SSR check: stories GoHTML %s not found (%v)", componentNameFromFile, gohtmlStoriesPath, err)
-			}
 
-			contentBytes, readErr := os.ReadFile(filepath.Join(staticDirRoot, jsStoryPath)) // Ensure reading from correct base
-			if readErr != nil {
-				log.Printf("Error reading story file %s: %v", jsStoryPath, readErr)
-				return readErr
-			}
-			content := string(contentBytes)
+				contentBytes, readErr := fs.ReadFile(staticFS, jsStoryPath)
+				if readErr != nil {
+					log.Printf("Error reading story file %s: %v", jsStoryPath, readErr)
+					return readErr
+				}
+				content := string(contentBytes)
+
+				// TypeScript and JSX are transpiled first; the inline source map lets the parser
+				// report positions in the original file.
+				moduleSource, hasSourceMap := content, false
+				if transpile.IsSource(path) {
+					code, transpileErr := transpile.Module(path, contentBytes)
+					if transpileErr != nil {
+						var esbuildErr *transpile.Error
+						if errors.As(transpileErr, &esbuildErr) {
+							diags.add(models.SeverityError, "", esbuildErr.Line, esbuildErr.Column, "%s", esbuildErr.Message)
+						} else {
+							diags.add(models.SeverityError, "", 0, 0, "%v", transpileErr)
+						}
+						return nil
+					}
+					moduleSource, hasSourceMap = string(code), true
+				}
 
-			var variants []models.StoryVariant
-			storyMatches := storyKeyRegex.FindAllStringSubmatch(content, -1)
-			if len(storyMatches) == 0 {
-				log.Printf("No story keys (export const XXX) found in %s", path)
-			}
+				module, parseErr := parseCSF(path, moduleSource, hasSourceMap)
+				if parseErr != nil {
+					// A broken story file should not hide every other component; record it and move on.
+					// The file has no component group, so the diagnostics page is the only place it shows up.
+					var fileErr *StoryFileError
+					if errors.As(parseErr, &fileErr) {
+						diags.add(models.SeverityError, "", fileErr.Line, fileErr.Column, "syntax error: %s", fileErr.Message)
+					} else {
+						diags.add(models.SeverityError, "", 0, 0, "%v", parseErr)
+					}
+					return nil
+				}
 
-			for _, storyMatch := range storyMatches {
-				if len(storyMatch) > 1 {
-					storyKey := storyMatch[1]
-					log.Printf("  Found story key: %s in %s", storyKey, path)
-					variantBlockRegex := regexp.MustCompile(`export\s+const\s+` + regexp.QuoteMeta(storyKey) + `\s*=\s*{([^}]*)}`)
-					variantBlockMatch := variantBlockRegex.FindStringSubmatch(content)
+				var variants []models.StoryVariant
+				if len(module.Stories) == 0 {
+					diags.add(models.SeverityWarning, "", 0, 0, "no story exports (export const XXX = {...}) found")
+				}
+				for _, skipped := range module.Skipped {
+					diags.add(models.SeverityWarning, "", skipped.Line, 0, "export %s skipped: %s", skipped.Key, skipped.Reason)
+				}
+
+				// pendingText may be declared on the component meta or on the story itself.
+				metaPendingText, _ := findStringProperty(module.Meta, "pendingText")
+				metaArgTypes := parseArgTypes(module.Meta["argTypes"], diags, "", 0)
+				metaTags := parseTags(module.Meta["tags"], diags, "", 0)
+
+				// Component-level args (`export default { args: {...} }`) are inherited by every story.
+				metaArgs := make(map[string]interface{})
+				metaInferredArgTypes := make(map[string]models.ArgTypeInfo)
+				if metaArgsObject, ok := module.Meta["args"].(map[string]interface{}); ok {
+					metaArgs, metaInferredArgTypes = parseArgs(metaArgsObject, diags, "", 0)
+				} else if metaArgsValue, present := module.Meta["args"]; present {
+					diags.add(models.SeverityWarning, "", 0, 0, "args of the default export are not a static object literal (%T), ignoring", metaArgsValue)
+				}
+
+				for _, story := range module.Stories {
+					storyKey := story.Key
+					log.Printf("  Found story key: %s in %s:%d", storyKey, path, story.Line)
 					variantTitle := storyKey
-					storyArgs := make(map[string]interface{})
-					storyArgTypes := make(map[string]models.ArgTypeInfo)
-
-					if len(variantBlockMatch) > 1 {
-						blockContent := variantBlockMatch[1]
-						titleMatch := storyTitleRegex.FindStringSubmatch(blockContent)
-						if len(titleMatch) > 1 {
-							variantTitle = titleMatch[1]
-						}
+					storyArgs := make(map[string]interface{}, len(metaArgs))
+					storyArgTypes := make(map[string]models.ArgTypeInfo, len(metaInferredArgTypes))
+					for argName, value := range metaArgs {
+						storyArgs[argName] = value
+					}
+					for argName, info := range metaInferredArgTypes {
+						storyArgTypes[argName] = info
+					}
 
-						argsBlockMatch := storyArgsBlockRegex.FindStringSubmatch(blockContent)
-						if len(argsBlockMatch) > 1 {
-							storyArgs, storyArgTypes = parseArgs(argsBlockMatch[1])
-						} else {
-							log.Printf("    No args block found for story %s in %s", storyKey, path)
+					// CSF uses `name` for the display name; `title` is accepted for older stories.
+					if name, ok := story.Object["name"].(string); ok && name != "" {
+						variantTitle = name
+					} else if title, ok := story.Object["title"].(string); ok && title != "" {
+						variantTitle = title
+					}
+
+					// Story args take precedence over the inherited component args, value and type alike.
+					if argsObject, ok := story.Object["args"].(map[string]interface{}); ok {
+						ownArgs, ownArgTypes := parseArgs(argsObject, diags, storyKey, story.Line)
+						for argName, value := range ownArgs {
+							storyArgs[argName] = value
+							storyArgTypes[argName] = ownArgTypes[argName]
 						}
-					} else {
-						log.Printf("    Could not find variant block for story %s in %s", storyKey, path)
+					} else if argsValue, present := story.Object["args"]; present {
+						diags.add(models.SeverityWarning, storyKey, story.Line, 0, "args are not a static object literal (%T), ignoring", argsValue)
+					} else if len(metaArgs) == 0 {
+						diags.add(models.SeverityInfo, storyKey, story.Line, 0, "no args block, the args editor will be empty")
 					}
 
+					// Declared argTypes refine the types inferred from arg values: component-level first,
+					// then story-level, so a story can override what its component declares.
+					storyArgTypes = mergeArgTypes(storyArgTypes, metaArgTypes)
+					storyArgTypes = mergeArgTypes(storyArgTypes, parseArgTypes(story.Object["argTypes"], diags, storyKey, story.Line))
+					applyDeclaredArgTypes(storyArgs, storyArgTypes)
+
 					if _, ok := storyArgs["IsSquare"]; !ok {
 						isSquareVal := strings.Contains(storyKey, "Icon")
 						storyArgs["IsSquare"] = isSquareVal
@@ -195,97 +316,253 @@ func DiscoverStories(componentsDir string) ([]models.ComponentGroup, error) {
 						storyArgTypes["IsSquare"] = models.ArgTypeInfo{Type: models.ArgTypeBoolean, Required: false, Default: &defaultValStr}
 					}
 
-					// Check for PendingText in the story
+					// Check for PendingText in the story, falling back to the component meta
 					hasPendingText := false
-					pendingTextMatch := pendingTextRegex.FindStringSubmatch(content) // Check against the full story content, not just the block
-					if len(pendingTextMatch) > 1 {
+					pendingTextVal, found := findStringProperty(story.Object, "pendingText")
+					if !found {
+						pendingTextVal, found = metaPendingText, metaPendingText != ""
+					}
+					if found {
 						hasPendingText = true
 						if _, ok := storyArgs["PendingText"]; !ok {
-							pendingTextVal := pendingTextMatch[1]
 							storyArgs["PendingText"] = pendingTextVal
 							defaultValStr := pendingTextVal
 							storyArgTypes["PendingText"] = models.ArgTypeInfo{Type: models.ArgTypeString, Required: false, Default: &defaultValStr}
 						}
 					}
 
-					// Special handling for HTML Children when using template.HTML
-					if childrenVal, ok := storyArgs["Children"]; ok {
-						if childrenStr, isString := childrenVal.(string); isString {
-							storyArgs["Children"] = template.HTML(childrenStr)
-						}
-					}
+					trustHTMLArgs(storyArgs, storyArgTypes)
 
 					variants = append(variants, models.StoryVariant{
-						Key:            storyKey,
-						Title:          variantTitle,
-						Args:           storyArgs,
-						ArgTypes:       storyArgTypes,
-						HasCSR:         true,            // Variants from .stories.js are always CSR capable
-						HasSSR:         componentCanSSR, // SSR capability depends on Go templates for the component
-						HasPendingText: hasPendingText,
+						Key:             storyKey,
+						Title:           variantTitle,
+						Args:            storyArgs,
+						ArgTypes:        storyArgTypes,
+						HasCSR:          true,            // Variants from story modules are always CSR capable
+						HasSSR:          componentCanSSR, // SSR capability depends on Go templates for the component
+						SSRTemplateName: models.SSRTemplateName(componentNameFromFile, storyKey),
+						HasPendingText:  hasPendingText,
+						Tags:            combineTags(defaultTags, metaTags, parseTags(story.Object["tags"], diags, storyKey, story.Line)),
+						SnapshotArgs:    parseSnapshotArgs(story.Object["parameters"], diags, storyKey, story.Line),
+						Diagnostics:     diags.forStory(storyKey),
 					})
 				}
-			}
 
-			componentTitle := strings.Title(strings.ReplaceAll(componentNameFromFile, "-", " "))
-			defaultTitleMatch := defaultTitleRegex.FindStringSubmatch(content)
-			if len(defaultTitleMatch) > 1 {
-				componentTitle = defaultTitleMatch[1]
-			}
-
-			if len(variants) > 0 {
-				discoveredComponents = append(discoveredComponents, models.ComponentGroup{
-					Name:                componentNameFromFile,
-					Title:               componentTitle,
-					Path:                jsStoryPath,
-					StoryContent:        content,
-					Variants:            variants,
-					SSRGoHTMLPath:       gohtmlStoriesPath,
-					ComponentGoHTMLPath: componentGoHTMLPath,
-					CanSSR:              componentCanSSR,
-				})
-				log.Printf("Successfully discovered component: %s (%s) with %d variants. CanSSR: %t", componentTitle, componentNameFromFile, len(variants), componentCanSSR)
-				for _, v := range variants {
-					log.Printf("  Variant: %s, Title: %s, Args: %+v", v.Key, v.Title, v.Args)
-					for argName, argType := range v.ArgTypes {
-						log.Printf("    Arg: %s, Type: %s, Default: %v", argName, argType.Type, argType.Default)
+				metaTitle, _ := module.Meta["title"].(string)
+				hierarchy, componentTitle := storyHierarchy(metaTitle, componentsDir, path, componentNameFromFile)
+
+				if len(variants) > 0 {
+					discoveredComponents = append(discoveredComponents, models.ComponentGroup{
+						Name:                componentNameFromFile,
+						Title:               componentTitle,
+						Hierarchy:           hierarchy,
+						Path:                jsStoryPath,
+						StoryContent:        content,
+						Variants:            variants,
+						SSRGoHTMLPath:       gohtmlStoriesPath,
+						ComponentGoHTMLPath: componentGoHTMLPath,
+						CanSSR:              componentCanSSR,
+						Diagnostics:         append(models.Diagnostics(nil), diags.list...),
+						Imports:             module.Imports,
+					})
+					log.Printf("Successfully discovered component: %s (%s) with %d variants. CanSSR: %t", componentTitle, componentNameFromFile, len(variants), componentCanSSR)
+					for _, v := range variants {
+						log.Printf("  Variant: %s, Title: %s, Args: %+v", v.Key, v.Title, v.Args)
+						for argName, argType := range v.ArgTypes {
+							log.Printf("    Arg: %s, Type: %s, Default: %v", argName, argType.Type, argType.Default)
+						}
 					}
+				} else {
+					log.Printf("No variants found for component %s in file %s, component not added.", componentNameFromFile, path)
 				}
 			} else {
-				log.Printf("No variants found for component %s in file %s, component not added.", componentNameFromFile, path)
+				// log.Printf("Skipping non-story file: %s", info.Name()) // Optional: very verbose
 			}
-		} else {
-			// log.Printf("Skipping non .stories.js file: %s", info.Name()) // Optional: very verbose
+			return nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("error walking components directory %s: %w", componentsDir, err)
 		}
-		return nil
-	})
-	if err != nil {
-		return nil, fmt.Errorf("error walking components directory %s: %w", componentsDir, err)
 	}
 	if len(discoveredComponents) == 0 {
-		log.Println("WARNING: No component stories were discovered after walking the components directory.")
+		log.Println("WARNING: No component stories were discovered after walking the components directories.")
 	}
 
 	// Now enrich the GoHTML templates with the arg type information from JS
-	err = enrichGoHTMLTemplates(discoveredComponents, staticDirRoot)
+	err := enrichGoHTMLTemplates(discoveredComponents, staticFS)
 	if err != nil {
 		log.Printf("WARNING: Error enriching GoHTML templates: %v", err)
 	}
 
-	return discoveredComponents, nil
+	return discoveredComponents, diagnostics, nil
+}
+
+// storyHierarchy returns the sidebar groups and the label of a component.
+// A slash-separated story title (`title: "Forms/Inputs/TextField"`) is used as is. Without one,
+// the groups follow the directories between componentsDir (the one the file was found in) and the story file, leaving out the
+// component's own directory (static/components/forms/text-field/text-field.stories.js -> "Forms").
+func storyHierarchy(metaTitle, componentsDir, storyFilePath, componentName string) ([]string, string) {
+	if strings.Contains(metaTitle, "/") {
+		var segments []string
+		for _, segment := range strings.Split(metaTitle, "/") {
+			if segment = strings.TrimSpace(segment); segment != "" {
+				segments = append(segments, segment)
+			}
+		}
+		if len(segments) > 0 {
+			return segments[:len(segments)-1], segments[len(segments)-1]
+		}
+	}
+
+	title := strings.TrimSpace(metaTitle)
+	if title == "" {
+		title = humanizeName(componentName)
+	}
+
+	relDir := path.Dir(storyFilePath)
+	if componentsDir != "." {
+		relDir = strings.TrimPrefix(strings.TrimPrefix(relDir, componentsDir), "/")
+	}
+	if relDir == "" || relDir == "." {
+		return nil, title
+	}
+	dirs := strings.Split(relDir, "/")
+	if dirs[len(dirs)-1] == componentName {
+		dirs = dirs[:len(dirs)-1]
+	}
+	groups := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		groups = append(groups, humanizeName(dir))
+	}
+	return groups, title
+}
+
+// defaultTags are the tags every story starts with, as in Storybook.
+var defaultTags = []string{"dev", "test"}
+
+// parseTags reads a CSF `tags` array. Anything but an array of string literals is reported
+// and ignored.
+func parseTags(value interface{}, diags *fileDiagnostics, storyKey string, line int) []string {
+	if value == nil {
+		return nil
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		diags.add(models.SeverityWarning, storyKey, line, 0, "tags are not a static array (%T), ignoring", value)
+		return nil
+	}
+	tags := make([]string, 0, len(list))
+	for _, item := range list {
+		tag, ok := item.(string)
+		if !ok {
+			diags.add(models.SeverityWarning, storyKey, line, 0, "tag %v is not a string literal, ignoring", item)
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// combineTags merges tag lists from the outermost level (the defaults) to the innermost (the
+// story). A "!name" tag removes "name" added by an earlier level, so a story can opt out of
+// "test" with `tags: ["!test"]`.
+func combineTags(levels ...[]string) []string {
+	var tags []string
+	for _, level := range levels {
+		for _, tag := range level {
+			if removed, ok := strings.CutPrefix(tag, "!"); ok {
+				tags = slices.DeleteFunc(tags, func(existing string) bool { return existing == removed })
+			} else if !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// snapshotNamePattern limits arg set names to what is safe in a snapshot file name.
+var snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// parseSnapshotArgs reads the named arg sets of a story's `parameters.snapshots`, e.g.
+// `parameters: { snapshots: { Disabled: { disabled: true } } }`. The snapshot command renders
+// the story once with its args and once per set, with the set's args merged over them.
+func parseSnapshotArgs(parameters interface{}, diags *fileDiagnostics, storyKey string, line int) map[string]map[string]interface{} {
+	parametersObject, _ := parameters.(map[string]interface{})
+	value, present := parametersObject["snapshots"]
+	if !present {
+		return nil
+	}
+	sets, ok := value.(map[string]interface{})
+	if !ok {
+		diags.add(models.SeverityWarning, storyKey, line, 0, "parameters.snapshots is not a static object literal (%T), ignoring", value)
+		return nil
+	}
+	snapshotArgs := make(map[string]map[string]interface{}, len(sets))
+	for name, setValue := range sets {
+		if !snapshotNamePattern.MatchString(name) {
+			diags.add(models.SeverityWarning, storyKey, line, 0, "snapshot arg set %q skipped: names may only contain letters, digits, - and _", name)
+			continue
+		}
+		argsObject, ok := setValue.(map[string]interface{})
+		if !ok {
+			diags.add(models.SeverityWarning, storyKey, line, 0, "snapshot arg set %q skipped: not a static object literal (%T)", name, setValue)
+			continue
+		}
+		setArgs, setArgTypes := parseArgs(argsObject, diags, storyKey, line)
+		trustHTMLArgs(setArgs, setArgTypes)
+		snapshotArgs[name] = setArgs
+	}
+	return snapshotArgs
+}
+
+// trustHTMLArgs turns the string values of html args into template.HTML: html`...` from the
+// story file, and args declared with type html, are markup written by the project. Frames get
+// the same from args.Decode; snapshots and parity checks render the variant's args as they are.
+func trustHTMLArgs(args map[string]interface{}, argTypes map[string]models.ArgTypeInfo) {
+	for name, info := range argTypes {
+		if text, isString := args[name].(string); isString && info.Type == models.ArgTypeHTML {
+			args[name] = template.HTML(text)
+		}
+	}
+}
+
+// humanizeName turns a file or directory name like "text-field" into "Text Field".
+func humanizeName(name string) string {
+	return strings.Title(strings.NewReplacer("-", " ", "_", " ").Replace(name))
+}
+
+// findStringProperty searches an evaluated object, and any objects nested in it,
+// for a string property with the given key.
+func findStringProperty(object map[string]interface{}, key string) (string, bool) {
+	if value, ok := object[key].(string); ok {
+		return value, true
+	}
+	keys := make([]string, 0, len(object))
+	for k := range object {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys) // Deterministic result when several nested objects declare the key
+	for _, k := range keys {
+		if nested, ok := object[k].(map[string]interface{}); ok {
+			if found, ok := findStringProperty(nested, key); ok {
+				return found, true
+			}
+		}
+	}
+	return "", false
 }
 
 // enrichGoHTMLTemplates reads the .stories.gohtml files and adds type annotations
 // using HTML comments that can be parsed by the template engine
-func enrichGoHTMLTemplates(components []models.ComponentGroup, staticDirRoot string) error {
+func enrichGoHTMLTemplates(components []models.ComponentGroup, staticFS fs.FS) error {
 	for _, component := range components {
 		if !component.CanSSR {
 			continue
 		}
 
 		// Read the GoHTML stories file
-		goHTMLPath := filepath.Join(staticDirRoot, component.SSRGoHTMLPath)
-		if _, err := os.Stat(goHTMLPath); err != nil {
+		goHTMLPath := component.SSRGoHTMLPath
+		if _, err := fs.Stat(staticFS, goHTMLPath); err != nil {
 			log.Printf("Skipping GoHTML enrichment for %s: file not found", goHTMLPath)
 			continue
 		}
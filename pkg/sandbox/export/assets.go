@@ -0,0 +1,125 @@
+package export
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"kormsen.com/machine-ui/pkg/sandbox/transpile"
+)
+
+// copyStatic copies the static files to outDir. TypeScript and JSX modules are transpiled
+// to .js files (button.tsx becomes button.js), because static hosts serve .tsx with a MIME
+// type browsers refuse for modules. Import specifiers in modules are rewritten to match the
+// exported files, see rewriteImports. Go templates, TypeScript declarations, dotfiles and HTML
+// snapshots (__snapshots__) are not copied.
+func copyStatic(staticFS fs.FS, outDir string) (int, error) {
+	copied := 0
+	err := fs.WalkDir(staticFS, ".", func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if file != "." && (strings.HasPrefix(d.Name(), ".") || d.Name() == "__snapshots__") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || strings.HasSuffix(d.Name(), ".gohtml") || strings.HasSuffix(d.Name(), ".d.ts") {
+			return nil // Templates are rendered into the pages; type declarations never reach the browser
+		}
+
+		data, err := fs.ReadFile(staticFS, file)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case transpile.IsSource(file):
+			jsFile := strings.TrimSuffix(file, path.Ext(file)) + ".js"
+			if _, err := fs.Stat(staticFS, jsFile); err == nil {
+				// The server serves the existing .js file for this URL as well
+				log.Printf("Export: %s is shadowed by %s, not transpiled", file, jsFile)
+				return nil
+			}
+			code, err := transpile.Module(path.Base(file), data)
+			if err != nil {
+				// The server answers 500 for this module; the rest of the site is still useful
+				log.Printf("Export: Skipping %s: %v", file, err)
+				return nil
+			}
+			file, data = jsFile, rewriteImports(staticFS, jsFile, code)
+		case path.Ext(file) == ".js" || path.Ext(file) == ".mjs":
+			data = rewriteImports(staticFS, file, data)
+		}
+
+		outPath := filepath.Join(outDir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(outPath, data, 0o644); err != nil {
+			return err
+		}
+		copied++
+		return nil
+	})
+	return copied, err
+}
+
+// importSpecifier matches the module specifier of static imports, re-exports and dynamic
+// imports with a literal argument: from "x", import "x" and import("x").
+var importSpecifier = regexp.MustCompile(`(\b(?:from|import)\s*\(?\s*)(["'])([^"'\n]+)(["'])`)
+
+// rewriteImports makes the imports of the module at file (relative to the static directory)
+// work in the export:
+//   - "/static/..." becomes a relative specifier, so the site can live under any path
+//   - "./button", "./button.js" or "./button.tsx" name the transpiled button.js when the
+//     module only exists as TypeScript or JSX, as the transpiling file server resolves them
+//
+// Bare specifiers are left to the import map.
+func rewriteImports(staticFS fs.FS, file string, code []byte) []byte {
+	return importSpecifier.ReplaceAllFunc(code, func(match []byte) []byte {
+		parts := importSpecifier.FindSubmatch(match)
+		specifier := string(parts[3])
+
+		var rewritten string
+		switch {
+		case strings.HasPrefix(specifier, "/static/"):
+			rewritten = relativeURL(path.Join("static", file), staticFile(specifier))
+			if !strings.HasPrefix(rewritten, "../") {
+				rewritten = "./" + rewritten // Without it the specifier would be bare
+			}
+		case strings.HasPrefix(specifier, "./") || strings.HasPrefix(specifier, "../"):
+			rewritten = resolveModule(staticFS, path.Dir(file), specifier)
+		default:
+			return match
+		}
+		return []byte(string(parts[1]) + string(parts[2]) + rewritten + string(parts[4]))
+	})
+}
+
+// resolveModule returns the specifier of the exported module for a relative import from dir.
+func resolveModule(staticFS fs.FS, dir, specifier string) string {
+	if transpile.IsSource(specifier) {
+		return strings.TrimSuffix(specifier, path.Ext(specifier)) + ".js"
+	}
+	ext := path.Ext(specifier)
+	if ext != "" && ext != ".js" {
+		return specifier
+	}
+	base := path.Join(dir, specifier)
+	if _, err := fs.Stat(staticFS, base); err == nil {
+		return specifier
+	}
+	base = strings.TrimSuffix(base, ext)
+	for _, sourceExt := range transpile.SourceExtensions {
+		if _, err := fs.Stat(staticFS, base+sourceExt); err == nil {
+			return strings.TrimSuffix(specifier, ext) + ".js"
+		}
+	}
+	return specifier
+}
@@ -0,0 +1,420 @@
+// Package export writes the sandbox to a directory as a static site: the manager pages, the
+// story frames in every render mode and theme, the diagnostics report and the static assets.
+// Pages are rendered by the same handlers the server uses, so an exported SSR frame is exactly
+// what /sandbox-content returns. Root-relative links are rewritten to relative file paths, so
+// the result works from any static host, under any path, without a Go server.
+package export
+
+import (
+	"fmt"
+	"html"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"kormsen.com/machine-ui/pkg/sandbox/api"
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+	"kormsen.com/machine-ui/pkg/sandbox/themes"
+	"kormsen.com/machine-ui/pkg/sandbox/transpile"
+)
+
+// Options configures an export.
+type Options struct {
+	Static fs.FS  // Files the sandbox serves under /static/, as passed to api.NewRouter
+	OutDir string // Directory the site is written to; created if needed, existing files are overwritten
+
+	// The handlers' DefaultTheme and DefaultRenderMode. The default theme's start page is
+	// index.html, and links that name no theme or render mode get these, as on the server.
+	DefaultTheme      string
+	DefaultRenderMode string
+
+	// RenderModes are the handlers' render modes; each story is exported in the ones available
+	// for it. Nil means the built-in modes.
+	RenderModes *api.RenderModeRegistry
+}
+
+// Summary counts what an export wrote.
+type Summary struct {
+	Pages    int // Manager pages, frames and diagnostics reports
+	Assets   int // Files copied or transpiled from the static directory
+	Problems int // Pages that rendered with an error status; they are written anyway
+}
+
+// page is one file of the exported site and the request that renders it.
+type page struct {
+	file       string // Slash-separated, relative to the output directory
+	requestURL string
+}
+
+// site holds the state of one export.
+type site struct {
+	handler http.Handler
+	content api.Content
+	options Options
+
+	queued  map[string]bool // Export files already rendered or waiting to be
+	queue   []page
+	summary Summary
+}
+
+// Site renders every story of content through handler (the router returned by api.NewRouter)
+// and writes the result to options.OutDir, together with the static assets.
+//
+// Each story gets a manager page and a frame per available render mode and theme. Links found
+// in the rendered pages are followed as well, so the theme toggle, the render mode buttons and
+// the diagnostics links all point at files that exist.
+func Site(handler http.Handler, content api.Content, options Options) (Summary, error) {
+	if options.RenderModes == nil {
+		options.RenderModes = api.NewRenderModeRegistry()
+	}
+	s := &site{
+		handler: handler,
+		content: content,
+		options: options,
+		queued:  make(map[string]bool),
+	}
+	if err := os.MkdirAll(options.OutDir, 0o755); err != nil {
+		return s.summary, err
+	}
+
+	assets, err := copyStatic(options.Static, filepath.Join(options.OutDir, "static"))
+	s.summary.Assets = assets
+	if err != nil {
+		return s.summary, err
+	}
+
+	for _, theme := range s.themes() {
+		s.enqueue(s.homePage(theme))
+		for _, component := range content.Components {
+			if len(component.Variants) == 0 {
+				s.enqueue(s.storyPage(&component, nil, "", theme))
+				continue
+			}
+			for i := range component.Variants {
+				variant := &component.Variants[i]
+				for _, mode := range s.renderModes(&component, variant) {
+					s.enqueue(s.storyPage(&component, variant, mode, theme))
+					s.enqueue(s.framePage(component.Name, variant.Key, mode, theme))
+				}
+			}
+		}
+	}
+	if len(content.Diagnostics) > 0 {
+		// The sandbox only links to the report when there is something in it
+		s.enqueue(s.diagnosticsPage("", false))
+	}
+	// Storybook's index, for tools that list the stories of a published sandbox; its import
+	// paths are relative to the site root, where static/ is
+	s.enqueue(page{file: "sandbox/api/index.json", requestURL: "/sandbox/api/index.json"})
+
+	for len(s.queue) > 0 {
+		next := s.queue[0]
+		s.queue = s.queue[1:]
+		if err := s.render(next); err != nil {
+			return s.summary, err
+		}
+	}
+	return s.summary, nil
+}
+
+func (s *site) enqueue(p page) {
+	if p.file == "" || s.queued[p.file] {
+		return
+	}
+	s.queued[p.file] = true
+	s.queue = append(s.queue, p)
+}
+
+// render requests a page from the handler, rewrites its links and writes it to disk.
+func (s *site) render(p page) error {
+	request := httptest.NewRequest(http.MethodGet, p.requestURL, nil)
+	request.Header.Set(api.ExportHeader, "true")
+	recorder := httptest.NewRecorder()
+	s.handler.ServeHTTP(recorder, request)
+
+	switch {
+	case recorder.Code >= 300 && recorder.Code < 400:
+		// Not expected for the canonical URLs built here; a redirect cannot be a file
+		log.Printf("Export: %s redirected to %s, skipping %s", p.requestURL, recorder.Header().Get("Location"), p.file)
+		return nil
+	case recorder.Code != http.StatusOK:
+		// SSR error pages are still useful, the server shows the same page
+		log.Printf("Export: %s answered %d, writing %s anyway", p.requestURL, recorder.Code, p.file)
+		s.summary.Problems++
+	}
+
+	body := recorder.Body.String()
+	if strings.HasSuffix(p.file, ".html") {
+		body = s.rewriteHTML(p.file, body)
+	}
+
+	outPath := filepath.Join(s.options.OutDir, filepath.FromSlash(p.file))
+	if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(outPath, []byte(body), 0o644); err != nil {
+		return err
+	}
+	s.summary.Pages++
+	return nil
+}
+
+var (
+	// urlAttribute matches the attributes the sandbox templates put root-relative URLs in
+	urlAttribute = regexp.MustCompile(`(\s(?:href|src|action)=")([^"]*)(")`)
+	// staticString matches asset URLs inside JSON: the import map and the CSR frame config
+	staticString = regexp.MustCompile(`"(/static/[^"]*)"`)
+)
+
+// rewriteHTML points every link of a page rendered for file at the exported files, relative
+// to file. Links to pages that have not been exported yet are queued. URLs that do not
+// belong to the sandbox (other sites, fragments) are left alone.
+func (s *site) rewriteHTML(file, body string) string {
+	body = urlAttribute.ReplaceAllStringFunc(body, func(match string) string {
+		parts := urlAttribute.FindStringSubmatch(match)
+		rewritten, ok := s.rewriteURL(file, html.UnescapeString(parts[2]))
+		if !ok {
+			return match
+		}
+		return parts[1] + html.EscapeString(rewritten) + parts[3]
+	})
+	return staticString.ReplaceAllStringFunc(body, func(match string) string {
+		assetURL := strings.Trim(match, `"`)
+		return `"` + relativeURL(file, staticFile(assetURL)) + `"`
+	})
+}
+
+// rewriteURL maps a root-relative sandbox URL to the exported file for it, relative to file.
+func (s *site) rewriteURL(file, rawURL string) (string, bool) {
+	if !strings.HasPrefix(rawURL, "/") || strings.HasPrefix(rawURL, "//") {
+		return "", false
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+
+	var target string
+	if strings.HasPrefix(u.Path, "/static/") {
+		target = staticFile(u.Path)
+	} else {
+		p, ok := s.target(u)
+		if !ok {
+			return "", false
+		}
+		s.enqueue(p)
+		target = p.file
+	}
+
+	rewritten := relativeURL(file, target)
+	if u.Fragment != "" {
+		rewritten += "#" + u.Fragment
+	}
+	return rewritten, true
+}
+
+// target returns the exported page for a sandbox URL. Query parameters other than the render
+// mode, the theme and the diagnostics filter are dropped: a static page cannot read story args.
+func (s *site) target(u *url.URL) (page, bool) {
+	query := u.Query()
+	theme := query.Get("theme")
+	if !slices.Contains(s.themes(), theme) {
+		theme = s.defaultTheme()
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch segments[0] {
+	case "":
+		return s.homePage(theme), true
+
+	case "sandbox", "sandbox-body-swap":
+		if len(segments) == 1 {
+			return s.homePage(theme), true
+		}
+		if segments[0] == "sandbox" && segments[1] == "__diagnostics" {
+			return s.diagnosticsPage(query.Get("component"), query.Get("format") == "json"), true
+		}
+		component := s.component(segments[1])
+		if component == nil {
+			return page{}, false
+		}
+		// Same choice of story and render mode as the body-swap handler
+		var variant *models.StoryVariant
+		if len(component.Variants) > 0 {
+			variant = &component.Variants[0]
+			if len(segments) > 2 {
+				for i := range component.Variants {
+					if component.Variants[i].Key == segments[2] {
+						variant = &component.Variants[i]
+					}
+				}
+			}
+		}
+		return s.storyPage(component, variant, s.effectiveMode(component, variant, query.Get("renderMode")), theme), true
+
+	case "sandbox-content":
+		componentName := query.Get("componentName")
+		if len(segments) > 1 {
+			componentName = segments[1]
+		}
+		storyKey := ""
+		if len(segments) > 2 {
+			storyKey = segments[2]
+		}
+		mode := query.Get("renderMode")
+		if mode == "" {
+			mode = "csr"
+		}
+		return s.framePage(componentName, storyKey, mode, theme), true
+	}
+	return page{}, false
+}
+
+// themes are the themes every page and frame is exported in: the content's registered ones.
+func (s *site) themes() []string {
+	if len(s.content.Themes) > 0 {
+		return s.content.Themes
+	}
+	return themes.Builtin
+}
+
+// defaultTheme is the theme of pages whose links name none; as on the server, the configured
+// one if it is registered, else "light" or the first theme.
+func (s *site) defaultTheme() string {
+	registered := s.themes()
+	for _, theme := range []string{s.options.DefaultTheme, "light"} {
+		if slices.Contains(registered, theme) {
+			return theme
+		}
+	}
+	return registered[0]
+}
+
+func (s *site) component(name string) *models.ComponentGroup {
+	for i := range s.content.Components {
+		if s.content.Components[i].Name == name {
+			return &s.content.Components[i]
+		}
+	}
+	return nil
+}
+
+// effectiveMode picks the render mode the server would show for a requested one.
+func (s *site) effectiveMode(component *models.ComponentGroup, variant *models.StoryVariant, requested string) string {
+	modes := s.renderModes(component, variant)
+	if slices.Contains(modes, requested) {
+		return requested
+	}
+	if slices.Contains(modes, s.options.DefaultRenderMode) {
+		return s.options.DefaultRenderMode
+	}
+	if len(modes) > 0 {
+		return modes[0]
+	}
+	return "csr"
+}
+
+// renderModes lists the modes a story can be shown in, in the server's (registry) order.
+// A component without stories only has the CSR fallback frame.
+func (s *site) renderModes(component *models.ComponentGroup, variant *models.StoryVariant) []string {
+	if variant == nil {
+		return []string{"csr"}
+	}
+	return s.options.RenderModes.Available(api.Story{Component: component, Variant: variant, Templates: s.content.Templates})
+}
+
+// homePage is the start page in a theme: index.html for the default theme, index-dark.html etc.
+// It is rendered by the body-swap handler, whose frame points at the fallback route.
+func (s *site) homePage(theme string) page {
+	file := "index.html"
+	if theme != s.defaultTheme() {
+		file = "index-" + theme + ".html"
+	}
+	return page{file: file, requestURL: "/sandbox-body-swap/?theme=" + url.QueryEscape(theme)}
+}
+
+// storyPage is the manager page for a story, e.g. sandbox/button/Primary/ssr-dark.html.
+// It is rendered by the body-swap handler rather than ViewStory: its full-page response
+// carries the render mode buttons, which ViewStory only sends to JavaScript clients.
+func (s *site) storyPage(component *models.ComponentGroup, variant *models.StoryVariant, mode, theme string) page {
+	if mode == "" {
+		mode = s.effectiveMode(component, variant, "")
+	}
+	requestPath := "/sandbox-body-swap/" + url.PathEscape(component.Name)
+	file := path.Join("sandbox", component.Name)
+	if variant != nil {
+		requestPath += "/" + url.PathEscape(variant.Key)
+		file = path.Join(file, variant.Key)
+	}
+	query := url.Values{"renderMode": {mode}, "theme": {theme}}
+	return page{file: path.Join(file, mode+"-"+theme+".html"), requestURL: requestPath + "?" + query.Encode()}
+}
+
+// framePage is the iframe document for a story, e.g. sandbox-content/button/Primary/ssr-dark.html.
+func (s *site) framePage(componentName, storyKey, mode, theme string) page {
+	requestPath := "/sandbox-content/" + url.PathEscape(componentName)
+	file := path.Join("sandbox-content", componentName)
+	if storyKey != "" {
+		requestPath += "/" + url.PathEscape(storyKey)
+		file = path.Join(file, storyKey)
+	}
+	query := url.Values{"renderMode": {mode}, "theme": {theme}}
+	return page{file: path.Join(file, mode+"-"+theme+".html"), requestURL: requestPath + "?" + query.Encode()}
+}
+
+// diagnosticsPage is the discovery report, optionally filtered to one component, as HTML or JSON.
+func (s *site) diagnosticsPage(componentName string, asJSON bool) page {
+	file := "sandbox/__diagnostics"
+	query := url.Values{}
+	if componentName != "" {
+		file = path.Join(file, componentName)
+		query.Set("component", componentName)
+	}
+	ext := ".html"
+	if asJSON {
+		ext = ".json"
+		query.Set("format", "json")
+	}
+	requestURL := "/sandbox/__diagnostics"
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+	return page{file: file + ext, requestURL: requestURL}
+}
+
+// relativeURL returns the link from the page at fromFile to toFile, both relative to the
+// output directory.
+func relativeURL(fromFile, toFile string) string {
+	rel, err := filepath.Rel(filepath.FromSlash(path.Dir(fromFile)), filepath.FromSlash(toFile))
+	if err != nil {
+		return toFile
+	}
+	return filepath.ToSlash(rel)
+}
+
+// staticFile maps a /static/ URL to its exported file. TypeScript and JSX modules are written
+// as transpiled .js files next to where the source was, see copyStatic.
+func staticFile(assetURL string) string {
+	file := strings.TrimPrefix(assetURL, "/")
+	if transpile.IsSource(file) {
+		file = strings.TrimSuffix(file, path.Ext(file)) + ".js"
+	}
+	return file
+}
+
+// String formats a summary for the command line.
+func (s Summary) String() string {
+	summary := fmt.Sprintf("%d pages, %d assets", s.Pages, s.Assets)
+	if s.Problems > 0 {
+		summary += fmt.Sprintf(", %d pages with errors", s.Problems)
+	}
+	return summary
+}
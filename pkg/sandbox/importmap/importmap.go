@@ -0,0 +1,224 @@
+// Package importmap loads the import map shared by the sandbox page and the story frames,
+// and checks it against the files that are actually served.
+package importmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+)
+
+// DefaultPath is where the sandbox looks for the import map, relative to the project root.
+const DefaultPath = "importmap.json"
+
+// ImportMap is the JSON document browsers accept in <script type="importmap">.
+type ImportMap struct {
+	Imports map[string]string            `json:"imports"`
+	Scopes  map[string]map[string]string `json:"scopes,omitempty"`
+
+	path   string // File the map was loaded from, for diagnostics
+	source []byte // Its content, to find the line of an entry
+}
+
+// Load reads and parses an import map file of fsys.
+func Load(fsys fs.FS, path string) (*ImportMap, error) {
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	importMap := &ImportMap{path: path, source: data}
+	if err := json.Unmarshal(data, importMap); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if importMap.Imports == nil {
+		importMap.Imports = make(map[string]string)
+	}
+	return importMap, nil
+}
+
+// HTML returns the map as JSON for a <script type="importmap"> element.
+// html/template does not treat importmap scripts as JavaScript and would HTML-escape a
+// string value, so the JSON is passed as template.HTML. json.Marshal escapes <, > and &,
+// so the content cannot close the script element.
+func (m *ImportMap) HTML() template.HTML {
+	if m == nil {
+		return template.HTML(`{"imports":{}}`)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return template.HTML(`{"imports":{}}`)
+	}
+	return template.HTML(data)
+}
+
+// Mounted returns the map for a sandbox mounted under basePath (e.g. "/_design"): root-relative
+// targets and scopes ("/static/modules/preact.js") move under basePath, and an entry for
+// "/static/" is added, so modules that import root-relative paths ("/static/components/...")
+// load from the mounted static directory. The receiver is not modified; with an empty
+// basePath it is returned as is.
+func (m *ImportMap) Mounted(basePath string) *ImportMap {
+	if m == nil || basePath == "" {
+		return m
+	}
+	mount := func(target string) string {
+		if strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//") {
+			return basePath + target
+		}
+		return target // A URL or a relative path
+	}
+	mountAll := func(imports map[string]string) map[string]string {
+		mounted := make(map[string]string, len(imports)+1)
+		for specifier, target := range imports {
+			mounted[specifier] = mount(target)
+		}
+		return mounted
+	}
+
+	mounted := &ImportMap{Imports: mountAll(m.Imports), path: m.path, source: m.source}
+	if _, ok := mounted.Imports["/static/"]; !ok {
+		mounted.Imports["/static/"] = basePath + "/static/"
+	}
+	if m.Scopes != nil {
+		mounted.Scopes = make(map[string]map[string]string, len(m.Scopes))
+		for scope, imports := range m.Scopes {
+			mounted.Scopes[mount(scope)] = mountAll(imports)
+		}
+	}
+	return mounted
+}
+
+// Resolves reports whether a bare specifier is mapped by the top-level imports, either
+// exactly ("preact") or through a prefix entry ending in "/" ("lodash/" maps "lodash/get").
+func (m *ImportMap) Resolves(specifier string) bool {
+	_, ok := m.Resolve(specifier, "")
+	return ok
+}
+
+// Resolve maps a specifier to its target the way browsers do: the imports of the longest
+// scope that is a prefix of referrer (the importing module's URL path) are tried first, then
+// the top-level imports; within each, an exact entry wins over the longest prefix entry.
+// It reports false if no entry applies.
+func (m *ImportMap) Resolve(specifier, referrer string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	scopes := make([]string, 0, len(m.Scopes))
+	for scope := range m.Scopes {
+		if referrer != "" && strings.HasPrefix(referrer, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Slice(scopes, func(i, j int) bool { return len(scopes[i]) > len(scopes[j]) })
+	for _, scope := range scopes {
+		if target, ok := resolveIn(m.Scopes[scope], specifier); ok {
+			return target, true
+		}
+	}
+	return resolveIn(m.Imports, specifier)
+}
+
+// resolveIn looks a specifier up in one imports object.
+func resolveIn(imports map[string]string, specifier string) (string, bool) {
+	if target, ok := imports[specifier]; ok {
+		return target, true
+	}
+	longest := ""
+	for key := range imports {
+		if strings.HasSuffix(key, "/") && strings.HasPrefix(specifier, key) && len(key) > len(longest) {
+			longest = key
+		}
+	}
+	if longest == "" {
+		return "", false
+	}
+	return imports[longest] + strings.TrimPrefix(specifier, longest), true
+}
+
+// Validate checks that every local target of the map exists in staticFS. urlPrefix is the URL
+// path staticFS is served under (e.g. "/static/"); targets outside it and absolute URLs
+// (CDNs) are not checked. Prefix entries ("lib/": "/static/lib/") must point at a directory.
+func (m *ImportMap) Validate(staticFS fs.FS, urlPrefix string) models.Diagnostics {
+	var diagnostics models.Diagnostics
+	if m == nil {
+		return diagnostics
+	}
+
+	check := func(scope string, imports map[string]string) {
+		specifiers := make([]string, 0, len(imports))
+		for specifier := range imports {
+			specifiers = append(specifiers, specifier)
+		}
+		sort.Strings(specifiers)
+
+		for _, specifier := range specifiers {
+			target := imports[specifier]
+			where := fmt.Sprintf("%q", specifier)
+			if scope != "" {
+				where = fmt.Sprintf("%q in scope %q", specifier, scope)
+			}
+			if strings.HasSuffix(specifier, "/") != strings.HasSuffix(target, "/") {
+				diagnostics = append(diagnostics, m.diagnostic(models.SeverityError, specifier, "%s maps to %q: prefix entries need a trailing slash on both sides", where, target))
+				continue
+			}
+			if !strings.HasPrefix(target, urlPrefix) {
+				continue // A CDN URL or a route not served from the static directory
+			}
+			filePath := strings.TrimSuffix(strings.TrimPrefix(target, urlPrefix), "/")
+			if filePath == "" {
+				filePath = "."
+			}
+			info, err := fs.Stat(staticFS, filePath)
+			switch {
+			case err != nil:
+				diagnostics = append(diagnostics, m.diagnostic(models.SeverityError, specifier, "%s maps to %q, but %s does not exist in the static files", where, target, filePath))
+			case strings.HasSuffix(target, "/") && !info.IsDir():
+				diagnostics = append(diagnostics, m.diagnostic(models.SeverityError, specifier, "%s maps to %q, which is not a directory", where, target))
+			case !strings.HasSuffix(target, "/") && info.IsDir():
+				diagnostics = append(diagnostics, m.diagnostic(models.SeverityError, specifier, "%s maps to %q, which is a directory, not a module", where, target))
+			}
+		}
+	}
+
+	check("", m.Imports)
+	scopes := make([]string, 0, len(m.Scopes))
+	for scope := range m.Scopes {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	for _, scope := range scopes {
+		check(scope, m.Scopes[scope])
+	}
+	return diagnostics
+}
+
+// diagnostic reports a problem with the entry for specifier, at the line where its key
+// first appears in the file.
+func (m *ImportMap) diagnostic(severity models.DiagnosticSeverity, specifier, format string, args ...interface{}) models.Diagnostic {
+	line := 0
+	if key, err := json.Marshal(specifier); err == nil {
+		if offset := bytes.Index(m.source, key); offset >= 0 {
+			line = bytes.Count(m.source[:offset], []byte("\n")) + 1
+		}
+	}
+	return models.Diagnostic{
+		Severity: severity,
+		File:     m.path,
+		Line:     line,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+// IsBare reports whether an import specifier has to be resolved through the import map,
+// i.e. it is neither a relative or absolute path nor a URL.
+func IsBare(specifier string) bool {
+	if strings.HasPrefix(specifier, "./") || strings.HasPrefix(specifier, "../") || strings.HasPrefix(specifier, "/") {
+		return false
+	}
+	return !strings.Contains(specifier, "://") && !strings.HasPrefix(specifier, "data:")
+}
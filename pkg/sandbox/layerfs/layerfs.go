@@ -0,0 +1,128 @@
+// Package layerfs stacks file systems on top of each other, so a project's files on disk can
+// override and extend the sandbox's embedded ones: a file is read from the first layer that
+// has it, and directories list the entries of every layer.
+package layerfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+)
+
+// FS is a read-only union of layers, the first one taking precedence.
+type FS struct {
+	layers []fs.FS
+}
+
+// New returns the union of layers. nil layers are skipped, so optional ones can be passed as is.
+func New(layers ...fs.FS) *FS {
+	union := &FS{}
+	for _, layer := range layers {
+		if layer != nil {
+			union.layers = append(union.layers, layer)
+		}
+	}
+	return union
+}
+
+// Open opens the named file from the first layer that has it. A directory is opened with
+// the merged listing of all layers.
+func (u *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	for _, layer := range u.layers {
+		file, err := layer.Open(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if !info.IsDir() {
+			return file, nil
+		}
+		entries, err := u.ReadDir(name)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &dir{File: file, entries: entries}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir lists a directory across all layers, sorted by name. When layers disagree about
+// an entry, the first layer's entry is listed. A file shadows the layers below it: they are
+// not listed under its name, and listing it is an error.
+func (u *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	seen := make(map[string]bool)
+	var entries []fs.DirEntry
+	found := false
+	for _, layer := range u.layers {
+		info, err := fs.Stat(layer, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			if found {
+				break
+			}
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+		}
+		layerEntries, err := fs.ReadDir(layer, name)
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		for _, entry := range layerEntries {
+			if !seen[entry.Name()] {
+				seen[entry.Name()] = true
+				entries = append(entries, entry)
+			}
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+var errNotDir = errors.New("not a directory")
+
+// dir is an open directory of the union; its listing covers all layers.
+type dir struct {
+	fs.File
+	entries []fs.DirEntry
+	offset  int
+}
+
+// ReadDir implements fs.ReadDirFile with the semantics documented there.
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	d.offset += n
+	return remaining[:n], nil
+}
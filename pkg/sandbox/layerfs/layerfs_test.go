@@ -0,0 +1,107 @@
+package layerfs
+
+import (
+	"errors"
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+var (
+	project = fstest.MapFS{
+		"templates/page.gohtml":    {Data: []byte("project page")},
+		"templates/extra.gohtml":   {Data: []byte("project extra")},
+		"static/app.js":            {Data: []byte("project app")},
+		"static/theme":             {Data: []byte("project file over an embedded directory")},
+		"modules/sandbox/ui.js":    {Data: []byte("project ui")},
+		"modules/sandbox/nested/a": {Data: []byte("project nested")},
+		"importmap.json":           {Data: []byte("{}")},
+	}
+	embedded = fstest.MapFS{
+		"templates/page.gohtml": {Data: []byte("embedded page")},
+		"templates/base.gohtml": {Data: []byte("embedded base")},
+		"static/theme/dark.css": {Data: []byte("embedded dark")},
+		"modules/sandbox":       {Data: []byte("embedded file under a project directory")},
+		"modules/sandbox.js":    {Data: []byte("embedded sandbox")},
+	}
+)
+
+func TestOpen(t *testing.T) {
+	union := New(project, nil, embedded)
+	tests := []struct {
+		name string
+		want string // File content, or "" for an error
+		err  error
+	}{
+		{name: "templates/page.gohtml", want: "project page"},
+		{name: "templates/base.gohtml", want: "embedded base"},
+		{name: "static/theme", want: "project file over an embedded directory"},
+		{name: "static/theme/dark.css", want: "embedded dark"},
+		{name: "modules/sandbox/ui.js", want: "project ui"},
+		{name: "missing.js", err: fs.ErrNotExist},
+		{name: "../outside", err: fs.ErrInvalid},
+		{name: "/templates/page.gohtml", err: fs.ErrInvalid},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := fs.ReadFile(union, test.name)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Errorf("ReadFile(%q) error = %v, want %v", test.name, err, test.err)
+				}
+				return
+			}
+			if err != nil || string(got) != test.want {
+				t.Errorf("ReadFile(%q) = %q, %v, want %q", test.name, got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	union := New(project, embedded)
+	tests := []struct {
+		name string
+		want []string // Entry names, sorted; nil for an error
+		dirs []string // The entries that are directories
+	}{
+		{name: ".", want: []string{"importmap.json", "modules", "static", "templates"}, dirs: []string{"modules", "static", "templates"}},
+		{name: "templates", want: []string{"base.gohtml", "extra.gohtml", "page.gohtml"}},
+		{name: "modules", want: []string{"sandbox", "sandbox.js"}, dirs: []string{"sandbox"}},
+		{name: "modules/sandbox", want: []string{"nested", "ui.js"}, dirs: []string{"nested"}},
+		{name: "static", want: []string{"app.js", "theme"}},
+		{name: "static/theme"},
+		{name: "missing"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries, err := fs.ReadDir(union, test.name)
+			if test.want == nil {
+				if err == nil {
+					t.Errorf("ReadDir(%q) = %v, want an error", test.name, entries)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadDir(%q): %v", test.name, err)
+			}
+			var names, dirs []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+				if entry.IsDir() {
+					dirs = append(dirs, entry.Name())
+				}
+			}
+			if !reflect.DeepEqual(names, test.want) || !reflect.DeepEqual(dirs, test.dirs) {
+				t.Errorf("ReadDir(%q) = %v (directories %v), want %v (directories %v)", test.name, names, dirs, test.want, test.dirs)
+			}
+		})
+	}
+}
+
+func TestFSConformance(t *testing.T) {
+	if err := fstest.TestFS(New(project, embedded), "templates/page.gohtml", "templates/base.gohtml", "static/theme", "modules/sandbox/ui.js", "modules/sandbox.js"); err != nil {
+		t.Error(err)
+	}
+}
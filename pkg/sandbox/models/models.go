@@ -1,30 +1,77 @@
 package models
 
 import (
+	"fmt"
 	"html/template"
+	"strings"
+	"time"
 )
 
 // StoryVariant holds information about a specific story variant.
 type StoryVariant struct {
-	Key            string                 // e.g., "Default", "Ghost", serves as ID
-	Title          string                 // e.g., "Default Button", "Ghost Button"
-	IsSelected     bool                   // True if this is the currently selected story variant
-	Args           map[string]interface{} // Parsed arguments from the story.js export
-	ArgTypes       map[string]ArgTypeInfo // Added ArgTypes map to store type information
-	HasCSR         bool                   // True if client-side rendering is available (always true if discovered from JS)
-	HasSSR         bool                   // True if server-side rendering via Go template is available
-	HasPendingText bool                   // Flag to indicate if the story has pendingText support
+	Key             string                            // e.g., "Default", "Ghost", serves as ID
+	Title           string                            // e.g., "Default Button", "Ghost Button"
+	IsSelected      bool                              // True if this is the currently selected story variant
+	Args            map[string]interface{}            // Parsed arguments from the story.js export
+	ArgTypes        map[string]ArgTypeInfo            // Added ArgTypes map to store type information
+	HasCSR          bool                              // True if client-side rendering is available (always true if discovered from JS)
+	HasSSR          bool                              // True if server-side rendering via Go template is available
+	SSRTemplateName string                            // Component-scoped Go template name, e.g. "button/Default"
+	HasPendingText  bool                              // Flag to indicate if the story has pendingText support
+	Tags            []string                          // Storybook tags: the defaults, then the component's and the story's `tags`
+	SnapshotArgs    map[string]map[string]interface{} // Named arg sets for HTML snapshots (`parameters.snapshots`), each merged over Args
+	Diagnostics     Diagnostics                       // Discovery problems attributed to this story
+}
+
+// SSRTemplateName returns the name under which the {{define "storyKey"}} template from a
+// component's .stories.gohtml file is registered, e.g. "button/Default". Scoping by component
+// keeps two components that both define a "Default" story from colliding.
+func SSRTemplateName(componentName, storyKey string) string {
+	return componentName + "/" + storyKey
+}
+
+// StoryID returns the Storybook ID of a story, e.g. "forms-inputs-textfield--default" for
+// the story exported as Default from a component titled "Forms/Inputs/TextField". Like
+// Storybook, it lowercases both parts and replaces every run of other characters with "-".
+func StoryID(title, exportName string) string {
+	return sanitizeID(title) + "--" + sanitizeID(exportName)
+}
+
+func sanitizeID(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
 }
 
 // ArgTypeInfo stores information about an argument's type and optional metadata
 type ArgTypeInfo struct {
-	Type     ArgType
-	Required bool
-	Control  string   // Optional UI control type (select, radio, etc.)
-	Options  []string // For select/radio controls
-	Min      *float64 // For number type
-	Max      *float64 // For number type
-	Default  *string  // Default value as string
+	Type     ArgType  `json:"type"`
+	Required bool     `json:"required,omitempty"`
+	Control  string   `json:"control,omitempty"` // Optional UI control type (select, radio, inline-radio, range, etc.)
+	Options  []string `json:"options,omitempty"` // For select/radio controls
+	Min      *float64 `json:"min,omitempty"`     // For number type
+	Max      *float64 `json:"max,omitempty"`     // For number type
+	Step     *float64 `json:"step,omitempty"`    // For range controls
+	Default  *string  `json:"default,omitempty"` // Default value as string
+}
+
+// ArgProblem is a query value for an arg that was rejected or sanitized, see args.Error. The
+// args editor lists them.
+type ArgProblem struct {
+	Name   string
+	Value  string
+	Reason string // e.g. "is not a number"
 }
 
 // ArgType represents the data type of an argument
@@ -35,12 +82,15 @@ const (
 	ArgTypeBoolean ArgType = "boolean"
 	ArgTypeNumber  ArgType = "number"
 	ArgTypeHTML    ArgType = "html"
+	ArgTypeArray   ArgType = "array"  // JSON-like []interface{} value
+	ArgTypeObject  ArgType = "object" // JSON-like map[string]interface{} value
 )
 
 // ComponentGroup holds information about a component and its story variants.
 type ComponentGroup struct {
-	Name                string         // e.g., "button"
-	Title               string         // e.g., "Button"
+	Name                string         // e.g., "button"; unique, used in URLs
+	Title               string         // e.g., "Button"; the last segment of the story title
+	Hierarchy           []string       // Sidebar groups above the component, e.g. ["Forms", "Inputs"]
 	Path                string         // Path to the .stories.js file, relative to "static"
 	StoryContent        string         // JavaScript content of the .stories.js file (may not be needed in PageData if only path is used by template)
 	Variants            []StoryVariant // List of story variants
@@ -48,6 +98,170 @@ type ComponentGroup struct {
 	SSRGoHTMLPath       string         // Path to the .stories.gohtml file, relative to "static"
 	ComponentGoHTMLPath string         // Path to the component's .gohtml file (e.g. button.gohtml)
 	CanSSR              bool           // True if this component has associated Go templates for SSR
+	Diagnostics         Diagnostics    // Discovery problems for this component, including those of its variants
+	Imports             []ModuleImport // Modules imported by the story file, checked against the import map
+}
+
+// ModuleImport is an import declaration of a story file.
+type ModuleImport struct {
+	Specifier string // e.g. "preact", "./button.js"
+	Line      int    // 1-based line of the import in the story file
+}
+
+// FullTitle returns the slash-separated story title, e.g. "Forms/Inputs/TextField".
+func (c ComponentGroup) FullTitle() string {
+	if len(c.Hierarchy) == 0 {
+		return c.Title
+	}
+	return strings.Join(c.Hierarchy, "/") + "/" + c.Title
+}
+
+// NavGroup is a section of the sidebar tree. Groups come from the segments of slash-separated
+// story titles (`title: "Forms/Inputs/TextField"`) or, for stories without one, from the
+// directories under static/components.
+type NavGroup struct {
+	Name        string            // Segment name, e.g. "Inputs"; empty for the root
+	Path        string            // Slash-joined segments from the root, e.g. "Forms/Inputs"
+	Groups      []*NavGroup       // Subgroups, in discovery order
+	Components  []*ComponentGroup // Components directly in this group, in discovery order
+	HasSelected bool              // True if the selected component is somewhere below this group
+}
+
+// BuildNavTree arranges components into groups by their Hierarchy. The returned root has no
+// name; components without a hierarchy sit directly in it. The components are referenced,
+// not copied, so IsSelected flags set per request show up in the tree.
+func BuildNavTree(components []ComponentGroup) *NavGroup {
+	root := &NavGroup{}
+	for i := range components {
+		component := &components[i]
+		group := root
+		trail := []*NavGroup{root}
+		for _, segment := range component.Hierarchy {
+			group = group.child(segment)
+			trail = append(trail, group)
+		}
+		group.Components = append(group.Components, component)
+		if component.IsSelected {
+			for _, ancestor := range trail {
+				ancestor.HasSelected = true
+			}
+		}
+	}
+	return root
+}
+
+// child returns the subgroup with the given name, creating it if needed.
+func (g *NavGroup) child(name string) *NavGroup {
+	for _, existing := range g.Groups {
+		if existing.Name == name {
+			return existing
+		}
+	}
+	path := name
+	if g.Path != "" {
+		path = g.Path + "/" + name
+	}
+	group := &NavGroup{Name: name, Path: path}
+	g.Groups = append(g.Groups, group)
+	return group
+}
+
+// DiagnosticSeverity ranks discovery problems.
+type DiagnosticSeverity string
+
+const (
+	SeverityError   DiagnosticSeverity = "error"   // The file or story could not be used at all
+	SeverityWarning DiagnosticSeverity = "warning" // Something was ignored or only partially available
+	SeverityInfo    DiagnosticSeverity = "info"    // Worth knowing, usually intentional (e.g. no SSR templates)
+)
+
+// Diagnostic describes a problem found while discovering stories.
+type Diagnostic struct {
+	Severity  DiagnosticSeverity `json:"severity"`
+	File      string             `json:"file"`             // Path relative to the static root
+	Line      int                `json:"line,omitempty"`   // 1-based, 0 if unknown
+	Column    int                `json:"column,omitempty"` // 1-based, 0 if unknown
+	Component string             `json:"component,omitempty"`
+	StoryKey  string             `json:"storyKey,omitempty"`
+	Message   string             `json:"message"`
+}
+
+// Location returns "file:line:column", omitting the parts that are unknown.
+func (d Diagnostic) Location() string {
+	switch {
+	case d.Line == 0:
+		return d.File
+	case d.Column == 0:
+		return fmt.Sprintf("%s:%d", d.File, d.Line)
+	}
+	return fmt.Sprintf("%s:%d:%d", d.File, d.Line, d.Column)
+}
+
+func (d Diagnostic) String() string {
+	if d.StoryKey != "" {
+		return fmt.Sprintf("%s (story %s): %s", d.Location(), d.StoryKey, d.Message)
+	}
+	return fmt.Sprintf("%s: %s", d.Location(), d.Message)
+}
+
+// Diagnostics is a list of discovery diagnostics.
+type Diagnostics []Diagnostic
+
+// Highest returns the most severe severity in the list, or "" if it is empty.
+// Templates use it to pick the sidebar badge style.
+func (ds Diagnostics) Highest() DiagnosticSeverity {
+	var highest DiagnosticSeverity
+	for _, d := range ds {
+		switch {
+		case d.Severity == SeverityError:
+			return SeverityError
+		case d.Severity == SeverityWarning:
+			highest = SeverityWarning
+		case highest == "":
+			highest = d.Severity
+		}
+	}
+	return highest
+}
+
+// Count returns the number of diagnostics with at least the given severity.
+func (ds Diagnostics) Count(minimum DiagnosticSeverity) int {
+	rank := map[DiagnosticSeverity]int{SeverityInfo: 0, SeverityWarning: 1, SeverityError: 2}
+	count := 0
+	for _, d := range ds {
+		if rank[d.Severity] >= rank[minimum] {
+			count++
+		}
+	}
+	return count
+}
+
+// ParityReport compares the server (Go template) and client (Preact) renderings of one story.
+type ParityReport struct {
+	Component   string             `json:"component"`
+	StoryKey    string             `json:"storyKey"`
+	Error       string             `json:"error,omitempty"` // A side failed to render; Differences is empty then
+	Differences []ParityDifference `json:"differences"`
+}
+
+// ParityDifference is one way the two renderings of a story disagree.
+type ParityDifference struct {
+	Kind   string `json:"kind"`             // "structure", "attribute", "class" or "text"
+	Path   string `json:"path"`             // CSS-like path of the element, e.g. "div.wrap > button"
+	Detail string `json:"detail,omitempty"` // The attribute name, or which side a node exists on
+	SSR    string `json:"ssr,omitempty"`    // What the Go template rendered
+	CSR    string `json:"csr,omitempty"`    // What the Preact component rendered
+}
+
+// HydrationReport is what a story frame in hydrate mode found when Preact hydrated the SSR
+// markup: the places where the component rendered something else than the Go template.
+type HydrationReport struct {
+	Component  string             `json:"component"`
+	StoryKey   string             `json:"storyKey"`
+	URL        string             `json:"url"`             // The frame URL, with the args it was rendered with
+	Error      string             `json:"error,omitempty"` // Loading or hydrating the story failed
+	Mismatches []ParityDifference `json:"mismatches"`      // SSR is the server markup, CSR what Preact rendered over it
+	ReceivedAt time.Time          `json:"receivedAt"`
 }
 
 // ModeSwitchLink holds data for rendering a mode switch button in the toolbar.
@@ -58,21 +272,49 @@ type ModeSwitchLink struct {
 	Text     string // Display text for the button, e.g., "Switch to SSR" or "SSR Active"
 }
 
+// ThemeOption is one theme of the toolbar's theme select.
+type ThemeOption struct {
+	Name     string // e.g. "high-contrast"
+	Label    string // e.g. "High contrast"
+	URL      string // The page in this theme; static exports link to it, as their select cannot submit
+	IsActive bool   // True for the theme shown
+}
+
+// ViewportLink is one size of the toolbar's viewport control.
+type ViewportLink struct {
+	Name     string // The viewport query value, e.g. "mobile"; empty for the full-size frame
+	Label    string // e.g. "Mobile 375×667" or "Fill"
+	URL      string // The page at this size
+	IsActive bool   // True for the size shown
+}
+
+// FormField is a hidden field of a toolbar form, carrying a query parameter the form keeps.
+type FormField struct {
+	Name  string
+	Value string
+}
+
 // PageData holds the data to be passed to the HTML templates.
 type PageData struct {
-	Title                string
-	Components           []ComponentGroup
-	SelectedComponent    *ComponentGroup
-	SelectedStoryKey     string
-	StaticBaseURL        string
-	IsPartialRequest     bool   // True if the request is for a partial update (e.g., via X-Mach-Request)
-	ClientTargetSelector string // Optional: The selector client intends to update, for logging/debug
-	Theme                string // e.g., "light", "dark"
-	ToggleThemeURL       string // URL for the theme toggle button
-	ResetArgsURL         string // URL for the Reset Args button
+	Title             string
+	Components        []ComponentGroup
+	SelectedComponent *ComponentGroup
+	SelectedStoryKey  string
+	BasePath          string         // Path the sandbox is mounted under ("" at the root); prefix of every sandbox link
+	StaticBaseURL     string         // URL of the static directory, BasePath + "/static"
+	IsPartialRequest  bool           // True if the request is for a partial update (e.g., via X-Mach-Request)
+	Theme             string         // A registered theme, e.g. "light" or "high-contrast"
+	ThemeOptions      []ThemeOption  // The toolbar's theme select, one option per registered theme
+	ThemeFormURL      string         // Action of the theme select's form
+	ThemeFormFields   []FormField    // The page's query without the theme, kept by the theme select
+	ResetArgsURL      string         // URL for the Reset Args button
+	Viewport          string         // The viewport query value of the story frame, e.g. "mobile" or "390x844"; empty fills the page
+	ViewportWidth     int            // Width of the story frame in CSS pixels; 0 with an empty Viewport
+	ViewportHeight    int            // Height of the story frame in CSS pixels; 0 with an empty Viewport
+	ViewportLinks     []ViewportLink // The toolbar's viewport control
 
 	// SSR Specific Fields
-	RenderMode            string                 // "csr" or "ssr"
+	RenderMode            string                 // "csr", "ssr" or "hydrate"
 	SSRContent            template.HTML          // Rendered HTML content for the story if mode is SSR
 	SelectedStoryArgs     map[string]interface{} // Arguments for the selected story, for SSR
 	ToolbarHTML           template.HTML          // Rendered HTML for the toolbar, if mode is SSR
@@ -83,11 +325,47 @@ type PageData struct {
 	CurrentPath           string                 // New: The current request path, for form actions
 	CanClientSideNavigate bool                   // New: True if client is JS-enabled (for mode switching UI)
 	SSRAvailable          bool                   // New: True if the selected story has a valid SSR template
+	ImportMapJSON         template.HTML          // Import map for the page head, see importmap.ImportMap.HTML
+	StaticExport          bool                   // True when the page is written to disk by the export command: no live reload or partial navigation
+	LiveReload            bool                   // Load live-reload.js: the server has live reload on and the page is not exported
+	HydrationReport       *HydrationReport       // The last hydration of the selected story, in hydrate mode; nil before the first
+	ArgProblems           []ArgProblem           // Args in the URL that were rejected or sanitized, for the args editor
+	Nonce                 string                 // CSP nonce of the response, on every inline script and style
+
+	// Discovery diagnostics
+	Diagnostics         Diagnostics // All discovery diagnostics, for the sidebar summary
+	IsDiagnosticsPage   bool        // True when the main area shows the diagnostics report instead of a story
+	DiagnosticsFilter   string      // Component name the diagnostics report is filtered to, if any
+	FilteredDiagnostics Diagnostics // Diagnostics shown on the report page
+
+	// CSR/SSR parity report
+	IsParityPage  bool           // True when the main area shows the parity report instead of a story
+	ParityFilter  string         // Component name the parity report is limited to, if any
+	ParityReports []ParityReport // One per story rendered both ways
+}
+
+// HasSSRStories reports whether any component has SSR templates, i.e. whether the parity
+// report has anything to compare.
+func (p PageData) HasSSRStories() bool {
+	for _, component := range p.Components {
+		if component.CanSSR {
+			return true
+		}
+	}
+	return false
+}
+
+// NavTree returns the sidebar tree for the page's components.
+func (p PageData) NavTree() *NavGroup {
+	return BuildNavTree(p.Components)
 }
 
 // CSRFrameData holds data for the sandbox_csr_frame.gohtml template.
 type CSRFrameData struct {
 	Theme                 string
+	BasePath              string           // Path the sandbox is mounted under, see PageData
+	StaticBaseURL         string           // URL of the static directory, BasePath + "/static"
+	ImportMapJSON         template.HTML    // Import map for the frame head, same as the page's
 	SandboxConfigJSON     template.JS      // Marshalled JSON for sandbox config
 	SandboxScriptToLoad   string           // Path to sandbox-app.js or sandbox-fallback.js
 	IsFallback            bool             // To show different loading text
@@ -95,4 +373,9 @@ type CSRFrameData struct {
 	IframeSrcURL          string           // New: Source URL for the content iframe
 	CurrentPath           string           // New: The current request path, for form actions
 	CanClientSideNavigate bool             // New: True if client is JS-enabled (for mode switching UI)
+	StaticExport          bool             // True when the frame is written to disk by the export command
+	LiveReload            bool             // Load live-reload.js, see PageData
+	SSRContent            template.HTML    // Server markup to hydrate, in hydrate mode; empty otherwise
+	ComponentCSSPath      string           // Stylesheet of the component, linked in hydrate mode like in the SSR layout
+	Nonce                 string           // CSP nonce of the response, see PageData
 }
@@ -0,0 +1,133 @@
+package parity
+
+import (
+	_ "embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+
+	"kormsen.com/machine-ui/pkg/sandbox/importmap"
+	"kormsen.com/machine-ui/pkg/sandbox/transpile"
+)
+
+//go:embed render.js
+var renderModule string
+
+const (
+	staticURL       = "/static/"                // URL path the static directory is served under
+	renderSpecifier = "sandbox:parity-render"   // The entry's import of render.js
+	staticNamespace = "sandbox-static"          // esbuild namespace of files read from the static directory
+	renderNamespace = "sandbox-parity-renderer" // esbuild namespace of render.js
+)
+
+// bundle builds one script from a story file and everything it imports, for the JavaScript
+// engine, which cannot load modules itself. Imports are resolved the way the browser resolves
+// them in a story frame: through the import map, relative to the importing module's URL, or
+// root-relative under /static/, with the transpile handler's TypeScript fallbacks. The script
+// defines a global renderStory(storyKey, argsJSON) that returns the story's HTML.
+func bundle(staticFS fs.FS, importMap *importmap.ImportMap, storyPath string) (string, error) {
+	entry := fmt.Sprintf("import * as module from %q;\n"+
+		"import { renderStory } from %q;\n"+
+		"globalThis.renderStory = (storyKey, argsJSON) => renderStory(module, storyKey, JSON.parse(argsJSON));\n",
+		staticURL+storyPath, renderSpecifier)
+
+	result := api.Build(api.BuildOptions{
+		Stdin: &api.StdinOptions{
+			Contents:   entry,
+			Sourcefile: "parity-entry.js",
+			Loader:     api.LoaderJS,
+		},
+		Bundle:          true,
+		Write:           false,
+		Format:          api.FormatIIFE,
+		Platform:        api.PlatformNeutral,
+		Target:          api.ES2017, // Lowers object spread and optional chaining for the engine
+		JSX:             api.JSXAutomatic,
+		JSXImportSource: transpile.JSXImportSource,
+		LogLevel:        api.LogLevelSilent,
+		Plugins:         []api.Plugin{staticPlugin(staticFS, importMap)},
+	})
+	if len(result.Errors) > 0 {
+		first := result.Errors[0]
+		if first.Location != nil {
+			return "", fmt.Errorf("%s:%d:%d: %s", strings.TrimPrefix(first.Location.File, staticNamespace+":"+staticURL), first.Location.Line, first.Location.Column+1, first.Text)
+		}
+		return "", fmt.Errorf("%s", first.Text)
+	}
+	return string(result.OutputFiles[0].Contents), nil
+}
+
+// staticPlugin resolves every import to a file of staticFS (or to render.js) and loads it.
+// Paths in the static namespace are URL paths, "/static/components/button/button.js".
+func staticPlugin(staticFS fs.FS, importMap *importmap.ImportMap) api.Plugin {
+	return api.Plugin{
+		Name: "sandbox-static",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(api.OnResolveOptions{Filter: ".*"}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+				if args.Path == renderSpecifier {
+					return api.OnResolveResult{Path: "render.js", Namespace: renderNamespace}, nil
+				}
+				referrer := ""
+				if args.Namespace == staticNamespace {
+					referrer = args.Importer
+				}
+
+				target, mapped := importMap.Resolve(args.Path, referrer)
+				switch {
+				case mapped:
+				case strings.HasPrefix(args.Path, "./") || strings.HasPrefix(args.Path, "../"):
+					if referrer == "" {
+						return api.OnResolveResult{}, fmt.Errorf("relative import %q outside a static module", args.Path)
+					}
+					target = path.Join(path.Dir(referrer), args.Path)
+				case strings.HasPrefix(args.Path, "/") && !strings.HasPrefix(args.Path, "//"):
+					target = args.Path
+				default:
+					return api.OnResolveResult{}, fmt.Errorf("cannot resolve %q: not in the import map", args.Path)
+				}
+				if !strings.HasPrefix(target, staticURL) {
+					return api.OnResolveResult{}, fmt.Errorf("cannot load %q (%s): only files under %s can be rendered on the server", args.Path, target, staticURL)
+				}
+				return api.OnResolveResult{Path: target, Namespace: staticNamespace}, nil
+			})
+
+			build.OnLoad(api.OnLoadOptions{Filter: ".*", Namespace: renderNamespace}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+				return api.OnLoadResult{Contents: &renderModule, Loader: api.LoaderJS}, nil
+			})
+
+			build.OnLoad(api.OnLoadOptions{Filter: ".*", Namespace: staticNamespace}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+				filePath := strings.TrimPrefix(args.Path, staticURL)
+				if source, ok := transpile.Resolve(staticFS, filePath); ok {
+					filePath = source
+				}
+				data, err := fs.ReadFile(staticFS, filePath)
+				if err != nil {
+					return api.OnLoadResult{}, err
+				}
+				contents := string(data)
+				return api.OnLoadResult{Contents: &contents, Loader: loaderFor(filePath)}, nil
+			})
+		},
+	}
+}
+
+// loaderFor picks the esbuild loader for a static file. Stylesheets imported by components
+// do not affect markup and are left out.
+func loaderFor(filePath string) api.Loader {
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".ts", ".mts":
+		return api.LoaderTS
+	case ".tsx":
+		return api.LoaderTSX
+	case ".jsx":
+		return api.LoaderJSX
+	case ".json":
+		return api.LoaderJSON
+	case ".css":
+		return api.LoaderEmpty
+	}
+	return api.LoaderJS
+}
@@ -0,0 +1,266 @@
+package parity
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+)
+
+// Difference kinds, from the most to the least severe.
+const (
+	KindStructure = "structure" // An element or text node exists on one side only
+	KindAttribute = "attribute" // An attribute is missing or has another value
+	KindClass     = "class"     // The class lists differ (order is ignored)
+	KindText      = "text"      // Text content differs
+)
+
+// node is a normalized DOM node: an element, or text when tag is empty.
+type node struct {
+	tag        string
+	attributes map[string]string // Without class
+	classes    []string          // Sorted, without duplicates
+	text       string            // Whitespace collapsed
+	children   []*node
+}
+
+// booleanAttributes only matter by presence: disabled, disabled="" and disabled="disabled"
+// are the same.
+var booleanAttributes = map[string]bool{
+	"allowfullscreen": true, "async": true, "autofocus": true, "autoplay": true, "checked": true,
+	"controls": true, "default": true, "defer": true, "disabled": true, "formnovalidate": true,
+	"hidden": true, "inert": true, "ismap": true, "loop": true, "multiple": true, "muted": true,
+	"nomodule": true, "novalidate": true, "open": true, "readonly": true, "required": true,
+	"reversed": true, "selected": true,
+}
+
+// parse parses an HTML fragment as the content of a <div> and normalizes it: comments are
+// dropped, whitespace in text is collapsed and whitespace-only text between elements is
+// removed, so template indentation does not count as a difference.
+func parse(fragment string) ([]*node, error) {
+	context := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), context)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeNodes(nodes), nil
+}
+
+func normalizeNodes(nodes []*html.Node) []*node {
+	var normalized []*node
+	for _, n := range nodes {
+		switch n.Type {
+		case html.TextNode:
+			text := strings.Join(strings.Fields(n.Data), " ")
+			if text == "" {
+				continue
+			}
+			if last := len(normalized) - 1; last >= 0 && normalized[last].tag == "" {
+				normalized[last].text += " " + text // Text split by a dropped comment
+				continue
+			}
+			normalized = append(normalized, &node{text: text})
+		case html.ElementNode:
+			element := &node{tag: n.Data, attributes: make(map[string]string)}
+			for _, attribute := range n.Attr {
+				key := attribute.Key
+				if attribute.Namespace != "" {
+					key = attribute.Namespace + ":" + key
+				}
+				switch {
+				case key == "class":
+					element.classes = strings.Fields(attribute.Val)
+				case key == "style":
+					element.attributes[key] = normalizeStyle(attribute.Val)
+				case booleanAttributes[key]:
+					element.attributes[key] = ""
+				default:
+					element.attributes[key] = attribute.Val
+				}
+			}
+			sort.Strings(element.classes)
+			element.classes = slices.Compact(element.classes)
+			var children []*html.Node
+			for child := n.FirstChild; child != nil; child = child.NextSibling {
+				children = append(children, child)
+			}
+			element.children = normalizeNodes(children)
+			normalized = append(normalized, element)
+		}
+	}
+	return normalized
+}
+
+// normalizeStyle makes "color: red;  margin:0" and "color:red;margin:0;" equal.
+func normalizeStyle(style string) string {
+	var declarations []string
+	for _, declaration := range strings.Split(style, ";") {
+		name, value, found := strings.Cut(declaration, ":")
+		if !found {
+			continue
+		}
+		declarations = append(declarations, strings.ToLower(strings.TrimSpace(name))+":"+strings.Join(strings.Fields(value), " "))
+	}
+	return strings.Join(declarations, ";")
+}
+
+// compare reports the differences between the SSR and the CSR children of the element at path.
+// Children are aligned by a longest common subsequence of their tags, so one extra element
+// shows up as that element, not as every sibling after it.
+func compare(ssr, csr []*node, path string) []models.ParityDifference {
+	key := func(n *node) string {
+		if n.tag == "" {
+			return "#text"
+		}
+		return n.tag
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of ssr[i:] and csr[j:]
+	lcs := make([][]int, len(ssr)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(csr)+1)
+	}
+	for i := len(ssr) - 1; i >= 0; i-- {
+		for j := len(csr) - 1; j >= 0; j-- {
+			if key(ssr[i]) == key(csr[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	where := path
+	if where == "" {
+		where = "(root)"
+	}
+	var differences []models.ParityDifference
+	i, j := 0, 0
+	for i < len(ssr) || j < len(csr) {
+		switch {
+		case i < len(ssr) && j < len(csr) && key(ssr[i]) == key(csr[j]):
+			differences = append(differences, compareNode(ssr[i], csr[j], childPath(path, ssr, i))...)
+			i++
+			j++
+		case i < len(ssr) && (j == len(csr) || lcs[i+1][j] >= lcs[i][j+1]):
+			differences = append(differences, models.ParityDifference{Kind: KindStructure, Path: where, Detail: "only in SSR", SSR: describe(ssr[i])})
+			i++
+		default:
+			differences = append(differences, models.ParityDifference{Kind: KindStructure, Path: where, Detail: "only in CSR", CSR: describe(csr[j])})
+			j++
+		}
+	}
+	return differences
+}
+
+// compareNode compares two nodes of the same kind and tag, and their children.
+func compareNode(ssr, csr *node, path string) []models.ParityDifference {
+	if ssr.tag == "" {
+		if ssr.text != csr.text {
+			return []models.ParityDifference{{Kind: KindText, Path: path, SSR: ssr.text, CSR: csr.text}}
+		}
+		return nil
+	}
+
+	var differences []models.ParityDifference
+	if !slices.Equal(ssr.classes, csr.classes) {
+		var onlySSR, onlyCSR []string
+		for _, class := range ssr.classes {
+			if !slices.Contains(csr.classes, class) {
+				onlySSR = append(onlySSR, class)
+			}
+		}
+		for _, class := range csr.classes {
+			if !slices.Contains(ssr.classes, class) {
+				onlyCSR = append(onlyCSR, class)
+			}
+		}
+		differences = append(differences, models.ParityDifference{Kind: KindClass, Path: path, SSR: strings.Join(onlySSR, " "), CSR: strings.Join(onlyCSR, " ")})
+	}
+
+	names := make([]string, 0, len(ssr.attributes)+len(csr.attributes))
+	for name := range ssr.attributes {
+		names = append(names, name)
+	}
+	for name := range csr.attributes {
+		if _, shared := ssr.attributes[name]; !shared {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ssrValue, inSSR := ssr.attributes[name]
+		csrValue, inCSR := csr.attributes[name]
+		if inSSR == inCSR && ssrValue == csrValue {
+			continue
+		}
+		differences = append(differences, models.ParityDifference{Kind: KindAttribute, Path: path, Detail: name, SSR: attributeValue(ssrValue, inSSR), CSR: attributeValue(csrValue, inCSR)})
+	}
+
+	return append(differences, compare(ssr.children, csr.children, path)...)
+}
+
+// childPath returns the path of siblings[index] below parent, e.g. "div.wrap > button:nth-of-type(2)".
+// The position is only added when the parent has more than one element of that tag.
+func childPath(parent string, siblings []*node, index int) string {
+	n := siblings[index]
+	segment := n.tag
+	if n.tag == "" {
+		segment = "#text"
+	} else if id := n.attributes["id"]; id != "" {
+		segment += "#" + id
+	} else if len(n.classes) > 0 {
+		segment += "." + n.classes[0]
+	}
+	position, count := 0, 0
+	for i, sibling := range siblings {
+		if sibling.tag == n.tag {
+			count++
+			if i <= index {
+				position++
+			}
+		}
+	}
+	if count > 1 {
+		segment += ":nth-of-type(" + strconv.Itoa(position) + ")"
+	}
+	if parent == "" {
+		return segment
+	}
+	return parent + " > " + segment
+}
+
+// describe renders a node's opening tag (or text) for a structure difference.
+func describe(n *node) string {
+	if n.tag == "" {
+		return strconv.Quote(n.text)
+	}
+	var b strings.Builder
+	b.WriteString("<" + n.tag)
+	if len(n.classes) > 0 {
+		fmt.Fprintf(&b, " class=%q", strings.Join(n.classes, " "))
+	}
+	names := make([]string, 0, len(n.attributes))
+	for name := range n.attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, " %s=%q", name, n.attributes[name])
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+func attributeValue(value string, present bool) string {
+	if !present {
+		return "(absent)"
+	}
+	return strconv.Quote(value)
+}
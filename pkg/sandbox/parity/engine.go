@@ -0,0 +1,83 @@
+package parity
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/grafana/sobek"
+)
+
+// renderTimeout bounds a single story render, so a story that loops forever cannot hang the
+// check.
+const renderTimeout = 5 * time.Second
+
+// engine is a JavaScript runtime with one story file's bundle loaded. It is not safe for
+// concurrent use.
+type engine struct {
+	runtime     *sobek.Runtime
+	renderStory sobek.Callable
+}
+
+// newEngine runs a bundle from bundle(). Only what Preact and typical components touch at
+// module level exists besides the language itself: console (logged) and timers (never fire).
+// There is no DOM.
+func newEngine(script, storyPath string) (*engine, error) {
+	runtime := sobek.New()
+	runtime.SetMaxCallStackSize(10000)
+
+	console := runtime.NewObject()
+	for _, level := range []string{"log", "info", "debug", "warn", "error"} {
+		_ = console.Set(level, func(call sobek.FunctionCall) sobek.Value {
+			args := make([]string, len(call.Arguments))
+			for i, arg := range call.Arguments {
+				args[i] = arg.String()
+			}
+			log.Printf("Parity: %s: console.%s: %s", storyPath, level, strings.Join(args, " "))
+			return sobek.Undefined()
+		})
+	}
+	_ = runtime.Set("console", console)
+	noTimer := func(sobek.FunctionCall) sobek.Value { return runtime.ToValue(0) }
+	for _, name := range []string{"setTimeout", "clearTimeout", "setInterval", "clearInterval", "requestAnimationFrame", "cancelAnimationFrame"} {
+		_ = runtime.Set(name, noTimer)
+	}
+
+	if _, err := run(runtime, func() (sobek.Value, error) { return runtime.RunString(script) }); err != nil {
+		return nil, fmt.Errorf("loading %s: %w", storyPath, err)
+	}
+	renderStory, ok := sobek.AssertFunction(runtime.Get("renderStory"))
+	if !ok {
+		return nil, errors.New("the bundle did not define renderStory")
+	}
+	return &engine{runtime: runtime, renderStory: renderStory}, nil
+}
+
+// render returns the HTML of a story with args, passed as JSON like the CSR frame's config.
+func (e *engine) render(storyKey, argsJSON string) (string, error) {
+	value, err := run(e.runtime, func() (sobek.Value, error) {
+		return e.renderStory(sobek.Undefined(), e.runtime.ToValue(storyKey), e.runtime.ToValue(argsJSON))
+	})
+	if err != nil {
+		return "", err
+	}
+	return value.String(), nil
+}
+
+// run calls fn with the render timeout and turns JavaScript exceptions into plain errors.
+func run(runtime *sobek.Runtime, fn func() (sobek.Value, error)) (sobek.Value, error) {
+	timer := time.AfterFunc(renderTimeout, func() {
+		runtime.Interrupt(fmt.Sprintf("timed out after %s", renderTimeout))
+	})
+	defer timer.Stop()
+	defer runtime.ClearInterrupt()
+
+	value, err := fn()
+	var exception *sobek.Exception
+	if errors.As(err, &exception) {
+		return nil, errors.New(exception.Value().String())
+	}
+	return value, err
+}
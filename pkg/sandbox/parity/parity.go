@@ -0,0 +1,114 @@
+// Package parity checks that a story's Go template (SSR) and its Preact component (CSR)
+// render the same markup. The Preact side is rendered to HTML on the server, by an embedded
+// JavaScript engine running the story file with Preact and htm from the static directory (see
+// render.js); both results are parsed, normalized and compared element by element. The report
+// lists structural, attribute, class and text differences per story.
+//
+// Only the initial markup is compared: effects do not run and there is no DOM, so anything a
+// component changes after mounting is not seen.
+package parity
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"log"
+
+	"kormsen.com/machine-ui/pkg/sandbox/importmap"
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+	"kormsen.com/machine-ui/pkg/sandbox/renderer"
+)
+
+// Options configures a parity check.
+type Options struct {
+	Static    fs.FS                // The static directory, as served under /static/
+	ImportMap *importmap.ImportMap // Resolves the bare imports of story files and components
+	Templates *template.Template   // The SSR templates, see renderer.LoadTemplates
+	Theme     string               // Passed to the SSR templates as .Theme
+}
+
+// Check renders every story of components that has both a CSR and an SSR rendering, with its
+// default args, and returns a report per story, in discovery order. Stories that exist in one
+// mode only are left out.
+func Check(components []models.ComponentGroup, options Options) []models.ParityReport {
+	var reports []models.ParityReport
+	for i := range components {
+		component := &components[i]
+		var variants []*models.StoryVariant
+		for j := range component.Variants {
+			variant := &component.Variants[j]
+			if variant.HasCSR && variant.HasSSR && options.Templates != nil && options.Templates.Lookup(variant.SSRTemplateName) != nil {
+				variants = append(variants, variant)
+			}
+		}
+		if len(variants) == 0 {
+			continue
+		}
+
+		// One bundle and engine per story file; a failure affects all of its stories
+		var js *engine
+		script, err := bundle(options.Static, options.ImportMap, component.Path)
+		if err == nil {
+			js, err = newEngine(script, component.Path)
+		}
+		for _, variant := range variants {
+			report := models.ParityReport{Component: component.Name, StoryKey: variant.Key, Differences: []models.ParityDifference{}}
+			if err != nil {
+				report.Error = "CSR: " + err.Error()
+			} else {
+				report.Differences, report.Error = checkStory(js, variant, options)
+			}
+			if report.Error != "" {
+				log.Printf("Parity: %s/%s: %s", component.Name, variant.Key, report.Error)
+			}
+			reports = append(reports, report)
+		}
+	}
+	return reports
+}
+
+// checkStory renders one story both ways and compares the results. The args are the story's
+// defaults, passed to each side the way the sandbox frames pass them.
+func checkStory(js *engine, variant *models.StoryVariant, options Options) ([]models.ParityDifference, string) {
+	ssrHTML, err := renderer.RenderStory(options.Templates, variant.SSRTemplateName, variant.Args, options.Theme)
+	if err != nil {
+		return []models.ParityDifference{}, "SSR: " + err.Error()
+	}
+	args := variant.Args
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	argsJSON, err := json.Marshal(args)
+	if err != nil {
+		return []models.ParityDifference{}, "CSR: encoding args: " + err.Error()
+	}
+	csrHTML, err := js.render(variant.Key, string(argsJSON))
+	if err != nil {
+		return []models.ParityDifference{}, "CSR: " + err.Error()
+	}
+
+	ssrNodes, err := parse(string(ssrHTML))
+	if err != nil {
+		return []models.ParityDifference{}, "SSR: parsing output: " + err.Error()
+	}
+	csrNodes, err := parse(csrHTML)
+	if err != nil {
+		return []models.ParityDifference{}, "CSR: parsing output: " + err.Error()
+	}
+	differences := compare(ssrNodes, csrNodes, "")
+	if differences == nil {
+		differences = []models.ParityDifference{}
+	}
+	return differences, ""
+}
+
+// Failed counts the reports with differences or errors.
+func Failed(reports []models.ParityReport) int {
+	failed := 0
+	for _, report := range reports {
+		if report.Error != "" || len(report.Differences) > 0 {
+			failed++
+		}
+	}
+	return failed
+}
@@ -0,0 +1,203 @@
+// Renders Preact stories to HTML strings for the parity check (see parity.go). It runs in the
+// embedded JavaScript engine, bundled with the story module, and does what
+// preact-render-to-string does, reduced to what stories need: function and class components,
+// context, and hooks and signals through Preact's option hooks. Effects are not run, as on a
+// server. The property names below (__b, __c, __H, ...) are Preact 10's mangled internals.
+import { options, Fragment } from "preact";
+
+const VOID_ELEMENTS = new Set([
+  "area", "base", "br", "col", "embed", "hr", "img", "input", "link", "meta", "source", "track", "wbr",
+]);
+
+// Style properties whose numbers are not lengths; the same pattern Preact uses
+const NON_DIMENSIONAL = /acit|ex(?:s|g|n|p|$)|rph|grid|ows|mnc|ntw|ine[ch]|zoo|^ord|itera/i;
+
+// Props that never become attributes
+const SKIPPED_PROPS = new Set(["children", "key", "ref", "__source", "__self", "dangerouslySetInnerHTML"]);
+
+function escapeHTML(value) {
+  return String(value)
+    .replace(/&/g, "&amp;")
+    .replace(/</g, "&lt;")
+    .replace(/>/g, "&gt;")
+    .replace(/"/g, "&quot;");
+}
+
+function styleString(style) {
+  if (typeof style === "string") return style;
+  let css = "";
+  for (const property in style) {
+    const value = style[property];
+    if (value == null || value === "" || value === false) continue;
+    const name = property[0] === "-" ? property : property.replace(/[A-Z]/g, (c) => "-" + c.toLowerCase());
+    const unit = typeof value === "number" && !NON_DIMENSIONAL.test(property) ? "px" : "";
+    css += name + ":" + value + unit + ";";
+  }
+  return css;
+}
+
+function markDirty() {
+  this.__d = true;
+}
+
+function renderAttributes(type, props, selectValue) {
+  let attributes = "";
+  for (let name in props) {
+    let value = props[name];
+    if (SKIPPED_PROPS.has(name) || value == null || typeof value === "function") continue;
+    // Like Preact's setAttribute: false removes an attribute, except aria-* and data-*
+    if (value === false && name[4] !== "-") continue;
+    if (name === "className") name = "class";
+    else if (name === "htmlFor") name = "for";
+    else if (name === "xlinkHref") name = "xlink:href";
+    else if (name === "defaultValue") name = "value";
+    else if (name === "defaultChecked") name = "checked";
+    if (name === "value" && (type === "select" || type === "textarea")) continue; // Rendered as content
+    if (name === "style" && typeof value === "object") value = styleString(value);
+    if (value === true) {
+      attributes += " " + name;
+      continue;
+    }
+    attributes += " " + name + '="' + escapeHTML(value) + '"';
+  }
+  if (type === "option" && selectValue != null && String(props.value ?? "") === String(selectValue) && !("selected" in props)) {
+    attributes += " selected";
+  }
+  return attributes;
+}
+
+function renderComponent(vnode, context) {
+  const type = vnode.type;
+  const props = vnode.props;
+  const contextType = type.contextType;
+  let componentContext = context;
+  if (contextType != null) {
+    const provider = context[contextType.__c];
+    componentContext = provider ? provider.props.value : contextType.__;
+  }
+
+  let component;
+  let rendered;
+  if (type.prototype && typeof type.prototype.render === "function") {
+    component = vnode.__c = new type(props, componentContext);
+    component.__v = vnode;
+    component.props = props;
+    component.context = componentContext;
+    component.__d = true;
+    component.__h = [];
+    if (component.state == null) component.state = {};
+    if (type.getDerivedStateFromProps) {
+      component.state = Object.assign({}, component.state, type.getDerivedStateFromProps(props, component.state));
+    } else if (component.componentWillMount) {
+      component.componentWillMount();
+    }
+    if (options.__r) options.__r(vnode);
+    rendered = component.render(component.props, component.state, component.context);
+  } else {
+    // Hooks keep their state on this object, found through vnode.__c
+    component = vnode.__c = {
+      __v: vnode,
+      props,
+      context,
+      setState: markDirty,
+      forceUpdate: markDirty,
+      __d: true,
+      __h: [],
+    };
+    // A setState during render renders again, as on the client
+    for (let passes = 0; component.__d && passes < 25; passes++) {
+      component.__d = false;
+      if (options.__r) options.__r(vnode);
+      rendered = type.call(component, props, componentContext);
+    }
+    component.__d = true;
+  }
+
+  if (component.getChildContext != null) {
+    context = Object.assign({}, context, component.getChildContext());
+  }
+  return { rendered, context };
+}
+
+function renderVNode(vnode, context, parent, selectValue) {
+  if (vnode == null || vnode === true || vnode === false || vnode === "") return "";
+  if (typeof vnode !== "object") {
+    return typeof vnode === "function" ? "" : escapeHTML(vnode);
+  }
+  if (Array.isArray(vnode)) {
+    let html = "";
+    parent.__k = vnode;
+    for (const child of vnode) html += renderVNode(child, context, parent, selectValue);
+    return html;
+  }
+  if (vnode.constructor !== undefined) return ""; // Not a vnode, e.g. a plain object as a child
+
+  vnode.__ = parent;
+  if (options.__b) options.__b(vnode);
+
+  const type = vnode.type;
+  const props = vnode.props;
+  if (typeof type === "function") {
+    let rendered = props.children;
+    if (type !== Fragment) {
+      ({ rendered, context } = renderComponent(vnode, context));
+    }
+    try {
+      return renderVNode(rendered, context, vnode, selectValue);
+    } finally {
+      if (options.diffed) options.diffed(vnode);
+      vnode.__ = null;
+      if (options.unmount) options.unmount(vnode);
+    }
+  }
+
+  let html = "<" + type + renderAttributes(type, props, selectValue) + ">";
+  if (VOID_ELEMENTS.has(type)) {
+    if (options.diffed) options.diffed(vnode);
+    return html;
+  }
+
+  let children = props.children;
+  if (type === "select") selectValue = props.value ?? props.defaultValue;
+  if (type === "textarea") children = props.value ?? props.defaultValue ?? children;
+  if (props.dangerouslySetInnerHTML != null) {
+    html += props.dangerouslySetInnerHTML.__html ?? "";
+  } else {
+    html += renderVNode(children, context, vnode, selectValue);
+  }
+  if (options.diffed) options.diffed(vnode);
+  return html + "</" + type + ">";
+}
+
+/**
+ * Renders a vnode tree to HTML.
+ * @param {object} vnode
+ * @returns {string}
+ */
+export function renderToString(vnode) {
+  const skipEffects = options.__s;
+  options.__s = true; // Hooks do not queue effects
+  const root = { __k: null, __: null }; // Stands in for the mount point; useId stops at its null parent
+  try {
+    return renderVNode(vnode, {}, root, undefined);
+  } finally {
+    options.__s = skipEffects;
+  }
+}
+
+/**
+ * Renders a story of a story module the way iframe-client.js does: the component args, the
+ * story's own args and the given args, passed to the story's render function.
+ * @param {object} module - The story module's exports
+ * @param {string} storyKey - Export name of the story
+ * @param {object} args - Args from the sandbox, already decoded
+ * @returns {string}
+ */
+export function renderStory(module, storyKey, args) {
+  const story = module[storyKey];
+  if (!story || typeof story.render !== "function") {
+    throw new Error(`story ${storyKey} has no render function`);
+  }
+  const componentArgs = (module.default && module.default.args) || {};
+  return renderToString(story.render({ ...componentArgs, ...(story.args || {}), ...args }));
+}
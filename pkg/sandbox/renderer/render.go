@@ -1,20 +1,29 @@
 package renderer
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	pathpkg "path"
 	"strings"
+	"text/template/parse"
+
+	"kormsen.com/machine-ui/pkg/sandbox/args"
+	"kormsen.com/machine-ui/pkg/sandbox/models"
 )
 
-// LoadTemplates parses all HTML templates from the given base directories.
+// LoadTemplates parses all HTML templates from the given base directories of fsys.
 // It walks each directory tree and parses all files ending with .gohtml or .html.
-// It includes custom functions like "safeJS", "dict", and "html" in the template FuncMap.
-func LoadTemplates(templateBaseDirs []string) (*template.Template, error) {
+// A directory missing from fsys is skipped, so optional template directories can be listed.
+// Story templates (*.stories.gohtml) are registered under component-scoped names, see
+// models.SSRTemplateName, and duplicate definitions are reported as an error.
+// It includes custom functions like "safeJS", "dict", "html", "toJSON" and "argParam" in the template FuncMap.
+func LoadTemplates(fsys fs.FS, templateBaseDirs []string) (*template.Template, error) {
 	funcMap := template.FuncMap{
 		"safeJS": func(s string) template.JS {
 			return template.JS(s)
@@ -50,22 +59,46 @@ func LoadTemplates(templateBaseDirs []string) (*template.Template, error) {
 			return value
 		},
 		"ToUpper": strings.ToUpper,
+		"toJSON": func(value interface{}) (string, error) {
+			// Used by the args editor to show array and object args as editable JSON.
+			encoded, err := json.MarshalIndent(value, "", "  ")
+			if err != nil {
+				return "", err
+			}
+			return string(encoded), nil
+		},
+		"argParam": args.Param, // The args editor's field names, e.g. "args.theme" for an arg named theme
 	}
 
 	tmpl := template.New("").Funcs(funcMap)
 	templatesToParse := []string{}
+	storyTemplateFiles := []string{}
 
 	for _, baseDir := range templateBaseDirs {
-		err := filepath.Walk(baseDir, func(path string, info os.FileInfo, err error) error {
+		err := fs.WalkDir(fsys, baseDir, func(path string, d fs.DirEntry, err error) error {
 			if err != nil {
 				return err
 			}
-			if !info.IsDir() && (strings.HasSuffix(info.Name(), ".gohtml") || strings.HasSuffix(info.Name(), ".html")) {
+			if d.IsDir() {
+				if d.Name() == "__snapshots__" {
+					return fs.SkipDir // Rendered HTML written by the snapshot command, not templates
+				}
+				return nil
+			}
+			if strings.HasSuffix(d.Name(), storiesTemplateSuffix) {
+				// Story templates are namespaced per component below, so they never collide.
+				storyTemplateFiles = append(storyTemplateFiles, path)
+				log.Printf("Found story template for parsing: %s", path)
+			} else if strings.HasSuffix(d.Name(), ".gohtml") || strings.HasSuffix(d.Name(), ".html") {
 				templatesToParse = append(templatesToParse, path)
 				log.Printf("Found template for parsing: %s", path) // More verbose logging
 			}
 			return nil
 		})
+		if errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Template directory %s not found, skipping", baseDir)
+			continue
+		}
 		if err != nil {
 			log.Printf("Error walking template directory %s: %v", baseDir, err)
 			// Decide if we should return error or continue with other dirs
@@ -74,23 +107,174 @@ func LoadTemplates(templateBaseDirs []string) (*template.Template, error) {
 		}
 	}
 
-	if len(templatesToParse) == 0 {
+	if len(templatesToParse) == 0 && len(storyTemplateFiles) == 0 {
 		log.Println("No .html or .gohtml templates found in any specified directories.")
 		return tmpl, nil // Return an empty (but valid) template set
 	}
 
+	// Shared templates (sandbox chrome and component partials) live in one global namespace,
+	// so a name defined in two files would silently resolve to whichever was parsed last.
+	definedIn := make(map[string]string)
+	var duplicateErrs []error
+	for _, path := range templatesToParse {
+		names, err := definedTemplateNames(fsys, path, funcMap)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if previous, exists := definedIn[name]; exists {
+				duplicateErrs = append(duplicateErrs, fmt.Errorf("template %q is defined in both %s and %s", name, previous, path))
+				continue
+			}
+			definedIn[name] = path
+		}
+	}
+
 	// Parse all collected template files.
-	tmpl, err := tmpl.ParseFiles(templatesToParse...)
-	if err != nil {
-		return nil, err
+	for _, path := range templatesToParse {
+		if _, err := parseFile(tmpl, fsys, path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, path := range storyTemplateFiles {
+		componentName := strings.TrimSuffix(pathpkg.Base(path), storiesTemplateSuffix)
+		if err := addStoryTemplates(tmpl, fsys, funcMap, componentName, path, definedIn, &duplicateErrs); err != nil {
+			return nil, err
+		}
 	}
 
-	log.Printf("Successfully parsed %d template files from all specified directories.", len(templatesToParse))
+	if len(duplicateErrs) > 0 {
+		for _, dupErr := range duplicateErrs {
+			log.Printf("Duplicate template definition: %v", dupErr)
+		}
+		return nil, fmt.Errorf("duplicate template definitions: %w", errors.Join(duplicateErrs...))
+	}
+
+	log.Printf("Successfully parsed %d template files and %d story template files from all specified directories.", len(templatesToParse), len(storyTemplateFiles))
 	// for _, t := range tmpl.Templates() { log.Printf(" - Defined template: %s", t.Name()) } // Debug: list all defined templates
 
 	return tmpl, nil
 }
 
+// storiesTemplateSuffix identifies a component's SSR story templates, e.g. button.stories.gohtml.
+const storiesTemplateSuffix = ".stories.gohtml"
+
+// parseFile parses one template file of fsys into tmpl, under the file's base name like
+// template.ParseFiles does. (ParseFS would treat the path as a glob pattern.)
+func parseFile(tmpl *template.Template, fsys fs.FS, path string) (*template.Template, error) {
+	content, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := tmpl.New(pathpkg.Base(path)).Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return parsed, nil
+}
+
+// definedTemplateNames parses a single template file on its own and returns the names it defines.
+func definedTemplateNames(fsys fs.FS, path string, funcMap template.FuncMap) ([]string, error) {
+	fileSet := template.New("").Funcs(funcMap)
+	if _, err := parseFile(fileSet, fsys, path); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, t := range fileSet.Templates() {
+		if t.Name() == pathpkg.Base(path) || t.Tree == nil {
+			continue // The file's own (usually empty) root template
+		}
+		names = append(names, t.Name())
+	}
+	return names, nil
+}
+
+// addStoryTemplates parses a component's .stories.gohtml file and adds every template it
+// defines to tmpl under the component-scoped name from models.SSRTemplateName
+// (e.g. {{define "Default"}} in button.stories.gohtml becomes "button/Default").
+// References between templates of the same file are rewritten to the scoped names, while
+// references to shared partials such as "button" resolve in the global set as before.
+func addStoryTemplates(tmpl *template.Template, fsys fs.FS, funcMap template.FuncMap, componentName, path string, definedIn map[string]string, duplicateErrs *[]error) error {
+	fileSet := template.New("").Funcs(funcMap)
+	if _, err := parseFile(fileSet, fsys, path); err != nil {
+		return err
+	}
+
+	local := make(map[string]string)
+	for _, t := range fileSet.Templates() {
+		if t.Name() == pathpkg.Base(path) || t.Tree == nil {
+			continue
+		}
+		local[t.Name()] = models.SSRTemplateName(componentName, t.Name())
+	}
+
+	for name, scopedName := range local {
+		if previous, exists := definedIn[scopedName]; exists {
+			*duplicateErrs = append(*duplicateErrs, fmt.Errorf("story template %q is defined in both %s and %s", scopedName, previous, path))
+			continue
+		}
+		definedIn[scopedName] = path
+
+		tree := fileSet.Lookup(name).Tree.Copy()
+		tree.Name = scopedName
+		rewriteTemplateRefs(tree.Root, local)
+		if _, err := tmpl.AddParseTree(scopedName, tree); err != nil {
+			return fmt.Errorf("adding story template %q from %s: %w", scopedName, path, err)
+		}
+	}
+	return nil
+}
+
+// rewriteTemplateRefs renames {{template "name"}} calls in a parse tree according to names.
+func rewriteTemplateRefs(node parse.Node, names map[string]string) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			rewriteTemplateRefs(child, names)
+		}
+	case *parse.TemplateNode:
+		if scopedName, ok := names[n.Name]; ok {
+			n.Name = scopedName
+		}
+	case *parse.IfNode:
+		rewriteTemplateRefs(n.List, names)
+		rewriteTemplateRefs(n.ElseList, names)
+	case *parse.RangeNode:
+		rewriteTemplateRefs(n.List, names)
+		rewriteTemplateRefs(n.ElseList, names)
+	case *parse.WithNode:
+		rewriteTemplateRefs(n.List, names)
+		rewriteTemplateRefs(n.ElseList, names)
+	}
+}
+
+// RenderStory executes a story's SSR template (see models.SSRTemplateName) with args and
+// returns the markup. Only template.HTML args are rendered as markup: html`...` args from the
+// story file, and HTML args from a URL once args.Decode has sanitized them. Every string is
+// escaped. "Theme" is set for templates that style by theme. args is not modified.
+// The story frame and the snapshot command both render stories through here.
+func RenderStory(tmpl *template.Template, templateName string, args map[string]interface{}, theme string) (template.HTML, error) {
+	storyTemplate := tmpl.Lookup(templateName)
+	if storyTemplate == nil {
+		return "", fmt.Errorf("story template %q not found", templateName)
+	}
+	data := make(map[string]interface{}, len(args)+1)
+	for name, value := range args {
+		data[name] = value
+	}
+	data["Theme"] = theme
+
+	var output bytes.Buffer
+	if err := storyTemplate.Execute(&output, data); err != nil {
+		return "", err
+	}
+	return template.HTML(output.String()), nil
+}
+
 // Execute renders the specified template with the given data to the http.ResponseWriter.
 func Execute(w http.ResponseWriter, tmpl *template.Template, name string, data interface{}) (executeErr error) {
 	defer func() {
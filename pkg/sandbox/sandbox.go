@@ -0,0 +1,338 @@
+// Package sandbox is the component sandbox as a library: an http.Handler that discovers a
+// project's stories and serves the sandbox UI, the story frames and the static files, so it
+// can run inside another Go application next to the real backend:
+//
+//	sb, err := sandbox.New(sandbox.Options{Config: config.Default(), BasePath: "/_design"})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	go sb.Watch(ctx) // Optional: live reload while developing
+//	mux.Handle("/_design/", sb)
+//
+// cmd/sandbox is this package behind a command line.
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	machineui "kormsen.com/machine-ui"
+	"kormsen.com/machine-ui/pkg/sandbox/api"
+	"kormsen.com/machine-ui/pkg/sandbox/args"
+	"kormsen.com/machine-ui/pkg/sandbox/config"
+	"kormsen.com/machine-ui/pkg/sandbox/discovery"
+	"kormsen.com/machine-ui/pkg/sandbox/export"
+	"kormsen.com/machine-ui/pkg/sandbox/importmap"
+	"kormsen.com/machine-ui/pkg/sandbox/layerfs"
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+	"kormsen.com/machine-ui/pkg/sandbox/renderer"
+	"kormsen.com/machine-ui/pkg/sandbox/snapshot"
+	"kormsen.com/machine-ui/pkg/sandbox/themes"
+	"kormsen.com/machine-ui/pkg/sandbox/watcher"
+)
+
+const (
+	uiTemplateDir = "cmd/sandbox/templates" // The sandbox UI's templates, relative to the project; embedded in the binary
+	watchInterval = 500 * time.Millisecond  // How often Watch polls for changed files
+)
+
+// Options configures a sandbox.
+type Options struct {
+	// Config is the project layout, the defaults and the features, usually config.Default()
+	// or the result of config.Flags.Load. Listen is not used: the caller serves the handler.
+	Config config.Config
+
+	// BasePath is the path the handler is mounted under, e.g. "/_design"; empty (or "/") for
+	// the root. Requests must arrive with the path unchanged, the sandbox strips it itself,
+	// and every link, frame, static file and import map entry it generates carries it.
+	BasePath string
+
+	// RenderModes are added to the built-in CSR, SSR and hydrate modes, or replace the one of
+	// the same name; see api.RenderMode. They are offered in the toolbar and exported.
+	RenderModes []api.RenderMode
+}
+
+// Sandbox serves one project. It implements http.Handler.
+//
+// The project directory (Config.Root) is layered over the files embedded in the module
+// (see package machineui): the sandbox UI, its modules and a default import map come from
+// the binary unless the project has its own copy at the same path, and the components always
+// come from the project. The static directory of that stack is served under /static/.
+type Sandbox struct {
+	config    config.Config
+	basePath  string
+	modes     []api.RenderMode
+	projectFS fs.FS
+	staticFS  fs.FS
+	handlers  *api.AppHandlers
+	router    http.Handler
+}
+
+// New loads the project: discovers stories, parses templates and loads the import map.
+// Problems with stories and the import map are reported as diagnostics in the UI; an invalid
+// configuration or templates that fail to parse are returned as an error.
+func New(options Options) (*Sandbox, error) {
+	if err := options.Config.Validate(); err != nil {
+		return nil, err
+	}
+	basePath := strings.TrimSuffix(options.BasePath, "/")
+	if basePath != "" && !strings.HasPrefix(basePath, "/") {
+		basePath = "/" + basePath
+	}
+
+	projectFS := layerfs.New(os.DirFS(options.Config.Root), machineui.Files())
+	staticFS, err := fs.Sub(projectFS, path.Clean(options.Config.StaticDir))
+	if err != nil {
+		return nil, err
+	}
+	s := &Sandbox{config: options.Config, basePath: basePath, modes: options.RenderModes, projectFS: projectFS, staticFS: staticFS}
+
+	content, err := s.load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load templates: %w", err)
+	}
+	s.handlers = s.newHandlers(content, basePath)
+	if !s.config.Features.LiveReload {
+		s.handlers.Events = nil
+	}
+
+	router := api.NewRouter(staticFS, s.handlers)
+	if basePath == "" {
+		s.router = router
+	} else {
+		s.router = http.StripPrefix(basePath, router)
+	}
+	return s, nil
+}
+
+// ServeHTTP serves the sandbox. Requests outside BasePath are answered with 404 Not Found.
+func (s *Sandbox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.basePath != "" && r.URL.Path == s.basePath {
+		// The start page is BasePath + "/", as for any mounted subtree
+		http.Redirect(w, r, s.basePath+"/", http.StatusMovedPermanently)
+		return
+	}
+	s.router.ServeHTTP(w, r)
+}
+
+// Watch reloads the project whenever a story, template, the import map or a theme stylesheet
+// changes on disk, and tells connected browsers to re-render. The sandbox keeps serving the
+// previous content until the new one is ready. Watch blocks until ctx is cancelled; it does nothing if live
+// reload is turned off in the configuration. Only the project's files on disk can change;
+// the embedded ones are fixed.
+func (s *Sandbox) Watch(ctx context.Context) {
+	if !s.config.Features.LiveReload {
+		return
+	}
+
+	watcher.New(s.watchDirs(), watchInterval).Run(ctx, func(changed []string) {
+		log.Printf("Files changed, reloading: %v", changed)
+		content, err := s.load()
+		if err != nil {
+			// Keep the last good templates so the sandbox stays usable while the file is being fixed
+			log.Printf("Reload: Failed to load templates, keeping the previous ones: %v", err)
+			content.Diagnostics = append(content.Diagnostics, models.Diagnostic{
+				Severity: models.SeverityError,
+				Message:  "templates not reloaded: " + err.Error(),
+			})
+		}
+		s.handlers.Update(content, s.reloadScope(changed), changed)
+	})
+}
+
+// watchDirs returns the files and directory trees Watch polls: the component and template
+// directories, the import map and, unless themes are configured, the stylesheets they are
+// discovered from.
+func (s *Sandbox) watchDirs() []string {
+	var dirs []string
+	for _, dir := range s.config.ComponentDirs {
+		dirs = append(dirs, filepath.Join(s.config.Root, s.config.StaticDir, dir))
+	}
+	for _, dir := range s.config.TemplateDirs {
+		dirs = append(dirs, filepath.Join(s.config.Root, dir))
+	}
+	dirs = append(dirs, filepath.Join(s.config.Root, s.config.ImportMap))
+	if len(s.config.Themes) == 0 {
+		dirs = append(dirs, filepath.Join(s.config.Root, s.config.StaticDir, themes.Dir))
+	}
+	return dirs
+}
+
+// Export writes every story in every render mode and theme to outDir as a static site, see
+// package export. The site does not depend on BasePath: its links are relative.
+func (s *Sandbox) Export(outDir string) (export.Summary, error) {
+	content := s.handlers.Content()
+	if n := content.Diagnostics.Count(models.SeverityError); n > 0 {
+		log.Printf("Warning: Exporting with %d discovery errors, see %s/sandbox/__diagnostics.html", n, outDir)
+	}
+
+	// The same handlers as the server, at the root and without the live reload stream
+	handlers := s.newHandlers(content, "")
+	handlers.Events = nil
+	return export.Site(api.NewRouter(s.staticFS, handlers), content, export.Options{
+		Static:            s.staticFS,
+		OutDir:            outDir,
+		DefaultTheme:      s.config.DefaultTheme,
+		DefaultRenderMode: s.config.DefaultRenderMode,
+		RenderModes:       handlers.RenderModes,
+	})
+}
+
+// Snapshot renders every SSR story with its args and named arg sets and compares the HTML
+// with the project's __snapshots__ files, or rewrites them with update; see package snapshot.
+// Failures and their diffs are written to output. Stories render in the default theme.
+func (s *Sandbox) Snapshot(update bool, output io.Writer) (snapshot.Summary, error) {
+	content := s.handlers.Content()
+	return snapshot.Run(content.Components, content.Templates, snapshot.Options{
+		StaticDir: filepath.Join(s.config.Root, s.config.StaticDir),
+		Theme:     s.config.DefaultTheme,
+		Update:    update,
+		Output:    output,
+	})
+}
+
+// Parity renders every story that has an SSR template both with the template and with its
+// Preact component, and reports where the markup differs; see package parity. A non-empty
+// componentName limits the check to that component.
+func (s *Sandbox) Parity(componentName string) []models.ParityReport {
+	return s.handlers.Parity(componentName)
+}
+
+// newHandlers returns the request handlers for content with the configured defaults.
+func (s *Sandbox) newHandlers(content api.Content, basePath string) *api.AppHandlers {
+	handlers := api.NewAppHandlers(content)
+	handlers.DefaultTheme = s.config.DefaultTheme
+	handlers.DefaultRenderMode = s.config.DefaultRenderMode
+	handlers.Viewports = s.config.Viewports
+	handlers.HideDiagnostics = !s.config.Features.Diagnostics
+	handlers.BasePath = basePath
+	handlers.Static = s.staticFS
+	handlers.Args = args.Decoder{
+		Strict:    s.config.Features.StrictArgs,
+		Sanitizer: args.NewSanitizer(s.config.HTMLArgs.Tags, s.config.HTMLArgs.Attributes),
+	}
+	if s.config.CSP.Enabled {
+		handlers.CSP = &api.CSP{Policy: s.config.CSP.Policy, ReportOnly: s.config.CSP.ReportOnly}
+	}
+	for _, mode := range s.modes {
+		handlers.RenderModes.Register(mode)
+	}
+	return handlers
+}
+
+// load discovers stories, parses templates and loads the import map. Discovery and
+// import map problems are reported as diagnostics; only a template set that fails to parse
+// is returned as an error, together with everything else that did load.
+func (s *Sandbox) load() (api.Content, error) {
+	content, err := s.loadContent()
+	if !s.config.Features.Diagnostics {
+		content = withoutDiagnostics(content)
+	}
+	return content, err
+}
+
+func (s *Sandbox) loadContent() (api.Content, error) {
+	// Discover stories
+	discoveredComponents, diagnostics, err := discovery.DiscoverStories(s.staticFS, s.config.ComponentDirs)
+	if err != nil {
+		log.Printf("Warning: Error discovering stories from %v: %v", s.config.ComponentDirs, err)
+	}
+	if len(discoveredComponents) == 0 {
+		log.Println("No component stories were found. The sidebar will be empty.")
+	}
+
+	// One import map for the sandbox page and the story frames; its targets and the bare
+	// imports of every story file are checked up front.
+	importMap, err := importmap.Load(s.projectFS, s.config.ImportMap)
+	if err != nil {
+		log.Printf("Warning: Could not load import map: %v", err)
+		diagnostics = append(diagnostics, models.Diagnostic{
+			Severity: models.SeverityError,
+			File:     s.config.ImportMap,
+			Message:  "import map not loaded, bare imports will fail in the browser: " + err.Error(),
+		})
+	}
+	diagnostics = append(diagnostics, importMap.Validate(s.staticFS, "/static/")...)
+	diagnostics = append(diagnostics, discovery.CheckImports(discoveredComponents, importMap, s.config.ImportMap)...)
+
+	// Themes are configured, or else the *-theme classes of the project's stylesheets
+	themeNames := s.config.Themes
+	if len(themeNames) == 0 {
+		discovered, err := themes.Discover(s.staticFS, themes.Dir)
+		if err != nil {
+			log.Printf("Warning: Error discovering themes in %s: %v", path.Join(s.config.StaticDir, themes.Dir), err)
+		}
+		if len(discovered) == 0 {
+			log.Printf("No *-theme classes found in %s; offering the themes %s.", path.Join(s.config.StaticDir, themes.Dir), strings.Join(themes.Builtin, ", "))
+		}
+		themeNames = discovered
+	}
+	if len(themeNames) > 0 && !slices.Contains(themeNames, s.config.DefaultTheme) {
+		diagnostics = append(diagnostics, models.Diagnostic{
+			Severity: models.SeverityWarning,
+			File:     path.Join(s.config.StaticDir, themes.Dir),
+			Message:  fmt.Sprintf("the default theme %q is not one of the themes %s", s.config.DefaultTheme, strings.Join(themeNames, ", ")),
+		})
+	}
+
+	content := api.Content{Components: discoveredComponents, Diagnostics: diagnostics, ImportMap: importMap, Themes: themeNames}
+	templateSet, err := renderer.LoadTemplates(s.projectFS, s.config.TemplateDirs)
+	if err != nil {
+		return content, err
+	}
+	content.Templates = templateSet
+
+	// Stories marked SSR capable can only be checked against the templates once they are loaded
+	content.Diagnostics = append(content.Diagnostics, discovery.CheckSSRTemplates(discoveredComponents, templateSet)...)
+	if len(content.Diagnostics) > 0 {
+		log.Printf("Discovery reported %d diagnostics (%d warnings or errors), see %s/sandbox/__diagnostics", len(content.Diagnostics), content.Diagnostics.Count(models.SeverityWarning), s.basePath)
+	}
+	return content, nil
+}
+
+// withoutDiagnostics drops the diagnostics from content, so the sidebar shows no badges and
+// no link to the report. They have been logged by then.
+func withoutDiagnostics(content api.Content) api.Content {
+	content.Diagnostics = nil
+	content.Components = slices.Clone(content.Components)
+	for i := range content.Components {
+		component := &content.Components[i]
+		component.Diagnostics = nil
+		component.Variants = slices.Clone(component.Variants)
+		for j := range component.Variants {
+			component.Variants[j].Diagnostics = nil
+		}
+	}
+	return content
+}
+
+// reloadScope decides how much the browser has to re-render for a set of changed files.
+// Story files, sandbox templates, the import map and the theme stylesheets change the sidebar,
+// toolbar, args editor or page head, so the whole page reloads; anything else (component JS, CSS, component
+// templates) only affects the story frame.
+func (s *Sandbox) reloadScope(changed []string) string {
+	for _, changedPath := range changed {
+		rel, err := filepath.Rel(s.config.Root, changedPath)
+		if err != nil {
+			return api.ReloadScopePage
+		}
+		rel = filepath.ToSlash(rel)
+		if strings.Contains(path.Base(rel), ".stories.") || strings.HasPrefix(rel, uiTemplateDir+"/") || rel == path.Clean(s.config.ImportMap) {
+			return api.ReloadScopePage
+		}
+		if len(s.config.Themes) == 0 && strings.HasPrefix(rel, path.Join(s.config.StaticDir, themes.Dir)+"/") {
+			return api.ReloadScopePage // May add or remove a theme of the toolbar
+		}
+	}
+	return api.ReloadScopeFrame
+}
@@ -0,0 +1,53 @@
+package sandbox
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"kormsen.com/machine-ui/pkg/sandbox/api"
+	"kormsen.com/machine-ui/pkg/sandbox/config"
+)
+
+func TestReload(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string   // Relative to the project
+		themes    []string // Config.Themes
+		templates []string // Config.TemplateDirs
+		watched   bool
+		scope     string
+	}{
+		{name: "story file", file: "static/components/button/button.stories.js", watched: true, scope: api.ReloadScopePage},
+		{name: "component module", file: "static/components/button/button.js", watched: true, scope: api.ReloadScopeFrame},
+		{name: "sandbox template", file: "cmd/sandbox/templates/_toolbar_content.gohtml", watched: true, scope: api.ReloadScopePage},
+		{name: "import map", file: "importmap.json", watched: true, scope: api.ReloadScopePage},
+		{name: "theme stylesheet", file: "static/styles/themes.css", templates: []string{"cmd/sandbox/templates"}, watched: true, scope: api.ReloadScopePage},
+		{name: "nested theme stylesheet", file: "static/styles/brand/sepia.css", templates: []string{"cmd/sandbox/templates"}, watched: true, scope: api.ReloadScopePage},
+		{name: "stylesheet with configured themes", file: "static/styles/themes.css", themes: []string{"light", "dark"}, templates: []string{"cmd/sandbox/templates"}, watched: false, scope: api.ReloadScopeFrame},
+		{name: "other static file", file: "static/lib/vendor.js", templates: []string{"cmd/sandbox/templates"}, watched: false, scope: api.ReloadScopeFrame},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Root = t.TempDir()
+			cfg.Themes = test.themes
+			if test.templates != nil {
+				cfg.TemplateDirs = test.templates
+			}
+			s := &Sandbox{config: cfg}
+			file := filepath.Join(cfg.Root, filepath.FromSlash(test.file))
+
+			watched := slices.ContainsFunc(s.watchDirs(), func(dir string) bool {
+				return file == dir || strings.HasPrefix(file, dir+string(filepath.Separator))
+			})
+			if watched != test.watched {
+				t.Errorf("%s watched = %v, want %v", test.file, watched, test.watched)
+			}
+			if scope := s.reloadScope([]string{file}); scope != test.scope {
+				t.Errorf("reloadScope(%s) = %q, want %q", test.file, scope, test.scope)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package snapshot
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// Diff returns a unified line diff from the snapshot (want) to the rendered HTML (got):
+// removed lines start with "-", added lines with "+", and each hunk is preceded by its line
+// ranges, as in `diff -u`. Snapshots are small, so a plain longest common subsequence table
+// is fast enough.
+func Diff(want, got string) string {
+	a := strings.Split(strings.TrimSuffix(want, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	// The edit script: one entry per line of either side
+	type edit struct {
+		op   byte // ' ', '-' or '+'
+		text string
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j]})
+			j++
+		}
+	}
+
+	// Group the changes into hunks with diffContext lines around them
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- snapshot\n+++ rendered\n")
+	for start := 0; start < len(edits); {
+		if edits[start].op == ' ' {
+			start++
+			continue
+		}
+		from := max(start-diffContext, 0)
+		to := start
+		for unchanged := 0; to < len(edits) && unchanged <= 2*diffContext; to++ {
+			if edits[to].op == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		// to is past the last change plus up to 2*diffContext+1 unchanged lines; keep diffContext
+		for to > start && edits[to-1].op == ' ' {
+			to--
+		}
+		to = min(to+diffContext, len(edits))
+
+		// Line numbers of the hunk on both sides (1-based)
+		wantLine, gotLine := 1, 1
+		for _, e := range edits[:from] {
+			if e.op != '+' {
+				wantLine++
+			}
+			if e.op != '-' {
+				gotLine++
+			}
+		}
+		wantCount, gotCount := 0, 0
+		for _, e := range edits[from:to] {
+			if e.op != '+' {
+				wantCount++
+			}
+			if e.op != '-' {
+				gotCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", wantLine, wantCount, gotLine, gotCount)
+		for _, e := range edits[from:to] {
+			out.WriteByte(e.op)
+			out.WriteString(e.text)
+			out.WriteByte('\n')
+		}
+		start = to
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
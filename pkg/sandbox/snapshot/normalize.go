@@ -0,0 +1,107 @@
+package snapshot
+
+import (
+	"regexp"
+	"strings"
+)
+
+// voidElements have no closing tag, so they do not indent what follows them.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// rawTextElements keep their content as written: whitespace in it is significant, and it
+// may contain "<" that is not a tag.
+var rawTextElements = map[string]bool{"script": true, "style": true, "pre": true, "textarea": true}
+
+var (
+	whitespace = regexp.MustCompile(`\s+`)
+	tagName    = regexp.MustCompile(`^</?([A-Za-z][A-Za-z0-9-]*)`)
+)
+
+// Normalize formats rendered HTML so that snapshots only change when the markup does:
+// every tag and every run of text goes on its own line, indented by nesting depth, with
+// whitespace inside tags and text collapsed to single spaces. HTML comments are dropped.
+// Template whitespace ({{- -}}, indentation of the .gohtml file) therefore never shows up
+// in a diff. The result ends with a newline.
+func Normalize(html string) string {
+	var b strings.Builder
+	depth := 0
+	line := func(text string) {
+		b.WriteString(strings.Repeat("  ", depth))
+		b.WriteString(text)
+		b.WriteByte('\n')
+	}
+	text := func(raw string) {
+		if collapsed := strings.TrimSpace(whitespace.ReplaceAllString(raw, " ")); collapsed != "" {
+			line(collapsed)
+		}
+	}
+
+	for len(html) > 0 {
+		start := strings.IndexByte(html, '<')
+		if start < 0 {
+			text(html)
+			break
+		}
+		text(html[:start])
+		html = html[start:]
+
+		if strings.HasPrefix(html, "<!--") {
+			end := strings.Index(html, "-->")
+			if end < 0 {
+				break
+			}
+			html = html[end+len("-->"):]
+			continue
+		}
+
+		end := strings.IndexByte(html, '>')
+		if end >= 0 && strings.HasPrefix(html, "<!") {
+			// <!DOCTYPE html>
+			line(whitespace.ReplaceAllString(html[:end+1], " "))
+			html = html[end+1:]
+			continue
+		}
+		name := tagName.FindStringSubmatch(html)
+		if end < 0 || name == nil {
+			// A lone "<" in text, e.g. "a < b"
+			text(html[:1])
+			html = html[1:]
+			continue
+		}
+		tag := whitespace.ReplaceAllString(html[:end+1], " ")
+		if trimmed, ok := strings.CutSuffix(tag, " />"); ok {
+			tag = trimmed + "/>"
+		} else if trimmed, ok := strings.CutSuffix(tag, " >"); ok {
+			tag = trimmed + ">"
+		}
+		html = html[end+1:]
+		element := strings.ToLower(name[1])
+
+		switch {
+		case strings.HasPrefix(tag, "</"):
+			depth = max(depth-1, 0)
+			line(tag)
+		case voidElements[element] || strings.HasSuffix(tag, "/>"):
+			line(tag)
+		case rawTextElements[element]:
+			line(tag)
+			closing := strings.Index(strings.ToLower(html), "</"+element)
+			if closing < 0 {
+				closing = len(html)
+			}
+			if content := strings.Trim(html[:closing], "\n"); strings.TrimSpace(content) != "" {
+				b.WriteString(content)
+				b.WriteByte('\n')
+			}
+			html = html[closing:]
+			depth++
+		default:
+			line(tag)
+			depth++
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,212 @@
+// Package snapshot pins down the SSR output of stories. Every story that can be rendered on
+// the server is rendered with its args, and once more per named arg set from the story's
+// `parameters.snapshots`; the normalized HTML (see Normalize) is compared with the file kept
+// in a __snapshots__ directory next to the story file:
+//
+//	static/components/button/button.stories.js
+//	static/components/button/__snapshots__/button.Default.snap.html
+//	static/components/button/__snapshots__/button.Default.Disabled.snap.html
+//
+// A story opts out by removing Storybook's "test" tag (`tags: ["!test"]`). Differences are
+// reported as a line diff; with Options.Update the files are rewritten instead.
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"kormsen.com/machine-ui/pkg/sandbox/models"
+	"kormsen.com/machine-ui/pkg/sandbox/renderer"
+)
+
+const (
+	DirName    = "__snapshots__" // Directory next to a story file that holds its snapshots
+	fileSuffix = ".snap.html"
+	testTag    = "test" // Storybook's tag for stories that test runners pick up
+)
+
+// Options configures a snapshot run.
+type Options struct {
+	StaticDir string    // The static directory on disk; component paths are relative to it
+	Theme     string    // Theme the stories are rendered in, passed to templates as .Theme
+	Update    bool      // Write missing and changed snapshots and delete obsolete ones instead of failing
+	Output    io.Writer // Receives a line per failure, with its diff
+}
+
+// Summary counts the outcome of a snapshot run.
+type Summary struct {
+	Passed   int // Rendered HTML matches the snapshot
+	Failed   int // Differs, has no snapshot yet, or failed to render
+	Written  int // Snapshots created or updated (Update only)
+	Obsolete int // Snapshot files no story renders any more; deleted with Update
+	Skipped  int // Stories without SSR or without the "test" tag
+}
+
+// snapshot is one rendering of a story: its default args or a named arg set.
+type snapshot struct {
+	component *models.ComponentGroup
+	variant   *models.StoryVariant
+	argSet    string // "" for the story's own args
+	file      string // Path of the snapshot file on disk
+}
+
+func (s snapshot) String() string {
+	name := s.component.Name + "/" + s.variant.Key
+	if s.argSet != "" {
+		name += " [" + s.argSet + "]"
+	}
+	return name
+}
+
+// Run renders the stories of components with templates and checks or updates their snapshots.
+// Mismatches are counted in the summary, not returned: the error is only for files that could
+// not be read or written.
+func Run(components []models.ComponentGroup, templates *template.Template, options Options) (Summary, error) {
+	var summary Summary
+	if options.Output == nil {
+		options.Output = io.Discard
+	}
+
+	// Snapshot files rendered in this run, by directory; story files in the same directory share one
+	dirs := make(map[string]map[string]bool)
+	for i := range components {
+		component := &components[i]
+		dir := filepath.Join(options.StaticDir, filepath.FromSlash(path.Dir(component.Path)), DirName)
+		if dirs[dir] == nil {
+			dirs[dir] = make(map[string]bool)
+		}
+
+		for j := range component.Variants {
+			variant := &component.Variants[j]
+			if !variant.HasSSR || templates == nil || templates.Lookup(variant.SSRTemplateName) == nil || !slices.Contains(variant.Tags, testTag) {
+				summary.Skipped++
+				continue
+			}
+			argSets := []string{""}
+			for name := range variant.SnapshotArgs {
+				argSets = append(argSets, name)
+			}
+			slices.Sort(argSets[1:])
+
+			for _, argSet := range argSets {
+				s := snapshot{component: component, variant: variant, argSet: argSet, file: filepath.Join(dir, fileName(component.Name, variant.Key, argSet))}
+				dirs[dir][s.file] = true
+				if err := check(s, templates, options, &summary); err != nil {
+					return summary, err
+				}
+			}
+		}
+	}
+
+	for _, dir := range slices.Sorted(maps.Keys(dirs)) {
+		if err := removeObsolete(dir, dirs[dir], options, &summary); err != nil {
+			return summary, err
+		}
+	}
+	return summary, nil
+}
+
+// check renders one snapshot and compares it with the file on disk.
+func check(s snapshot, templates *template.Template, options Options, summary *Summary) error {
+	args := make(map[string]interface{}, len(s.variant.Args))
+	for name, value := range s.variant.Args {
+		args[name] = value
+	}
+	for name, value := range s.variant.SnapshotArgs[s.argSet] {
+		args[name] = value
+	}
+	rendered, err := renderer.RenderStory(templates, s.variant.SSRTemplateName, args, options.Theme)
+	if err != nil {
+		// Never written: an error is not a snapshot worth keeping
+		fmt.Fprintf(options.Output, "FAIL %s: %v\n", s, err)
+		summary.Failed++
+		return nil
+	}
+	actual := Normalize(string(rendered))
+
+	existing, err := os.ReadFile(s.file)
+	switch {
+	case err == nil && string(existing) == actual:
+		summary.Passed++
+		return nil
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		return err
+	case options.Update:
+		if err := os.MkdirAll(filepath.Dir(s.file), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(s.file, []byte(actual), 0o644); err != nil {
+			return err
+		}
+		log.Printf("Snapshot: Wrote %s", s.file)
+		summary.Written++
+		return nil
+	case err != nil:
+		fmt.Fprintf(options.Output, "FAIL %s: no snapshot at %s, run with -update to write it\n", s, s.file)
+	default:
+		fmt.Fprintf(options.Output, "FAIL %s: rendered HTML differs from %s\n%s\n", s, s.file, Diff(string(existing), actual))
+	}
+	summary.Failed++
+	return nil
+}
+
+// removeObsolete reports (or, with Update, deletes) snapshot files in dir that no story rendered.
+func removeObsolete(dir string, expected map[string]bool, options Options, summary *Summary) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		file := filepath.Join(dir, entry.Name())
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), fileSuffix) || expected[file] {
+			continue
+		}
+		summary.Obsolete++
+		if !options.Update {
+			fmt.Fprintf(options.Output, "obsolete snapshot %s, run with -update to delete it\n", file)
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+		log.Printf("Snapshot: Deleted obsolete %s", file)
+	}
+	return nil
+}
+
+// fileName returns the snapshot file name of a story and arg set, e.g. "button.Default.Disabled.snap.html".
+func fileName(componentName, storyKey, argSet string) string {
+	name := componentName + "." + storyKey
+	if argSet != "" {
+		name += "." + argSet
+	}
+	return name + fileSuffix
+}
+
+func (s Summary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d passed, %d failed", s.Passed, s.Failed)
+	if s.Written > 0 {
+		fmt.Fprintf(&b, ", %d written", s.Written)
+	}
+	if s.Obsolete > 0 {
+		fmt.Fprintf(&b, ", %d obsolete", s.Obsolete)
+	}
+	if s.Skipped > 0 {
+		fmt.Fprintf(&b, ", %d stories skipped (no SSR or no %q tag)", s.Skipped, testTag)
+	}
+	return b.String()
+}
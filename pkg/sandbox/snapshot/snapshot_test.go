@@ -0,0 +1,66 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"kormsen.com/machine-ui/pkg/sandbox/discovery"
+	"kormsen.com/machine-ui/pkg/sandbox/renderer"
+)
+
+func TestRunRendersHTMLArgsAsMarkup(t *testing.T) {
+	staticFS := fstest.MapFS{
+		"components/icon-button/icon-button.stories.js": {Data: []byte(`import { html } from "lit";
+export default { title: "Icon Button" };
+export const Default = {
+  args: {
+    label: "<Save>",
+    icon: html` + "`<svg viewBox=\"0 0 16 16\"><path d=\"M2 8h12\"></path></svg>`" + `,
+    children: html` + "`<b>Now</b>`" + `,
+  },
+  parameters: { snapshots: { Outlined: { icon: html` + "`<i>outlined</i>`" + ` } } },
+};
+export const Declared = {
+  args: { label: "Save", icon: "<em>declared</em>" },
+  argTypes: { icon: { type: "html" } },
+};
+`)},
+		"components/icon-button/icon-button.gohtml":         {Data: []byte(`{{define "icon-button"}}<button>{{.icon}}{{.label}}{{.Children}}</button>{{end}}`)},
+		"components/icon-button/icon-button.stories.gohtml": {Data: []byte(`{{define "Default"}}{{template "icon-button" .}}{{end}}{{define "Declared"}}{{template "icon-button" .}}{{end}}`)},
+	}
+	components, _, err := discovery.DiscoverStories(staticFS, []string{"components"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates, err := renderer.LoadTemplates(staticFS, []string{"components"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	staticDir := t.TempDir()
+	if _, err := Run(components, templates, Options{StaticDir: staticDir, Update: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		file string
+		want string // Before Normalize
+	}{
+		{name: "html args", file: "icon-button.Default.snap.html", want: `<button><svg viewBox="0 0 16 16"><path d="M2 8h12"></path></svg>&lt;Save&gt;<b>Now</b></button>`},
+		{name: "html arg of an arg set", file: "icon-button.Default.Outlined.snap.html", want: `<button><i>outlined</i>&lt;Save&gt;<b>Now</b></button>`},
+		{name: "declared html arg", file: "icon-button.Declared.snap.html", want: `<button><em>declared</em>Save</button>`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := os.ReadFile(filepath.Join(staticDir, "components", "icon-button", DirName, test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := Normalize(test.want); string(got) != want {
+				t.Errorf("%s = %q, want %q", test.file, got, want)
+			}
+		})
+	}
+}
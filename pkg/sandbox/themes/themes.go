@@ -0,0 +1,93 @@
+// Package themes finds the themes the sandbox offers. A theme is a class on the page's and the
+// story frame's <body>, "<name>-theme", which the project's stylesheets style, e.g.
+//
+//	.high-contrast-theme { --sage-1: #000; }
+//
+// The sandbox takes its themes from the configuration or, if none are configured, from the
+// "*-theme" class selectors of the stylesheets in the static directory's styles/ (Dir).
+package themes
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Dir is the directory of the static directory whose stylesheets Discover reads.
+const Dir = "styles"
+
+// Builtin are the themes used when none are configured or discovered.
+var Builtin = []string{"light", "dark"}
+
+// classSelector matches a class selector; the class is the first group.
+var classSelector = regexp.MustCompile(`\.(-?[_a-zA-Z][-_a-zA-Z0-9]*)`)
+
+// comment matches a CSS comment.
+var comment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+
+// Discover returns the themes defined by the .css files under dir of fsys, sorted: the names of
+// the class selectors ending in "-theme". A missing dir defines none.
+func Discover(fsys fs.FS, dir string) ([]string, error) {
+	var names []string
+	err := fs.WalkDir(fsys, dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || path.Ext(file) != ".css" {
+			return nil
+		}
+		css, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		for _, name := range Parse(string(css)) {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	slices.Sort(names)
+	return names, err
+}
+
+// Parse returns the themes a stylesheet defines, in order of appearance. Only selectors are
+// read: a class name in a declaration, such as a url(), is not a theme.
+func Parse(css string) []string {
+	css = comment.ReplaceAllString(css, "")
+	var names []string
+	start := 0
+	for i, c := range css {
+		switch c {
+		case '{':
+			for _, match := range classSelector.FindAllStringSubmatch(css[start:i], -1) {
+				name, ok := strings.CutSuffix(match[1], "-theme")
+				if ok && name != "" && !slices.Contains(names, name) {
+					names = append(names, name)
+				}
+			}
+			start = i + 1
+		case '}', ';':
+			start = i + 1
+		}
+	}
+	return names
+}
+
+// Label returns the display name of a theme: "high-contrast" becomes "High contrast".
+func Label(name string) string {
+	label := strings.Join(strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == '_' }), " ")
+	if label == "" {
+		return name
+	}
+	first, size := utf8.DecodeRuneInString(label)
+	return string(unicode.ToUpper(first)) + label[size:]
+}
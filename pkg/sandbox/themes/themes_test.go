@@ -0,0 +1,86 @@
+package themes
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		css  string
+		want []string
+	}{
+		{name: "theme", css: ".high-contrast-theme { --sage-1: #000; }", want: []string{"high-contrast"}},
+		{name: "selector list", css: "body.dark-theme .card, .light-theme > p:hover { color: red }", want: []string{"dark", "light"}},
+		{name: "in order of appearance, once", css: ".sepia-theme {} .dark-theme {} .sepia-theme a {}", want: []string{"sepia", "dark"}},
+		{name: "nested in at-rule", css: "@media (prefers-contrast: more) { .high-contrast-theme { color: #000 } }", want: []string{"high-contrast"}},
+		{name: "comment", css: "/* .commented-theme { } */ .dark-theme {}", want: []string{"dark"}},
+		{name: "comment before brace", css: ".dark-theme /* .commented-theme */ {}", want: []string{"dark"}},
+		{name: "url in declaration", css: ".card { background: url(img/paper.sepia-theme.png) }", want: nil},
+		{name: "url before nested rule", css: ".card { background: url(paper.sepia-theme.png); .dark-theme & { color: #fff } }", want: []string{"dark"}},
+		{name: "import", css: "@import url(base.print-theme.css); .dark-theme {}", want: []string{"dark"}},
+		{name: "not a theme suffix", css: ".theme-toggle, .dark-themes, .dark-theme-toggle {}", want: nil},
+		{name: "bare suffix", css: ".-theme {}", want: nil},
+		{name: "no rule", css: ".dark-theme", want: nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Parse(test.css)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Parse(%q) = %q, want %q", test.css, got, test.want)
+			}
+		})
+	}
+}
+
+func TestLabel(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "dark", want: "Dark"},
+		{name: "high-contrast", want: "High contrast"},
+		{name: "high_contrast", want: "High contrast"},
+		{name: "-dim--blue-", want: "Dim blue"},
+		{name: "ébène", want: "Ébène"},
+		{name: "--", want: "--"},
+		{name: "", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Label(test.name); got != test.want {
+				t.Errorf("Label(%q) = %q, want %q", test.name, got, test.want)
+			}
+		})
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	fsys := fstest.MapFS{
+		"styles/themes.css":     {Data: []byte(".sepia-theme {} .dark-theme {}")},
+		"styles/more/print.css": {Data: []byte(".dark-theme {} .print-theme {}")},
+		"styles/notes.txt":      {Data: []byte(".ignored-theme {}")},
+		"components/button.css": {Data: []byte(".outside-theme {}")},
+	}
+	tests := []struct {
+		name string
+		dir  string
+		want []string
+	}{
+		{name: "sorted across files", dir: Dir, want: []string{"dark", "print", "sepia"}},
+		{name: "missing dir", dir: "missing", want: nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Discover(fsys, test.dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Discover(%q) = %q, want %q", test.dir, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package transpile
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheEntry is a transpiled module together with the file state it was built from.
+type cacheEntry struct {
+	modTime time.Time
+	size    int64
+	code    []byte
+}
+
+// Handler serves a static file system like http.FileServerFS, except that .ts, .tsx and .jsx
+// files are transpiled to JavaScript on request. Transpiled modules are cached in memory
+// and rebuilt when the file's modification time or size changes.
+//
+// Imports written the TypeScript way are resolved too: a request for "./button" or
+// "./button.js" that does not exist on disk is answered with button.ts, button.tsx or
+// button.jsx, whichever is found first.
+type Handler struct {
+	root  fs.FS
+	files http.Handler
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+// NewHandler returns a Handler serving the files of root.
+func NewHandler(root fs.FS) *Handler {
+	return &Handler{
+		root:  root,
+		files: http.FileServerFS(root),
+		cache: make(map[string]cacheEntry),
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	urlPath := path.Clean("/" + r.URL.Path)
+	sourcePath, ok := h.resolve(urlPath)
+	if !ok {
+		h.files.ServeHTTP(w, r)
+		return
+	}
+
+	info, err := fs.Stat(h.root, sourcePath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	code, err := h.transpile(sourcePath, info)
+	if err != nil {
+		log.Printf("Transpile: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(code))
+}
+
+// resolve maps a request path to a TypeScript or JSX file of the root file system, if it
+// names one directly or through the extension fallbacks described on Handler.
+func (h *Handler) resolve(urlPath string) (string, bool) {
+	return Resolve(h.root, urlPath)
+}
+
+// Resolve maps a path of root ("/components/chip/chip.js") to the TypeScript or JSX file
+// served for it, if it names one directly or through the extension fallbacks described on
+// Handler. It reports false for paths that are served as they are.
+func Resolve(root fs.FS, urlPath string) (string, bool) {
+	filePath := strings.TrimPrefix(urlPath, "/")
+	if filePath == "" {
+		return "", false
+	}
+	if IsSource(filePath) {
+		return filePath, true
+	}
+
+	ext := path.Ext(urlPath)
+	if ext != "" && ext != ".js" {
+		return "", false
+	}
+	if _, err := fs.Stat(root, filePath); !errors.Is(err, fs.ErrNotExist) {
+		return "", false // The file exists (or cannot be checked): serve it as is
+	}
+	base := strings.TrimSuffix(filePath, ext)
+	for _, sourceExt := range SourceExtensions {
+		if _, err := fs.Stat(root, base+sourceExt); err == nil {
+			return base + sourceExt, true
+		}
+	}
+	return "", false
+}
+
+// transpile returns the cached module for filePath or builds it.
+func (h *Handler) transpile(filePath string, info fs.FileInfo) ([]byte, error) {
+	h.mu.Lock()
+	entry, ok := h.cache[filePath]
+	h.mu.Unlock()
+	if ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry.code, nil
+	}
+
+	source, err := fs.ReadFile(h.root, filePath)
+	if err != nil {
+		return nil, err
+	}
+	// The source map names the file relative to the module's own URL, so devtools list the
+	// original next to the transpiled module
+	code, err := Module(path.Base(filePath), source)
+	if err != nil {
+		var transpileErr *Error
+		if errors.As(err, &transpileErr) {
+			transpileErr.Path = filePath
+		}
+		return nil, err
+	}
+
+	h.mu.Lock()
+	h.cache[filePath] = cacheEntry{modTime: info.ModTime(), size: info.Size(), code: code}
+	h.mu.Unlock()
+	log.Printf("Transpile: built %s (%d bytes)", filePath, len(code))
+	return code, nil
+}
@@ -0,0 +1,86 @@
+// Package transpile turns TypeScript and JSX modules into browser-ready ES modules with esbuild.
+// Plain .js files are never touched, so the no-build workflow keeps working as before;
+// .ts, .tsx and .jsx files are transpiled file by file (no bundling) when they are requested.
+package transpile
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// JSXImportSource is the package JSX compiles against. esbuild's automatic runtime imports
+// "preact/jsx-runtime", which the import maps point at static/modules/preact-jsx-runtime.js.
+const JSXImportSource = "preact"
+
+// SourceExtensions are the file extensions that are transpiled before they reach the browser.
+var SourceExtensions = []string{".ts", ".tsx", ".jsx"}
+
+// IsSource reports whether path needs transpiling.
+func IsSource(path string) bool {
+	return loaderFor(path) != api.LoaderNone
+}
+
+func loaderFor(path string) api.Loader {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ts", ".mts":
+		return api.LoaderTS
+	case ".tsx":
+		return api.LoaderTSX
+	case ".jsx":
+		return api.LoaderJSX
+	}
+	return api.LoaderNone
+}
+
+// Error describes the first problem esbuild reported for a file.
+type Error struct {
+	Path    string
+	Line    int // 1-based, 0 if unknown
+	Column  int // 1-based, 0 if unknown
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.Path, e.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.Path, e.Line, e.Column, e.Message)
+}
+
+// Module transpiles a single TypeScript or JSX module to an ES module. Types are stripped,
+// JSX is compiled for Preact and imports are left as they are, so bare specifiers still
+// resolve through the page's import map. The output carries an inline source map pointing
+// back at path, so browser devtools and the story parser report original line numbers.
+func Module(path string, source []byte) ([]byte, error) {
+	loader := loaderFor(path)
+	if loader == api.LoaderNone {
+		return nil, &Error{Path: path, Message: "not a TypeScript or JSX file"}
+	}
+
+	result := api.Transform(string(source), api.TransformOptions{
+		Loader:          loader,
+		Format:          api.FormatESModule,
+		Target:          api.ES2020,
+		Sourcefile:      path,
+		Sourcemap:       api.SourceMapInline,
+		JSX:             api.JSXAutomatic,
+		JSXImportSource: JSXImportSource,
+		LogLevel:        api.LogLevelSilent,
+	})
+	if len(result.Errors) > 0 {
+		first := result.Errors[0]
+		err := &Error{Path: path, Message: first.Text}
+		if first.Location != nil {
+			err.Line = first.Location.Line
+			err.Column = first.Location.Column + 1 // esbuild columns are 0-based
+		}
+		if len(result.Errors) > 1 {
+			err.Message = fmt.Sprintf("%s (and %d more errors)", err.Message, len(result.Errors)-1)
+		}
+		return nil, err
+	}
+	return result.Code, nil
+}
@@ -0,0 +1,102 @@
+// Package viewport sizes the story frame, so stories can be checked at their responsive
+// breakpoints. The manager page's viewport query parameter names a preset from the
+// configuration, e.g. "mobile", or gives a custom size, e.g. "390x844"; without it the frame
+// fills the space next to the sidebar.
+package viewport
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Param is the query parameter of the manager page that selects the viewport.
+const Param = "viewport"
+
+// MinSize and MaxSize bound the width and height of a viewport, in CSS pixels.
+const (
+	MinSize = 100
+	MaxSize = 4096
+)
+
+// Viewport is a size the story frame is shown at.
+type Viewport struct {
+	Name   string `json:"name"`   // The query value, e.g. "mobile"; "390x844" for a custom size
+	Width  int    `json:"width"`  // CSS pixels
+	Height int    `json:"height"` // CSS pixels
+}
+
+// Presets are the viewports offered when none are configured.
+var Presets = []Viewport{
+	{Name: "mobile", Width: 375, Height: 667},
+	{Name: "tablet", Width: 768, Height: 1024},
+	{Name: "desktop", Width: 1280, Height: 800},
+}
+
+// size matches a custom size, "<width>x<height>".
+var size = regexp.MustCompile(`^([0-9]{1,5})x([0-9]{1,5})$`)
+
+// name matches a preset name; it cannot be mistaken for a size.
+var name = regexp.MustCompile(`^[a-z][-a-z0-9]*$`)
+
+// Lookup returns the viewport a query value selects: the preset of that name, or a custom
+// size within MinSize and MaxSize. Anything else, "" included, selects none.
+func Lookup(value string, presets []Viewport) (Viewport, bool) {
+	for _, preset := range presets {
+		if preset.Name == value {
+			return preset, true
+		}
+	}
+	if match := size.FindStringSubmatch(value); match != nil {
+		width, _ := strconv.Atoi(match[1])
+		height, _ := strconv.Atoi(match[2])
+		if inBounds(width, height) {
+			return Viewport{Name: strconv.Itoa(width) + "x" + strconv.Itoa(height), Width: width, Height: height}, true
+		}
+	}
+	return Viewport{}, false
+}
+
+// Label is the viewport's toolbar text, e.g. "Mobile 375×667", or "390×844" for a custom size.
+func (v Viewport) Label() string {
+	dimensions := fmt.Sprintf("%d×%d", v.Width, v.Height)
+	if v.Name == "" || size.MatchString(v.Name) {
+		return dimensions
+	}
+	return strings.ToUpper(v.Name[:1]) + v.Name[1:] + " " + dimensions
+}
+
+// Check reports what is wrong with a preset: its name, which must not look like a custom
+// size, or its size.
+func (v Viewport) Check() error {
+	if !name.MatchString(v.Name) {
+		return fmt.Errorf("%q is not a valid viewport name", v.Name)
+	}
+	if !inBounds(v.Width, v.Height) {
+		return fmt.Errorf("viewport %s: %dx%d is outside %d to %d pixels", v.Name, v.Width, v.Height, MinSize, MaxSize)
+	}
+	return nil
+}
+
+func inBounds(width, height int) bool {
+	return width >= MinSize && width <= MaxSize && height >= MinSize && height <= MaxSize
+}
+
+// Parse parses a preset as written in a flag or an environment variable: "name=WxH", e.g.
+// "mobile=375x667".
+func Parse(preset string) (Viewport, error) {
+	presetName, dimensions, ok := strings.Cut(preset, "=")
+	match := size.FindStringSubmatch(strings.TrimSpace(dimensions))
+	if !ok || match == nil {
+		return Viewport{}, fmt.Errorf("%q is not name=WIDTHxHEIGHT", preset)
+	}
+	width, _ := strconv.Atoi(match[1])
+	height, _ := strconv.Atoi(match[2])
+	return Viewport{Name: strings.TrimSpace(presetName), Width: width, Height: height}, nil
+}
+
+// String formats a preset for Parse.
+func (v Viewport) String() string {
+	return fmt.Sprintf("%s=%dx%d", v.Name, v.Width, v.Height)
+}
@@ -0,0 +1,142 @@
+// Package watcher polls directories for file changes.
+// Polling keeps the sandbox free of OS-specific notification dependencies; the directories
+// it watches (components and templates) are small enough for a stat walk every few hundred
+// milliseconds to be cheap.
+package watcher
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// fileState is what a poll remembers about a file to tell whether it changed.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// Watcher polls a set of directory trees and reports files that were added, removed or modified.
+type Watcher struct {
+	Dirs     []string      // Directory trees to watch
+	Interval time.Duration // Time between polls
+	// Ignore, if set, excludes paths from watching (e.g. editor swap files). Directories are
+	// passed too; returning true for a directory skips the whole tree.
+	Ignore func(path string, isDir bool) bool
+
+	files map[string]fileState
+}
+
+// New returns a Watcher for dirs that polls every interval and ignores hidden files and
+// common editor temporary files.
+func New(dirs []string, interval time.Duration) *Watcher {
+	return &Watcher{
+		Dirs:     dirs,
+		Interval: interval,
+		Ignore:   ignoreTemporaryFiles,
+	}
+}
+
+// Run polls until ctx is cancelled. onChange is called from Run's goroutine with the sorted
+// paths that changed since the previous poll. Changes that keep happening across consecutive
+// polls (an editor writing a file in several steps, a git checkout) are batched: onChange is
+// only called once a poll sees no further changes.
+func (w *Watcher) Run(ctx context.Context, onChange func(changed []string)) {
+	w.files = w.scan()
+	log.Printf("Watcher: watching %d files in %v every %s", len(w.files), w.Dirs, w.Interval)
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	pending := make(map[string]bool)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := w.scan()
+		changed := diff(w.files, current)
+		w.files = current
+		for _, path := range changed {
+			pending[path] = true
+		}
+		if len(changed) > 0 || len(pending) == 0 {
+			continue // Wait for the tree to settle before reporting
+		}
+
+		batch := make([]string, 0, len(pending))
+		for path := range pending {
+			batch = append(batch, path)
+		}
+		sort.Strings(batch)
+		pending = make(map[string]bool)
+		onChange(batch)
+	}
+}
+
+// scan stats every file under the watched directories. Directories that do not exist
+// (yet) are skipped silently so they can be created while the sandbox runs.
+func (w *Watcher) scan() map[string]fileState {
+	files := make(map[string]fileState)
+	for _, dir := range w.Dirs {
+		_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil // Vanished between listing and stat, or unreadable: the next poll will tell
+			}
+			if w.Ignore != nil && path != dir && w.Ignore(path, d.IsDir()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			files[path] = fileState{modTime: info.ModTime(), size: info.Size()}
+			return nil
+		})
+	}
+	return files
+}
+
+// diff returns the paths that differ between two scans, sorted.
+func diff(previous, current map[string]fileState) []string {
+	var changed []string
+	for path, state := range current {
+		if old, ok := previous[path]; !ok || !old.modTime.Equal(state.modTime) || old.size != state.size {
+			changed = append(changed, path)
+		}
+	}
+	for path := range previous {
+		if _, ok := current[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// ignoreTemporaryFiles skips dot files and directories and the backup/swap files editors write.
+func ignoreTemporaryFiles(path string, isDir bool) bool {
+	name := filepath.Base(path)
+	if len(name) > 0 && name[0] == '.' {
+		return true
+	}
+	if isDir {
+		return name == "node_modules"
+	}
+	switch filepath.Ext(name) {
+	case ".swp", ".swx", ".tmp":
+		return true
+	}
+	return name[len(name)-1] == '~'
+}
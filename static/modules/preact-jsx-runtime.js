@@ -0,0 +1,17 @@
+// Minimal "preact/jsx-runtime" for modules compiled with esbuild's automatic JSX runtime
+// (.jsx/.tsx files transpiled by the sandbox, see pkg/sandbox/transpile).
+// preact.js is a prebuilt bundle without the jsx-runtime entry point, so this maps the
+// automatic runtime's calls onto createElement.
+import { h, Fragment } from "preact";
+
+/**
+ * Creates a vnode. `props` already contains `children`; `key` is passed separately.
+ * @param {any} type
+ * @param {object} props
+ * @param {string | number} [key]
+ */
+export function jsx(type, props, key) {
+  return h(type, key === undefined ? props : { ...props, key });
+}
+
+export { jsx as jsxs, jsx as jsxDEV, Fragment };
@@ -0,0 +1,10 @@
+// Keeps the args editor's range readouts in step with their sliders. The editor is rendered
+// by the server and replaced by partial navigation, so one listener on the document serves
+// every version of it; an inline oninput handler would need 'unsafe-inline' under the CSP.
+
+document.addEventListener("input", (event) => {
+  const input = event.target;
+  if (!(input instanceof HTMLInputElement) || input.type !== "range" || !input.id) return;
+  const output = document.querySelector(`.story-args-editor output[for="${CSS.escape(input.id)}"]`);
+  if (output) output.value = input.value;
+});
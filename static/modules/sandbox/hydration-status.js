@@ -0,0 +1,33 @@
+// Shows hydration reports in the manager page's toolbar. A story frame in hydrate mode posts
+// its report here (see hydration.js) every time it loads; the toolbar's #hydration-status,
+// rendered by the server with the last known report, is updated in place.
+
+/**
+ * @param {{componentName: string, storyKey: string, mismatches: Array<object>, error: string}} report
+ */
+function showReport(report) {
+  const status = document.getElementById("hydration-status");
+  if (!status) return;
+  const { mismatches, error } = report;
+  let state = "ok";
+  let text = "Hydrated, no mismatches";
+  if (error) {
+    state = "error";
+    text = "Hydration failed";
+  } else if (mismatches.length > 0) {
+    state = "mismatch";
+    text = `${mismatches.length} hydration ${mismatches.length === 1 ? "mismatch" : "mismatches"}`;
+  }
+  status.className = `hydration-status hydration-status--${state}`;
+  status.textContent = text;
+  status.title = error || mismatches
+    .map((m) => `${m.kind}${m.detail ? " " + m.detail : ""} at ${m.path}: ssr ${m.ssr || "-"}, csr ${m.csr || "-"}`)
+    .join("\n");
+}
+
+window.addEventListener("message", (event) => {
+  if (event.origin !== window.location.origin || !event.data || event.data.type !== "sandbox:hydration") return;
+  const iframe = document.getElementById("sandbox-iframe");
+  if (!iframe || event.source !== iframe.contentWindow) return;
+  showReport(event.data);
+});
@@ -0,0 +1,189 @@
+// Hydrate mode (renderMode=hydrate): the frame arrives with the SSR template output in its
+// mount point, and iframe-client.js hydrates the Preact story over it. This module does the
+// hydration and records where Preact's render disagrees with the server markup, in the shape
+// of the server's parity differences (models.ParityDifference): kind, path, detail, ssr, csr.
+//
+// Preact keeps the server's attributes while hydrating and only fixes structure and text, so
+// the checks run in options.diffed, as each vnode is matched to its DOM node: attributes and
+// text against what the server sent, and DOM nodes that Preact had to create. Server nodes
+// Preact removed are found afterwards. `__e` is Preact 10's mangled name for vnode._dom.
+import { hydrate, options } from "preact";
+
+// Props that never become attributes, as in render.js
+const SKIPPED_PROPS = new Set(["children", "key", "ref", "__source", "__self", "dangerouslySetInnerHTML"]);
+
+function collapse(text) {
+  return text.replace(/\s+/g, " ").trim();
+}
+
+function classList(value) {
+  return [...new Set(String(value || "").split(/\s+/).filter(Boolean))].sort();
+}
+
+/**
+ * A CSS-like path of a node below root, e.g. "div.wrap > button:nth-of-type(2)", the format
+ * of the parity report.
+ * @param {Node} node
+ * @param {Element} root
+ * @returns {string}
+ */
+function nodePath(node, root) {
+  const segments = [];
+  for (let current = node; current && current !== root; current = current.parentNode) {
+    if (current.nodeType === Node.TEXT_NODE) {
+      segments.unshift("#text");
+      continue;
+    }
+    let segment = current.localName;
+    if (current.id) segment += "#" + current.id;
+    else if (current.classList.length > 0) segment += "." + classList(current.className)[0];
+    const sameTag = current.parentNode
+      ? [...current.parentNode.children].filter((sibling) => sibling.localName === current.localName)
+      : [];
+    if (sameTag.length > 1) segment += `:nth-of-type(${sameTag.indexOf(current) + 1})`;
+    segments.unshift(segment);
+  }
+  return segments.join(" > ") || "(root)";
+}
+
+function describe(node) {
+  if (node.nodeType === Node.TEXT_NODE) return JSON.stringify(collapse(node.data));
+  const attributes = [...node.attributes].map((a) => ` ${a.name}="${a.value}"`).join("");
+  return `<${node.localName}${attributes}>`;
+}
+
+/**
+ * The attributes a vnode's props stand for, like Preact's setProperty: class and for under
+ * their attribute names, false and null left out (except aria-* and data-*), true as "".
+ * @param {object} props
+ * @returns {Map<string, string>}
+ */
+function expectedAttributes(props) {
+  const attributes = new Map();
+  for (let name in props) {
+    let value = props[name];
+    if (SKIPPED_PROPS.has(name) || value == null || typeof value === "function" || /^on[A-Z]/.test(name)) continue;
+    if (value === false && name[4] !== "-") continue;
+    if (name === "className") name = "class";
+    else if (name === "htmlFor") name = "for";
+    if (name === "style" && typeof value === "object") continue; // Compared as a string only
+    attributes.set(name, value === true ? "" : String(value));
+  }
+  return attributes;
+}
+
+/**
+ * Hydrates vnode over the server markup in root and returns the mismatches.
+ * @param {object} vnode - The story's rendered element
+ * @param {Element} root - The mount point holding the SSR output
+ * @returns {Array<{kind: string, path: string, detail?: string, ssr?: string, csr?: string}>}
+ */
+export function hydrateAndCompare(vnode, root) {
+  const mismatches = [];
+
+  // Everything the server sent, with its text and place before Preact changed them
+  const serverNodes = new Map();
+  const walker = document.createTreeWalker(root, NodeFilter.SHOW_ELEMENT | NodeFilter.SHOW_TEXT);
+  for (let node = walker.nextNode(); node; node = walker.nextNode()) {
+    serverNodes.set(node, { text: node.nodeType === Node.TEXT_NODE ? node.data : null, path: nodePath(node, root) });
+  }
+
+  // Nodes Preact created are not in the document yet when diffed runs; they are found
+  // afterwards, like the removed ones.
+  const checkVNode = (v) => {
+    const dom = v.__e;
+    if (!dom || !serverNodes.has(dom)) return;
+    const server = serverNodes.get(dom);
+    if (v.type === null) {
+      if (collapse(server.text) !== collapse(String(v.props))) {
+        mismatches.push({ kind: "text", path: server.path, ssr: collapse(server.text), csr: collapse(String(v.props)) });
+      }
+      return;
+    }
+    if (typeof v.type !== "string") return;
+
+    const path = server.path;
+    const expected = expectedAttributes(v.props);
+    const serverClasses = classList(dom.getAttribute("class"));
+    const clientClasses = classList(expected.get("class"));
+    if (serverClasses.join(" ") !== clientClasses.join(" ")) {
+      mismatches.push({
+        kind: "class",
+        path,
+        ssr: serverClasses.filter((c) => !clientClasses.includes(c)).join(" "),
+        csr: clientClasses.filter((c) => !serverClasses.includes(c)).join(" "),
+      });
+    }
+    const names = new Set([...dom.getAttributeNames(), ...expected.keys()]);
+    names.delete("class");
+    names.delete("style");
+    for (const name of [...names].sort()) {
+      const ssr = dom.getAttribute(name);
+      const csr = expected.has(name) ? expected.get(name) : null;
+      if (ssr === csr) continue;
+      mismatches.push({
+        kind: "attribute",
+        path,
+        detail: name,
+        ssr: ssr === null ? "(absent)" : JSON.stringify(ssr),
+        csr: csr === null ? "(absent)" : JSON.stringify(csr),
+      });
+    }
+  };
+
+  const previousDiffed = options.diffed;
+  options.diffed = (v) => {
+    checkVNode(v);
+    if (previousDiffed) previousDiffed(v);
+  };
+  try {
+    hydrate(vnode, root);
+  } finally {
+    options.diffed = previousDiffed;
+  }
+
+  // Nodes Preact had to create, reported at the outermost one
+  const hydrated = document.createTreeWalker(root, NodeFilter.SHOW_ELEMENT | NodeFilter.SHOW_TEXT);
+  for (let node = hydrated.nextNode(); node; node = hydrated.nextNode()) {
+    if (serverNodes.has(node) || (node.parentNode !== root && !serverNodes.has(node.parentNode))) continue;
+    if (node.nodeType === Node.TEXT_NODE && collapse(node.data) === "") continue;
+    mismatches.push({ kind: "structure", path: nodePath(node.parentNode, root), detail: "only in CSR", csr: describe(node) });
+  }
+
+  // Server nodes Preact dropped. Only the outermost node of a removed subtree lost its parent;
+  // whitespace between elements is dropped by design and not reported.
+  for (const [node, server] of serverNodes) {
+    if (node.parentNode !== null || root.contains(node)) continue;
+    if (node.nodeType === Node.TEXT_NODE && collapse(server.text) === "") continue;
+    mismatches.push({ kind: "structure", path: server.path, detail: "only in SSR", ssr: describe(node) });
+  }
+  return mismatches;
+}
+
+/**
+ * Sends a hydration report to the sandbox server (when the frame is served by one) and to the
+ * manager page, which shows it in the toolbar.
+ * @param {object} config - The frame's sandbox config
+ * @param {{mismatches: Array<object>, error?: string}} result
+ */
+export function reportHydration(config, { mismatches, error }) {
+  const report = { url: window.location.href, mismatches, error: error || "" };
+  if (mismatches.length > 0) {
+    console.warn(`[Hydration] ${mismatches.length} mismatches between the SSR markup and the Preact render:`, mismatches);
+  } else if (!error) {
+    console.log("[Hydration] The SSR markup matches the Preact render.");
+  }
+  if (config.hydrationReportURL) {
+    fetch(config.hydrationReportURL, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify(report),
+    }).catch((err) => console.warn("[Hydration] Could not send the report:", err));
+  }
+  if (window.parent !== window) {
+    window.parent.postMessage(
+      { type: "sandbox:hydration", componentName: config.componentName, storyKey: config.storyKey, ...report },
+      window.location.origin
+    );
+  }
+}
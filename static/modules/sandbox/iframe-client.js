@@ -1,4 +1,5 @@
 import { render } from "preact";
+import { hydrateAndCompare, reportHydration } from "./hydration.js";
 // import { story } from "../../lib/csf/index.js"; // Remove unused story import if not used directly here
 // import { html } from "htm/preact"; // htm is not used directly in this client, stories use it.
 
@@ -9,7 +10,7 @@ let currentAppliedTheme = null;
 
 /**
  * Applies the theme to the iframe document if it has changed.
- * @param {string} theme - The theme to apply ("light" or "dark").
+ * @param {string} theme - The theme to apply, e.g. "light" or "high-contrast".
  */
 function applyTheme(theme) {
   if (theme === currentAppliedTheme) {
@@ -27,16 +28,12 @@ function applyTheme(theme) {
   const classList = document.documentElement.classList;
   const bodyClassList = document.body.classList;
 
-  if (theme === "dark") {
-    classList.add("dark-theme");
-    classList.remove("light-theme");
-    bodyClassList.add("dark-theme");
-    bodyClassList.remove("light-theme");
-  } else {
-    classList.add("light-theme");
-    classList.remove("dark-theme");
-    bodyClassList.add("light-theme");
-    bodyClassList.remove("dark-theme");
+  // Any registered theme: drop the other "*-theme" classes, the server has validated this one
+  for (const list of [classList, bodyClassList]) {
+    for (const name of [...list]) {
+      if (name.endsWith("-theme") && name !== `${theme}-theme`) list.remove(name);
+    }
+    list.add(`${theme}-theme`);
   }
   currentAppliedTheme = theme;
   console.log(`iframe-client: Theme applied - ${theme}`);
@@ -160,12 +157,15 @@ function displayErrorInIframe(mountPoint, title, details, errorObject) {
  * @param {string} payload.storyModulePath
  * @param {string} payload.componentName
  * @param {object} payload.args
+ * @param {object} [payload.hydrateConfig] - In hydrate mode, the frame's config: the story is
+ *   hydrated over the server markup in the mount point and the mismatches are reported
  */
 async function loadAndRenderStory({
   storyKey,
   storyModulePath,
   componentName,
   args,
+  hydrateConfig,
 }) {
   console.log(
     `iframe-client: Rendering story: '${storyKey}' from '${storyModulePath}'. Args:`,
@@ -181,14 +181,15 @@ async function loadAndRenderStory({
     return;
   }
 
-  // **Clear loading message before attempting to load/render**
-  mountPoint.innerHTML = "";
+  // **Clear loading message before attempting to load/render**; hydrate mode keeps the SSR markup
+  if (!hydrateConfig) mountPoint.innerHTML = "";
 
   try {
     // A brief pause might not be necessary if the DOM is stable.
     // await new Promise(resolve => setTimeout(resolve, 0));
 
-    const module = await import(storyModulePath);
+    // Resolved against the page, not this module: a static export writes relative paths
+    const module = await import(new URL(storyModulePath, document.baseURI).href);
     console.log(
       `iframe-client: Module '${storyModulePath}' loaded for story '${storyKey}':`,
       module
@@ -216,7 +217,15 @@ async function loadAndRenderStory({
     }
 
     // --- Success Path ---
-    const storyElement = storyObject.render(storyObject.args);
+    // Component-level args from the default export are inherited by the story, and the args
+    // from the server (defaults merged with the URL) take precedence over the module's own.
+    // Arrays and objects arrive already decoded from the JSON sandbox config.
+    const componentArgs = (module.default && module.default.args) || {};
+    const storyElement = storyObject.render({
+      ...componentArgs,
+      ...(storyObject.args || {}),
+      ...args,
+    });
 
     if (storyElement === undefined || storyElement === null) {
       console.warn(
@@ -224,8 +233,13 @@ async function loadAndRenderStory({
       );
     }
 
-    // Use Preact's render function directly on the mount point
-    render(storyElement, mountPoint); // Render the VNode (or null/undefined) into the mount point
+    if (hydrateConfig) {
+      const mismatches = hydrateAndCompare(storyElement, mountPoint);
+      reportHydration(hydrateConfig, { mismatches });
+    } else {
+      // Use Preact's render function directly on the mount point
+      render(storyElement, mountPoint); // Render the VNode (or null/undefined) into the mount point
+    }
 
     console.log(`iframe-client: Story '${storyKey}' successfully rendered.`);
     // --- End Success Path ---
@@ -248,6 +262,9 @@ async function loadAndRenderStory({
       details = `Could not load the story module '${storyModulePath}'. Check the file path and network requests.`;
     }
 
+    if (hydrateConfig) {
+      reportHydration(hydrateConfig, { mismatches: [], error: `${title}: ${err.message}` });
+    }
     displayErrorInIframe(mountPoint, title, details, err);
     // --- End Error Path ---
   }
@@ -314,9 +331,6 @@ function initializeFromConfig() {
     }
     return;
   }
-  // Clear any initial content (e.g., "Loading story...") from mountPoint
-  mountPoint.innerHTML = "";
-
   const configElement = document.getElementById("sandbox-config");
   if (!configElement) {
     console.error("iframe-client: #sandbox-config script tag not found.");
@@ -337,6 +351,11 @@ function initializeFromConfig() {
       throw new Error("Parsed configuration is not a valid object.");
     }
 
+    // Clear any initial content (e.g., "Loading story...") from mountPoint, unless it is the
+    // server markup to hydrate
+    const hydrating = config.renderMode === "hydrate";
+    if (!hydrating) mountPoint.innerHTML = "";
+
     const theme = config.theme || "light";
     applyTheme(theme);
 
@@ -361,6 +380,7 @@ function initializeFromConfig() {
       storyModulePath: config.storyModulePath,
       componentName: config.componentName, // Used for logging/context
       args: config.currentArgs || {}, // Args for the story
+      hydrateConfig: hydrating ? config : undefined,
     });
   } catch (err) {
     console.error(
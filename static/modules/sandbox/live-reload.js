@@ -0,0 +1,59 @@
+// Live reload client. Listens to the sandbox's Server-Sent Events stream (/sandbox-events)
+// and re-renders the current story when the server has reloaded changed files.
+//
+// Loaded by the manager page and by the story frames. Inside the manager's iframe it stays
+// idle: the manager reloads the frame itself, so each tab holds a single event connection.
+// A frame opened on its own (e.g. /sandbox-content/button/Default in a new tab) connects directly.
+
+// Resolved from this module's URL (<base>/static/modules/sandbox/), so the stream is found
+// when the sandbox is mounted under a path prefix.
+const EVENTS_URL = new URL("../../../sandbox-events", import.meta.url);
+const isEmbeddedFrame = window.parent !== window;
+
+/**
+ * Reloads the story iframe in place, keeping its current URL (render mode, theme and args).
+ * Falls back to reloading the page if the manager has no story frame (e.g. the diagnostics page).
+ */
+function reloadStoryFrame() {
+  const iframe = document.getElementById("sandbox-iframe");
+  if (!iframe || !iframe.contentWindow) {
+    window.location.reload();
+    return;
+  }
+  iframe.contentWindow.location.reload();
+}
+
+function connect() {
+  const source = new EventSource(EVENTS_URL);
+  let serverId = null;
+
+  source.addEventListener("hello", (event) => {
+    const { serverId: newServerId } = JSON.parse(event.data);
+    // EventSource reconnects by itself; a different ID means the server was restarted
+    // while we were disconnected, so whatever we show may be stale.
+    if (serverId !== null && serverId !== newServerId) {
+      console.log("[LiveReload] Server restarted, reloading.");
+      window.location.reload();
+      return;
+    }
+    serverId = newServerId;
+  });
+
+  source.addEventListener("reload", (event) => {
+    const { version, scope, changed } = JSON.parse(event.data);
+    console.log(`[LiveReload] Reload #${version} (${scope}):`, changed);
+    if (scope === "page") {
+      window.location.reload();
+      return;
+    }
+    reloadStoryFrame();
+  });
+
+  source.addEventListener("error", () => {
+    console.log("[LiveReload] Connection lost, retrying.");
+  });
+}
+
+if (!isEmbeddedFrame && typeof EventSource !== "undefined") {
+  connect();
+}
@@ -0,0 +1,75 @@
+// Partial navigation for links that name the regions of the page they change, e.g.
+//
+//   <a href="/sandbox/button/primary" data-mach-target="nav, toolbar, iframe, args-editor">
+//
+// The link is fetched with the X-Mach protocol headers (see pkg/sandbox/api/mach.go) and only
+// those regions are replaced. Without JavaScript it is a plain link to the full page.
+
+const MACH_HEADERS = {
+  request: "X-Mach-Request",
+  target: "X-Mach-Target",
+  nonce: "X-Mach-Nonce",
+  title: "X-Mach-Title",
+  pushURL: "X-Mach-Push-URL",
+  redirect: "X-Mach-Redirect",
+};
+
+document.addEventListener("click", (event) => {
+  if (event.defaultPrevented || event.button !== 0) return;
+  if (event.metaKey || event.ctrlKey || event.shiftKey || event.altKey) return;
+  const link = event.target instanceof Element ? event.target.closest("a[data-mach-target]") : null;
+  if (!link || link.origin !== location.origin || link.target) return;
+
+  event.preventDefault();
+  navigate(link.href, link.dataset.machTarget).catch((error) => {
+    console.error("[MachTargets] Partial navigation failed, loading the page:", error);
+    location.assign(link.href);
+  });
+});
+
+// The regions of earlier history entries are not kept: going back loads the page.
+window.addEventListener("popstate", (event) => {
+  if (event.state?.machTargets) location.reload();
+});
+
+// The page's CSP nonce: the fragments' inline styles and scripts must carry it to be allowed.
+const nonce = document.querySelector('meta[name="mach-nonce"]')?.content ?? "";
+
+async function navigate(url, targets) {
+  const headers = { [MACH_HEADERS.request]: "true", [MACH_HEADERS.target]: targets };
+  if (nonce) headers[MACH_HEADERS.nonce] = nonce;
+  const response = await fetch(url, {
+    headers,
+    credentials: "same-origin",
+  });
+  const redirect = response.headers.get(MACH_HEADERS.redirect);
+  if (redirect) {
+    location.assign(redirect);
+    return;
+  }
+  if (!response.ok) {
+    throw new Error(`${response.status} ${response.statusText}`);
+  }
+
+  const template = document.createElement("template");
+  template.innerHTML = await response.text();
+  for (const fragment of template.content.querySelectorAll(":scope > [data-mach-target-selector]")) {
+    const region = document.querySelector(fragment.dataset.machTargetSelector);
+    if (region) {
+      region.replaceChildren(...fragment.childNodes);
+    } else {
+      console.warn(`[MachTargets] No element for region "${fragment.dataset.machRegion}".`);
+    }
+  }
+
+  const title = response.headers.get(MACH_HEADERS.title);
+  if (title) document.title = title;
+  const pushURL = response.headers.get(MACH_HEADERS.pushURL);
+  if (pushURL) {
+    if (!history.state?.machTargets) {
+      history.replaceState({ machTargets: "page" }, "");
+    }
+    history.pushState({ machTargets: targets }, "", pushURL);
+  }
+  document.dispatchEvent(new CustomEvent("mach:contentupdated", { detail: { url, targets } }));
+}
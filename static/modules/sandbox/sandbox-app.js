@@ -12,6 +12,18 @@ import {
 } from "/static/modules/sandbox/iframe-manager.js";
 import { loadStory } from "./story-loader.js";
 
+// Path the sandbox is mounted under ("" at the root), from this module's URL
+// (<base>/static/modules/sandbox/sandbox-app.js).
+const SANDBOX_BASE_PATH = new URL("../../..", import.meta.url).pathname.replace(/\/$/, "");
+
+// The query parameters the frame reads itself; args of these names go under "args.", as in
+// the Go args package (args.Param).
+const RESERVED_PARAMS = ["renderMode", "theme", "viewport", "componentName", "storyKey"];
+
+function argParam(name) {
+  return RESERVED_PARAMS.includes(name) || name.startsWith("args.") ? "args." + name : name;
+}
+
 let disposeUrlSyncEffect = null;
 let disposeIframeSrcUpdateEffect = null;
 
@@ -102,7 +114,7 @@ async function initializeApp() {
 
       // --- Construct the new iframe src URL with dynamic path ---
       // ComponentName and storyKey are read from the config closure variable
-      let newPath = "/sandbox-content/" + componentName;
+      let newPath = SANDBOX_BASE_PATH + "/sandbox-content/" + componentName;
       if (storyKey) {
         // storyKey might be empty/null from config
         newPath += "/" + storyKey;
@@ -113,8 +125,14 @@ async function initializeApp() {
       newSrcParams.set("theme", currentTheme);
       // componentName and storyKey are now in the path, not query params
       // Add all current args to the query string
+      // Arrays and objects are JSON encoded, matching what the Go handlers decode.
       for (const [key, value] of Object.entries(currentArgs)) {
-        newSrcParams.set(key, String(value));
+        newSrcParams.set(
+          argParam(key),
+          value !== null && typeof value === "object"
+            ? JSON.stringify(value)
+            : String(value)
+        );
       }
 
       const newSrc = `${newPath}?${newSrcParams.toString()}`; // Combine path and query
@@ -168,7 +186,7 @@ async function initializeApp() {
     if (iframe) {
       try {
         const errorDisplayHTML = `<p><em>Parent App Error: ${error.message}. Check console for details.</em></p>`;
-        iframe.srcdoc = `<!DOCTYPE html><html><head><title>Error</title><link rel="stylesheet" href="/static/styles/global.css"/><style>body{padding:var(--space-5);}</style></head><body>${errorDisplayHTML}</body></html>`;
+        iframe.srcdoc = `<!DOCTYPE html><html><head><title>Error</title><link rel="stylesheet" href="/static/styles/global.css"/></head><body style="padding:var(--space-5);">${errorDisplayHTML}</body></html>`;
       } catch (displayError) {
         console.error(
           "[SandboxApp] Could not display error in iframe:",
@@ -10,17 +10,20 @@ export async function loadStory(storyModulePath, storyKey) {
     `[StoryLoader] Attempting to load story: ${storyKey} from module: ${storyModulePath}`
   );
   try {
-    const module = await import(storyModulePath);
+    // Resolved against the page, not this module: a static export writes relative paths
+    const module = await import(new URL(storyModulePath, document.baseURI).href);
     if (
       module &&
       module[storyKey] &&
-      typeof module[storyKey].args === "object"
+      typeof (module[storyKey].args ?? {}) === "object"
     ) {
       console.log(
         `[StoryLoader] Successfully loaded module and found story '${storyKey}'.`
       );
+      // Story args override the component-level args of the default export.
+      const componentArgs = (module.default && module.default.args) || {};
       const storyDefaultArgs = JSON.parse(
-        JSON.stringify(module[storyKey].args)
+        JSON.stringify({ ...componentArgs, ...module[storyKey].args })
       );
       return { storyDefaultArgs, module }; // Returning module for now, might be refined later
     } else {
@@ -0,0 +1,10 @@
+// Applies the toolbar's theme select as soon as it changes. The select is part of a GET form
+// that works without JavaScript; submitting it through requestSubmit lets mach-form swap the
+// body in place. The toolbar is replaced by partial navigation, so the listener is on the
+// document.
+
+document.addEventListener("change", (event) => {
+  const select = event.target;
+  if (!(select instanceof HTMLSelectElement) || !select.hasAttribute("data-theme-select")) return;
+  select.form?.requestSubmit();
+});